@@ -2,9 +2,14 @@ package app
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
 
 	"gophermart/internal/config"
 	db "gophermart/internal/database"
+	"gophermart/internal/models"
 	"gophermart/internal/services"
 	transport "gophermart/internal/transport/handlers"
 	"net/http"
@@ -23,9 +28,12 @@ type Server struct {
 	mux     *chi.Mux
 	storage Storager
 	logger  *zap.SugaredLogger
+	events  *services.OrderEventBus
+	outbox  *services.OutboxSubscribers
 }
 
 var _ Storager = &db.Storage{}
+var _ Storager = &db.MemoryStorage{}
 
 type Storager interface {
 	db.StoragerDB
@@ -36,30 +44,119 @@ func New(ctx context.Context, config *config.Config) *Server {
 	return &Server{
 		ctx:    ctx,
 		config: config,
+		outbox: services.NewOutboxSubscribers(),
 	}
 }
 
+// OutboxSubscribers - подписка на доменные события внутри процесса.
+func (s *Server) OutboxSubscribers() *services.OutboxSubscribers {
+	return s.outbox
+}
+
+// outboxSinks - sinks relay по конфигурации.
+func (s *Server) outboxSinks() []services.OutboxSink {
+
+	sinks := []services.OutboxSink{s.outbox}
+	if s.config.OutboxLogSink {
+		sinks = append(sinks, services.NewLogSink(s.logger))
+	}
+	if s.config.OutboxHTTPSinkURL != "" {
+		sinks = append(sinks, services.NewHTTPSink(s.config.OutboxHTTPSinkURL, s.config.OutboxHTTPSinkTimeout))
+	}
+	return sinks
+}
+
+// newStorage выбирает хранилище по DATABASE_URI: memory:// - данные в памяти процесса.
+func (s *Server) newStorage(ctx context.Context) (Storager, error) {
+
+	if db.IsMemoryURI(s.config.DatabaseURI) {
+		s.logger.Info("данные хранятся в памяти и будут потеряны при остановке сервера")
+		return db.NewMemory(s.logger), nil
+	}
+	retry := db.RetryPolicy{
+		MaxAttempts: s.config.DBRetryMaxAttempts,
+		BaseDelay:   s.config.DBRetryBaseDelay,
+		MaxDelay:    s.config.DBRetryMaxDelay,
+		Budget:      s.config.DBRetryBudget,
+	}
+	pool := db.PoolOptions{
+		MaxConns:          s.config.DBMaxConns,
+		MinConns:          s.config.DBMinConns,
+		MaxConnLifetime:   s.config.DBMaxConnLifetime,
+		HealthCheckPeriod: s.config.DBHealthCheckPeriod,
+	}
+	return db.New(ctx, s.config.DatabaseURI, s.config.MigrationsPath, s.logger, retry, pool, s.config.StatusBatchSize)
+}
+
 func (s *Server) Start(ctx context.Context, logger *zap.SugaredLogger, wg *sync.WaitGroup) error {
 
 	s.logger = logger
-	storage, err := db.New(ctx, s.config.DatabaseURI, s.config.MigrationsPath, logger)
+	storage, err := s.newStorage(ctx)
 	if err != nil {
 		return err
 	}
 	s.storage = storage
+	s.events = services.NewOrderEventBus()
+	s.promoteAdmins(ctx)
 
-	s.mux = s.ConfigureMux()
+	s.mux, err = s.ConfigureMux()
+	if err != nil {
+		return err
+	}
 
 	s.server = &http.Server{
 		Addr:    s.config.RunAdress,
 		Handler: s.mux,
 	}
 	s.logger.Info("адрес сервера: " + s.config.RunAdress)
-	a := services.NewAccrual(s.config.AccrualSysremAdress, s.config.AccrualRequestInterval, s.config.AccuralPuttingDBInterval, s.storage, s.logger, s.config.NumberOfWorkers)
+	a := services.NewAccrual(s.config.AccrualSysremAdress, s.config.AccrualRequestInterval, s.config.AccuralPuttingDBInterval, s.storage, s.logger, s.config.NumberOfWorkers, s.config.UnregisteredOrderTTL,
+		services.LeaseOptions{
+			InstanceID: s.config.InstanceID,
+			TTL:        s.config.OrderLeaseTTL,
+			BatchSize:  s.config.OrderLeaseBatchSize,
+		})
 
 	wg.Add(1)
 	go a.RunAccrualRequester(ctx, wg)
 
+	relay := services.NewOutboxRelay(s.storage, s.logger, services.OutboxOptions{
+		InstanceID:      s.config.InstanceID,
+		PollInterval:    s.config.OutboxPollInterval,
+		BatchSize:       s.config.OutboxBatchSize,
+		LeaseTTL:        s.config.OutboxLeaseTTL,
+		RetryBaseDelay:  s.config.OutboxRetryBaseDelay,
+		RetryMaxDelay:   s.config.OutboxRetryMaxDelay,
+		Retention:       s.config.OutboxRetention,
+		CleanupInterval: s.config.OutboxCleanupPeriod,
+	}, s.outboxSinks()...)
+	wg.Add(1)
+	go relay.RunOutboxRelay(ctx, wg)
+
+	wg.Add(1)
+	go s.events.RunOrderEventListener(ctx, s.storage, s.logger, wg)
+
+	d := services.NewWebhookDeliverer(s.storage, s.logger, services.WebhookOptions{
+		InstanceID:      s.config.InstanceID,
+		PollInterval:    s.config.WebhookPollInterval,
+		Timeout:         s.config.WebhookTimeout,
+		MaxAttempts:     s.config.WebhookMaxAttempts,
+		BaseDelay:       s.config.WebhookRetryBaseDelay,
+		MaxDelay:        s.config.WebhookRetryMaxDelay,
+		BatchSize:       s.config.WebhookBatchSize,
+		Retention:       s.config.WebhookRetention,
+		CleanupInterval: s.config.WebhookCleanupPeriod,
+	})
+	wg.Add(1)
+	go d.RunWebhookDeliverer(ctx, wg)
+
+	j := services.NewIdempotencyJanitor(s.storage, s.logger, s.config.IdempotencyKeyTTL, s.config.IdempotencyCleanupPeriod)
+	wg.Add(1)
+	go j.RunIdempotencyJanitor(ctx, wg)
+
+	tj := services.NewTokenJanitor(s.storage, s.logger, s.config.TokenCleanupPeriod, s.config.LoginAttemptWindow)
+	wg.Add(1)
+	go tj.RunTokenJanitor(ctx, wg)
+
 	return s.server.ListenAndServe()
 }
 
@@ -76,26 +173,168 @@ func (s *Server) Close() error {
 	return nil
 }
 
-func (s *Server) ConfigureMux() *chi.Mux {
+// promoteAdmins назначает роль admin логинам из AdminLogins: так появляется первый администратор.
+// Незарегистрированные логины пропускаются, роль им назначится при следующем запуске.
+func (s *Server) promoteAdmins(ctx context.Context) {
+
+	for _, login := range strings.Split(s.config.AdminLogins, ",") {
+		login = strings.TrimSpace(login)
+		if login == "" {
+			continue
+		}
+		err := s.storage.SetUserRole(ctx, login, models.RoleAdmin)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			s.logger.Infof("администратор %s еще не зарегистрирован", login)
+		case err != nil:
+			s.logger.Errorf("ошибка назначения администратора %s: %w", login, err)
+		default:
+			s.logger.Infof("%s - администратор", login)
+		}
+	}
+}
+
+// newAuthToken - подпись токенов ключами из JWTKeysDir или, если каталог не задан, общим секретом Key.
+func (s *Server) newAuthToken() (*jwtpackage.Token, error) {
+
+	if s.config.JWTKeysDir == "" {
+		return jwtpackage.NewToken(s.config.AccessTokenExp, s.config.Key), nil
+	}
+	keys, err := jwtpackage.LoadKeyRing(s.config.JWTKeysDir, s.config.JWTCurrentKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка загрузки ключей JWT: %w", err)
+	}
+	s.logger.Infof("токены подписываются ключом %s (%s)", keys.Current().ID, keys.Current().Method.Alg())
+	return jwtpackage.NewTokenWithKeys(s.config.AccessTokenExp, keys), nil
+}
+
+func (s *Server) ConfigureMux() (*chi.Mux, error) {
 
 	router := chi.NewRouter()
 	handler := transport.New(s.ctx, s.storage, s.logger)
-	handler.AuthToken = *jwtpackage.NewToken(s.config.TokenExp, s.config.Key)
+	authToken, err := s.newAuthToken()
+	if err != nil {
+		return nil, err
+	}
+	handler.AuthToken = *authToken
+	handler.RefreshTokenExp = s.config.RefreshTokenExp
+	handler.LoginPolicy = db.LockoutPolicy{
+		FreeAttempts:     s.config.LoginFreeAttempts,
+		BaseDelay:        s.config.LoginBaseDelay,
+		MaxDelay:         s.config.LoginMaxDelay,
+		LockoutThreshold: s.config.LoginLockoutThreshold,
+		LockoutDuration:  s.config.LoginLockoutDuration,
+		Window:           s.config.LoginAttemptWindow,
+	}
+	handler.IPLoginPolicy = handler.LoginPolicy
+	handler.IPLoginPolicy.FreeAttempts = s.config.LoginIPFreeAttempts
+	handler.IPLoginPolicy.LockoutThreshold = s.config.LoginIPLockoutThreshold
+	handler.TrustProxyHeaders = s.config.TrustProxyHeaders
+
+	policy := &services.PasswordPolicy{
+		MinLength:  s.config.PasswordMinLength,
+		MaxLength:  s.config.PasswordMaxLength,
+		MinClasses: s.config.PasswordMinClasses,
+	}
+	if s.config.PasswordBreachedList != "" {
+		if err := policy.LoadBreachedPasswords(s.config.PasswordBreachedList); err != nil {
+			return nil, fmt.Errorf("ошибка загрузки списка утекших паролей: %w", err)
+		}
+		s.logger.Infof("загружено утекших паролей: %d", policy.Breached())
+	}
+	handler.PasswordPolicy = policy
+	handler.PasswordResetTokenTTL = s.config.PasswordResetTokenTTL
+	notifier, err := services.NewNotifier(s.config.NotifierType, s.config.NotifierFilePath, s.logger)
+	if err != nil {
+		return nil, err
+	}
+	handler.Notifier = notifier
+
+	// Key не подходит: он общий для всех по умолчанию и при ключах из JWTKeysDir не нужен,
+	// а дамп базы вместе с ним выдал бы второй фактор всех пользователей
+	if s.config.TOTPEncryptionKey == "" {
+		s.logger.Info("не задан TOTP_ENCRYPTION_KEY, 2FA недоступна")
+	} else {
+		totpBox, err := services.NewSecretBox(s.config.TOTPEncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка настройки шифрования секретов 2FA: %w", err)
+		}
+		handler.TOTPBox = totpBox
+	}
+	if s.config.TOTPIssuer != "" {
+		handler.TOTPIssuer = s.config.TOTPIssuer
+	}
+	handler.MFAChallengeTTL = s.config.MFAChallengeTTL
+	handler.MFAMaxAttempts = s.config.MFAMaxAttempts
+
+	hasher, err := services.NewPasswordHasher(s.config.PasswordHashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	handler.Hasher = hasher
+	handler.Events = s.events
+	handler.HeartbeatInterval = s.config.SSEHeartbeatInterval
 
 	router.Route("/", func(r chi.Router) {
 
+		r.Get("/.well-known/jwks.json", handler.GetJWKS) //открытые ключи для проверки токенов другими сервисами
+
 		r.Post("/api/user/register", handler.Registration)
 		r.Post("/api/user/login", handler.Login)
+		r.Post("/api/user/login/2fa", handler.LoginSecondFactor)                  //второй шаг входа: код TOTP или код восстановления
+		r.Post("/api/user/token/refresh", handler.RefreshToken)                   //новая пара токенов по refresh-токену
+		r.Post("/api/user/logout", handler.AuthMiddleware(handler.Logout))        //выход из текущей сессии
+		r.Post("/api/user/logout/all", handler.AuthMiddleware(handler.LogoutAll)) //выход из всех сессий
+
+		r.Post("/api/user/password", handler.AuthMiddleware(handler.ChangePassword)) //смена пароля, остальные сессии отзываются
+		r.Post("/api/user/password/reset", handler.RequestPasswordReset)             //отправка токена сброса пароля
+		r.Post("/api/user/password/reset/confirm", handler.ConfirmPasswordReset)     //новый пароль по токену сброса
 
-		r.Post("/api/user/orders", handler.AuthMiddleware(handler.UploadOrders))     //загрузка пользователем номера заказа для расчёта;
-		r.Get("/api/user/orders", handler.AuthMiddleware(handler.GetUploadedOrders)) //получение списка загруженных пользователем номеров заказов, статусов их обработки и информации о начислениях
+		r.Post("/api/user/2fa/totp", handler.AuthMiddleware(handler.EnrollTOTP))                        //новый секрет TOTP
+		r.Post("/api/user/2fa/totp/confirm", handler.AuthMiddleware(handler.ConfirmTOTP))               //включение 2FA первым кодом, коды восстановления
+		r.Delete("/api/user/2fa", handler.AuthMiddleware(handler.DisableTwoFactor))                     //отключение 2FA
+		r.Post("/api/user/2fa/recovery-codes", handler.AuthMiddleware(handler.RegenerateRecoveryCodes)) //новые коды восстановления
+		r.Put("/api/user/2fa/withdrawals", handler.AuthMiddleware(handler.SetWithdrawalTwoFactor))      //требовать код 2FA для списаний
 
-		r.Get("/api/user/balance", handler.AuthMiddleware(handler.GetBalance))                //получение текущего баланса счёта баллов лояльности пользователя
-		r.Post("/api/user/balance/withdraw", handler.AuthMiddleware(handler.WithdrawBalance)) //Запрос на списание средств
+		r.Post("/api/user/orders", handler.AuthMiddleware(handler.IdempotencyMiddleware(handler.UploadOrders))) //загрузка пользователем номера заказа для расчёта;
+		r.Get("/api/user/orders", handler.AuthMiddleware(handler.GetUploadedOrders))                            //получение списка загруженных пользователем номеров заказов, статусов их обработки и информации о начислениях
+		r.Get("/api/user/orders/stream", handler.AuthMiddleware(handler.StreamOrders))                          //поток изменений статусов заказов (SSE)
+		r.Get("/api/user/orders/{number}", handler.AuthMiddleware(handler.GetOrder))                            //заказ пользователя с историей статусов
+
+		r.Get("/api/user/balance", handler.AuthMiddleware(handler.GetBalance))                                                                               //получение текущего баланса счёта баллов лояльности пользователя
+		r.Post("/api/user/balance/withdraw", handler.AuthMiddleware(handler.IdempotencyMiddleware(handler.WithdrawalSecondFactor(handler.WithdrawBalance)))) //Запрос на списание средств
+		r.Get("/api/user/balance/history", handler.AuthMiddleware(handler.GetBalanceHistory))                                                                //движения баллов с корректировками и возвратами
 
 		r.Get("/api/user/withdrawals", handler.AuthMiddleware(handler.GetWithdrawals)) //Получение информации о выводе средств
 
+		r.Post("/api/user/webhooks", handler.AuthMiddleware(handler.AddWebhook))                          //регистрация адреса для событий начислений и списаний
+		r.Get("/api/user/webhooks", handler.AuthMiddleware(handler.GetWebhooks))                          //список webhook'ов пользователя
+		r.Delete("/api/user/webhooks/{id}", handler.AuthMiddleware(handler.DeleteWebhook))                //удаление webhook'а
+		r.Get("/api/user/webhooks/{id}/deliveries", handler.AuthMiddleware(handler.GetWebhookDeliveries)) //журнал доставок событий на webhook
+
+		r.Route("/api/admin", func(r chi.Router) {
+
+			staff := func(next http.HandlerFunc) http.HandlerFunc {
+				return handler.AuthMiddleware(handler.RequireRole(models.RoleSupport, models.RoleAdmin)(next))
+			}
+			admin := func(next http.HandlerFunc) http.HandlerFunc {
+				return handler.AuthMiddleware(handler.RequireRole(models.RoleAdmin)(next))
+			}
+
+			r.Get("/users/{login}", staff(handler.GetUserProfile))                 //роль, блокировка и баланс пользователя
+			r.Get("/users/{login}/orders", staff(handler.GetUserOrders))           //заказы пользователя
+			r.Get("/users/{login}/ledger", staff(handler.GetUserLedger))           //движения баллов пользователя
+			r.Delete("/users/{login}/lockout", staff(handler.UnlockLogin))         //снятие блокировки входа после подбора пароля
+			r.Put("/users/{login}/role", admin(handler.SetUserRole))               //назначение роли
+			r.Post("/users/{login}/block", admin(handler.BlockUser))               //блокировка аккаунта
+			r.Delete("/users/{login}/block", admin(handler.UnblockUser))           //снятие блокировки аккаунта
+			r.Post("/users/{login}/adjustments", admin(handler.AdjustBalance))     //ручная корректировка баланса
+			r.Get("/users/{login}/adjustments", staff(handler.GetUserAdjustments)) //корректировки и возвраты с причинами
+			r.Post("/users/{login}/refunds", admin(handler.RefundWithdrawal))      //возврат списанных за заказ баллов
+			r.Get("/db/stats", admin(handler.GetDBStats))                          //состояние пула соединений с БД для мониторинга
+		})
+
 	})
 
-	return router
+	return router, nil
 }
@@ -2,6 +2,7 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"gophermart/utils"
 	"log"
 	"os"
@@ -27,9 +28,69 @@ type Config struct {
 	DatabaseURI              string
 	LoggerLevel              string
 	Key                      string
-	TokenExp                 time.Duration
+	JWTKeysDir               string
+	JWTCurrentKeyID          string
+	LoginFreeAttempts        int
+	LoginIPFreeAttempts      int
+	LoginBaseDelay           time.Duration
+	LoginMaxDelay            time.Duration
+	LoginLockoutThreshold    int
+	LoginIPLockoutThreshold  int
+	LoginLockoutDuration     time.Duration
+	LoginAttemptWindow       time.Duration
+	TrustProxyHeaders        bool
+	AdminLogins              string
+	PasswordMinLength        int
+	PasswordMaxLength        int
+	PasswordMinClasses       int
+	PasswordBreachedList     string
+	PasswordResetTokenTTL    time.Duration
+	NotifierType             string
+	NotifierFilePath         string
+	TOTPEncryptionKey        string
+	TOTPIssuer               string
+	MFAChallengeTTL          time.Duration
+	MFAMaxAttempts           int
+	AccessTokenExp           time.Duration
+	RefreshTokenExp          time.Duration
+	TokenCleanupPeriod       time.Duration
 	MigrationsPath           string
 	NumberOfWorkers          int
+	PasswordHashAlgorithm    string
+	UnregisteredOrderTTL     time.Duration
+	InstanceID               string
+	OrderLeaseTTL            time.Duration
+	OrderLeaseBatchSize      int
+	IdempotencyKeyTTL        time.Duration
+	IdempotencyCleanupPeriod time.Duration
+	DBRetryMaxAttempts       int
+	DBRetryBaseDelay         time.Duration
+	DBRetryMaxDelay          time.Duration
+	DBRetryBudget            time.Duration
+	DBMaxConns               int32
+	DBMinConns               int32
+	DBMaxConnLifetime        time.Duration
+	DBHealthCheckPeriod      time.Duration
+	StatusBatchSize          int
+	SSEHeartbeatInterval     time.Duration
+	WebhookPollInterval      time.Duration
+	WebhookTimeout           time.Duration
+	WebhookMaxAttempts       int
+	WebhookRetryBaseDelay    time.Duration
+	WebhookRetryMaxDelay     time.Duration
+	WebhookBatchSize         int
+	WebhookRetention         time.Duration
+	WebhookCleanupPeriod     time.Duration
+	OutboxPollInterval       time.Duration
+	OutboxBatchSize          int
+	OutboxLeaseTTL           time.Duration
+	OutboxRetryBaseDelay     time.Duration
+	OutboxRetryMaxDelay      time.Duration
+	OutboxLogSink            bool
+	OutboxHTTPSinkURL        string
+	OutboxHTTPSinkTimeout    time.Duration
+	OutboxRetention          time.Duration
+	OutboxCleanupPeriod      time.Duration
 }
 
 func NewConfig(flag Flags) (*Config, error) {
@@ -64,16 +125,135 @@ func NewConfig(flag Flags) (*Config, error) {
 	_, err = toml.DecodeFile(filepathStr, &c)
 	if err != nil {
 		c.MigrationsPath = "migrations"
-		c.TokenExp = time.Hour * 999
+		c.AccessTokenExp = 15 * time.Minute
+		c.RefreshTokenExp = 30 * 24 * time.Hour
+		c.TokenCleanupPeriod = time.Hour
 		c.AccrualRequestInterval = 1
 		c.AccuralPuttingDBInterval = 1
 		c.NumberOfWorkers = 3
 		c.LoggerLevel = "Info"
+		c.PasswordHashAlgorithm = "argon2id"
+		c.UnregisteredOrderTTL = 24 * time.Hour
+		c.InstanceID = defaultInstanceID()
+		c.OrderLeaseTTL = time.Minute
+		c.OrderLeaseBatchSize = 100
+		c.IdempotencyKeyTTL = 24 * time.Hour
+		c.IdempotencyCleanupPeriod = 10 * time.Minute
+		c.DBRetryMaxAttempts = 4
+		c.DBRetryBaseDelay = 100 * time.Millisecond
+		c.DBRetryMaxDelay = 5 * time.Second
+		c.DBRetryBudget = 10 * time.Second
+		c.DBMaxConns = 10
+		c.DBMinConns = 2
+		c.DBMaxConnLifetime = time.Hour
+		c.DBHealthCheckPeriod = time.Minute
+		c.StatusBatchSize = 500
+		c.SSEHeartbeatInterval = 15 * time.Second
+		c.WebhookPollInterval = time.Second
+		c.WebhookTimeout = 10 * time.Second
+		c.WebhookMaxAttempts = 10
+		c.WebhookRetryBaseDelay = 10 * time.Second
+		c.WebhookRetryMaxDelay = time.Hour
+		c.WebhookBatchSize = 10
+		c.WebhookRetention = 30 * 24 * time.Hour
+		c.WebhookCleanupPeriod = 10 * time.Minute
+		c.OutboxPollInterval = time.Second
+		c.OutboxBatchSize = 100
+		c.OutboxLeaseTTL = 30 * time.Second
+		c.OutboxRetryBaseDelay = time.Second
+		c.OutboxRetryMaxDelay = 5 * time.Minute
+		c.OutboxLogSink = true
+		c.OutboxHTTPSinkURL = os.Getenv("OUTBOX_HTTP_SINK_URL")
+		c.OutboxHTTPSinkTimeout = 10 * time.Second
+		c.OutboxRetention = 7 * 24 * time.Hour
+		c.OutboxCleanupPeriod = 10 * time.Minute
+		c.JWTKeysDir = os.Getenv("JWT_KEYS_DIR")
+		c.JWTCurrentKeyID = os.Getenv("JWT_CURRENT_KEY_ID")
+		c.LoginFreeAttempts = 3
+		c.LoginIPFreeAttempts = 20
+		c.LoginBaseDelay = time.Second
+		c.LoginMaxDelay = 30 * time.Second
+		c.LoginLockoutThreshold = 10
+		c.LoginIPLockoutThreshold = 100
+		c.LoginLockoutDuration = 15 * time.Minute
+		c.LoginAttemptWindow = 15 * time.Minute
+		c.AdminLogins = os.Getenv("ADMIN_LOGINS")
+		c.PasswordMinLength = 8
+		c.PasswordMaxLength = 72
+		c.PasswordMinClasses = 3
+		c.PasswordResetTokenTTL = 30 * time.Minute
+		c.NotifierType = "log"
+		c.TOTPEncryptionKey = os.Getenv("TOTP_ENCRYPTION_KEY")
+		c.TOTPIssuer = "Gophermart"
+		c.MFAChallengeTTL = 5 * time.Minute
+		c.MFAMaxAttempts = 5
 		return &c, ErrFileNotFound
 	}
 
-	c.TokenExp = c.TokenExp * time.Hour
+	c.AccessTokenExp = c.AccessTokenExp * time.Minute
+	c.RefreshTokenExp = c.RefreshTokenExp * time.Hour
+	c.TokenCleanupPeriod = c.TokenCleanupPeriod * time.Minute
+	c.UnregisteredOrderTTL = c.UnregisteredOrderTTL * time.Minute
+	c.OrderLeaseTTL = c.OrderLeaseTTL * time.Second
+	c.IdempotencyKeyTTL = c.IdempotencyKeyTTL * time.Hour
+	c.IdempotencyCleanupPeriod = c.IdempotencyCleanupPeriod * time.Minute
+	c.DBRetryBaseDelay = c.DBRetryBaseDelay * time.Millisecond
+	c.DBRetryMaxDelay = c.DBRetryMaxDelay * time.Millisecond
+	c.DBRetryBudget = c.DBRetryBudget * time.Second
+	c.DBMaxConnLifetime = c.DBMaxConnLifetime * time.Minute
+	c.DBHealthCheckPeriod = c.DBHealthCheckPeriod * time.Second
+	c.SSEHeartbeatInterval = c.SSEHeartbeatInterval * time.Second
+	c.WebhookPollInterval = c.WebhookPollInterval * time.Second
+	c.WebhookTimeout = c.WebhookTimeout * time.Second
+	c.WebhookRetryBaseDelay = c.WebhookRetryBaseDelay * time.Second
+	c.WebhookRetryMaxDelay = c.WebhookRetryMaxDelay * time.Minute
+	c.WebhookRetention = c.WebhookRetention * time.Hour
+	c.WebhookCleanupPeriod = c.WebhookCleanupPeriod * time.Minute
+	c.OutboxPollInterval = c.OutboxPollInterval * time.Second
+	c.OutboxLeaseTTL = c.OutboxLeaseTTL * time.Second
+	c.OutboxRetryBaseDelay = c.OutboxRetryBaseDelay * time.Second
+	c.OutboxRetryMaxDelay = c.OutboxRetryMaxDelay * time.Minute
+	c.OutboxHTTPSinkTimeout = c.OutboxHTTPSinkTimeout * time.Second
+	c.OutboxRetention = c.OutboxRetention * time.Hour
+	c.OutboxCleanupPeriod = c.OutboxCleanupPeriod * time.Minute
+	c.LoginBaseDelay = c.LoginBaseDelay * time.Second
+	c.LoginMaxDelay = c.LoginMaxDelay * time.Second
+	c.LoginLockoutDuration = c.LoginLockoutDuration * time.Minute
+	c.LoginAttemptWindow = c.LoginAttemptWindow * time.Minute
+	c.PasswordResetTokenTTL = c.PasswordResetTokenTTL * time.Minute
+	c.MFAChallengeTTL = c.MFAChallengeTTL * time.Minute
+
+	if buf, ok := os.LookupEnv("INSTANCE_ID"); ok {
+		c.InstanceID = buf
+	}
+	if c.InstanceID == "" {
+		c.InstanceID = defaultInstanceID()
+	}
+	if buf, ok := os.LookupEnv("OUTBOX_HTTP_SINK_URL"); ok {
+		c.OutboxHTTPSinkURL = buf
+	}
+	if buf, ok := os.LookupEnv("JWT_KEYS_DIR"); ok {
+		c.JWTKeysDir = buf
+	}
+	if buf, ok := os.LookupEnv("JWT_CURRENT_KEY_ID"); ok {
+		c.JWTCurrentKeyID = buf
+	}
+	if buf, ok := os.LookupEnv("ADMIN_LOGINS"); ok {
+		c.AdminLogins = buf
+	}
+	if buf, ok := os.LookupEnv("TOTP_ENCRYPTION_KEY"); ok {
+		c.TOTPEncryptionKey = buf
+	}
 
 	return &c, nil
 
 }
+
+// defaultInstanceID - имя экземпляра для захвата заказов, если не задано в конфигурации.
+func defaultInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "gophermart"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
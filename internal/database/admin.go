@@ -0,0 +1,261 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gophermart/internal/models"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrInvalidRole       = errors.New("invalid role")
+	ErrInvalidReasonCode = errors.New("invalid adjustment reason code")
+	ErrInvalidRefund     = errors.New("invalid refund")
+)
+
+// SetUserRole назначает пользователю роль. При смене роли сессии пользователя отзываются:
+// роль записана в access-токены. Нет пользователя - sql.ErrNoRows.
+func (storage *Storage) SetUserRole(ctx context.Context, login, role string) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
+
+		if !models.ValidRole(role) {
+			return ErrInvalidRole
+		}
+
+		var current string
+		err := tx.QueryRow(ctx, `SELECT role FROM users WHERE user_id = $1 FOR UPDATE`, login).Scan(&current)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return sql.ErrNoRows
+		case err != nil:
+			return err
+		case current == role:
+			return nil
+		}
+
+		if _, err := tx.Exec(ctx, `UPDATE users SET role = $2 WHERE user_id = $1`, login, role); err != nil {
+			return err
+		}
+		return revokeUserSessions(ctx, tx, login)
+	})
+}
+
+// BlockUser блокирует аккаунт: вход и обновление токенов запрещены, сессии отозваны.
+// Нет пользователя - sql.ErrNoRows.
+func (storage *Storage) BlockUser(ctx context.Context, login, reason string) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
+
+		query := `UPDATE users SET blocked_at = COALESCE(blocked_at, CURRENT_TIMESTAMP), blocked_reason = $2
+				  WHERE user_id = $1`
+
+		tag, err := tx.Exec(ctx, query, login, reason)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return sql.ErrNoRows
+		}
+		return revokeUserSessions(ctx, tx, login)
+	})
+}
+
+// UnblockUser снимает блокировку аккаунта. Нет пользователя - sql.ErrNoRows.
+func (storage *Storage) UnblockUser(ctx context.Context, login string) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
+
+		query := `UPDATE users SET blocked_at = NULL, blocked_reason = NULL WHERE user_id = $1`
+
+		tag, err := tx.Exec(ctx, query, login)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+}
+
+func revokeUserSessions(ctx context.Context, tx pgx.Tx, userID string) error {
+
+	query := `UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+			  WHERE user_id = $1 AND revoked_at IS NULL`
+
+	_, err := tx.Exec(ctx, query, userID)
+	return err
+}
+
+// AdjustBalance проводит ручную корректировку баланса через журнал и сохраняет ее причину,
+// обращение и автора. Возврат (AdjustmentRefund) возвращает баллы, списанные в счет заказа
+// OrderNumber: нулевая сумма - вернуть весь остаток, возвратов может быть несколько,
+// пока их сумма не превышает списание.
+// Списание больше баланса - ErrNotEnoughFunds, нет пользователя - sql.ErrNoRows.
+func (storage *Storage) AdjustBalance(ctx context.Context, adjustment models.BalanceAdjustment) (models.BalanceAdjustment, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (models.BalanceAdjustment, error) {
+
+		if err := validateAdjustment(adjustment); err != nil {
+			return models.BalanceAdjustment{}, err
+		}
+
+		var exists bool
+		err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE user_id = $1)`, adjustment.UserID).Scan(&exists)
+		if err != nil {
+			return models.BalanceAdjustment{}, err
+		}
+		if !exists {
+			return models.BalanceAdjustment{}, sql.ErrNoRows
+		}
+
+		adjustment.CreatedAt = time.Now()
+		var entry ledgerEntry
+		if adjustment.ReasonCode == models.AdjustmentRefund {
+			withdrawn, err := refundableSum(ctx, tx, adjustment.UserID, adjustment.OrderNumber)
+			if err != nil {
+				return models.BalanceAdjustment{}, err
+			}
+			if adjustment.Amount, err = refundAmount(adjustment, withdrawn); err != nil {
+				return models.BalanceAdjustment{}, err
+			}
+			entry = refundEntry(adjustment.UserID, adjustment.OrderNumber, adjustment.Amount, adjustment.CreatedAt)
+		} else {
+			// как в WithdrawBalance: строка баланса заблокирована до конца транзакции
+			if adjustment.Amount < 0 {
+				var current models.Amount
+				err := tx.QueryRow(ctx, `SELECT current FROM user_balances WHERE user_id = $1 FOR UPDATE`, adjustment.UserID).Scan(&current)
+				switch {
+				case errors.Is(err, pgx.ErrNoRows):
+					return models.BalanceAdjustment{}, ErrNotEnoughFunds
+				case err != nil:
+					return models.BalanceAdjustment{}, err
+				}
+				if current < -adjustment.Amount {
+					return models.BalanceAdjustment{}, ErrNotEnoughFunds
+				}
+			}
+			entry = adjustmentEntry(adjustment.UserID, adjustment.Amount, adjustment.CreatedAt)
+		}
+
+		entryID, err := postEntry(ctx, tx, entry)
+		if err != nil {
+			return models.BalanceAdjustment{}, err
+		}
+
+		query := `INSERT INTO balance_adjustments
+				  (entry_id, user_id, amount, reason_code, reason, ticket, order_number, operator, created_at)
+				  VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, $9) RETURNING id`
+		err = tx.QueryRow(ctx, query, entryID, adjustment.UserID, adjustment.Amount, adjustment.ReasonCode,
+			adjustment.Reason, adjustment.Ticket, adjustment.OrderNumber, adjustment.Operator,
+			adjustment.CreatedAt).Scan(&adjustment.ID)
+		if err != nil {
+			return models.BalanceAdjustment{}, err
+		}
+		return adjustment, nil
+	})
+}
+
+// refundableSum - сумма списания пользователя по заказу, которую еще можно вернуть:
+// списание за вычетом прежних возвратов. Нет списания или все уже возвращено - ErrInvalidRefund.
+// Строка списания блокируется, чтобы два одновременных возврата не превысили списание.
+func refundableSum(ctx context.Context, tx pgx.Tx, userID, orderNumber string) (models.Amount, error) {
+
+	query := `
+	SELECT p.amount
+	FROM ledger_entries e
+	JOIN ledger_postings p ON p.entry_id = e.id
+	JOIN ledger_accounts a ON a.id = p.account_id
+	WHERE e.kind = 'withdrawal' AND e.user_id = $1 AND e.order_number = $2
+	  AND a.user_id IS NULL AND a.kind = 'withdrawal'
+	FOR UPDATE OF e`
+
+	var withdrawn models.Amount
+	err := tx.QueryRow(ctx, query, userID, orderNumber).Scan(&withdrawn)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("%w: нет списания по заказу %s", ErrInvalidRefund, orderNumber)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var refunded models.Amount
+	refundedQuery := `SELECT COALESCE(SUM(amount), 0) FROM balance_adjustments
+					  WHERE user_id = $1 AND order_number = $2 AND reason_code = 'refund'`
+	if err := tx.QueryRow(ctx, refundedQuery, userID, orderNumber).Scan(&refunded); err != nil {
+		return 0, err
+	}
+	return remainingRefund(orderNumber, withdrawn, refunded)
+}
+
+// remainingRefund - сколько еще можно вернуть по заказу, общее для хранилищ.
+func remainingRefund(orderNumber string, withdrawn, refunded models.Amount) (models.Amount, error) {
+	if refunded >= withdrawn {
+		return 0, fmt.Errorf("%w: списание по заказу %s уже возвращено", ErrInvalidRefund, orderNumber)
+	}
+	return withdrawn - refunded, nil
+}
+
+// refundAmount - сумма возврата: нулевая сумма - весь остаток, больше остатка - ErrInvalidRefund.
+func refundAmount(adjustment models.BalanceAdjustment, refundable models.Amount) (models.Amount, error) {
+	switch {
+	case adjustment.Amount == 0:
+		return refundable, nil
+	case adjustment.Amount > refundable:
+		return 0, fmt.Errorf("%w: по заказу %s можно вернуть %v", ErrInvalidRefund, adjustment.OrderNumber, refundable)
+	}
+	return adjustment.Amount, nil
+}
+
+// validateAdjustment - общие для хранилищ проверки корректировки до обращения к данным.
+func validateAdjustment(adjustment models.BalanceAdjustment) error {
+
+	if !models.ValidAdjustmentReason(adjustment.ReasonCode) {
+		return ErrInvalidReasonCode
+	}
+	if adjustment.Reason == "" || adjustment.Ticket == "" || adjustment.Operator == "" {
+		return ErrEmptyValue
+	}
+	if adjustment.ReasonCode == models.AdjustmentRefund {
+		if adjustment.OrderNumber == "" {
+			return ErrEmptyValue
+		}
+		if adjustment.Amount < 0 {
+			return ErrNonPositiveSum
+		}
+		return nil
+	}
+	if adjustment.Amount == 0 {
+		return ErrNonPositiveSum
+	}
+	return nil
+}
+
+// GetBalanceAdjustments возвращает ручные корректировки и возвраты пользователя в хронологическом порядке.
+func (storage *Storage) GetBalanceAdjustments(ctx context.Context, userID string) ([]models.BalanceAdjustment, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) ([]models.BalanceAdjustment, error) {
+
+		query := `SELECT id, user_id, amount, reason_code, reason, ticket, COALESCE(order_number, ''), operator, created_at
+				  FROM balance_adjustments WHERE user_id = $1
+				  ORDER BY created_at ASC, id ASC`
+
+		rows, err := tx.Query(ctx, query, userID)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var adjustments []models.BalanceAdjustment
+		for rows.Next() {
+			var a models.BalanceAdjustment
+			err := rows.Scan(&a.ID, &a.UserID, &a.Amount, &a.ReasonCode, &a.Reason, &a.Ticket,
+				&a.OrderNumber, &a.Operator, &a.CreatedAt)
+			if err != nil {
+				return nil, err
+			}
+			adjustments = append(adjustments, a)
+		}
+		return adjustments, rows.Err()
+	})
+}
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// pageCursor - позиция последней строки страницы. Выдача упорядочена по (Time, Number)
+// для заказов и по (Time, ID) для списаний, следующая страница начинается строго после курсора.
+type pageCursor struct {
+	Time   time.Time `json:"t"`
+	Number string    `json:"n,omitempty"`
+	ID     int64     `json:"i,omitempty"`
+}
+
+func encodeCursor(c pageCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor разбирает курсор клиента, пустая строка - первая страница (nil).
+func decodeCursor(cursor string) (*pageCursor, error) {
+
+	if cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c pageCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Time.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
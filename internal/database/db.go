@@ -2,7 +2,6 @@ package db
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
 	"gophermart/internal/models"
@@ -13,83 +12,183 @@ import (
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
-	_ "github.com/jackc/pgx/v5/stdlib"
-	_ "github.com/lib/pq"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"go.uber.org/zap"
 )
 
 var ErrNotEnoughFunds = errors.New("not enough funds on balance")
+var ErrOrderNotFound = errors.New("order not found")
+var ErrNonPositiveSum = errors.New("sum must be positive")
+
+var (
+	ErrUserExists   = errors.New("user already exists")
+	ErrEmptyValue   = errors.New("empty value")
+	ErrUserNotFound = errors.New("user not found")
+	ErrDuplicateKey = errors.New("duplicate key")
+)
 
 var _ StoragerDB = &Storage{}
 
-type DBOperation func(context.Context, *sql.Tx) (interface{}, error)
-
+// StoragerDB - хранилище gophermart. Каждый метод выполняется в своей транзакции
+// (см. RunTx) и возвращает конкретный тип.
 type StoragerDB interface {
 	Close() error
-	GetUser(context.Context, string) DBOperation
-	AddUser(context.Context, string, string) DBOperation
-	AddOrder(context.Context, string, string) DBOperation
-	GetOrders(context.Context, string) DBOperation
-	GetBalance(context.Context, string) DBOperation
-	WithdrawBalance(context.Context, string, models.OrderSum) DBOperation
-	WithRetry(context.Context, DBOperation) (interface{}, error)
-	GetWithdrawals(context.Context, string) DBOperation
-	GetNewProcessedOrders(context.Context) DBOperation
-	PutStatuses(context.Context, *[]models.OrderStatusNew) DBOperation
+	GetUser(context.Context, string) (models.User, error)
+	AddUser(context.Context, string, string) error
+	UpdateUserHash(context.Context, string, string) error
+	AddOrder(context.Context, string, string) (models.OrderUserID, error)
+	GetOrders(context.Context, string) ([]models.OrderStatus, error)
+	GetOrder(context.Context, string) (models.OrderDetails, error)
+	GetBalance(context.Context, string) (models.Balance, error)
+	WithdrawBalance(context.Context, string, models.OrderSum) error
+	GetWithdrawals(context.Context, string) ([]models.Withdrawal, error)
+	GetOrdersPage(context.Context, string, models.PageQuery) (models.OrdersPage, error)
+	GetWithdrawalsPage(context.Context, string, models.PageQuery) (models.WithdrawalsPage, error)
+	GetLedger(context.Context, string) ([]models.LedgerEntry, error)
+	PutStatuses(context.Context, *[]models.OrderStatusNew) ([]models.OrderStatusNew, error)
+	MarkUnregistered(context.Context, []string, time.Duration) ([]models.OrderStatusNew, error)
+	LeaseOrders(context.Context, string, int, time.Duration) ([]string, error)
+	RenewLeases(context.Context, string, []string, time.Duration) ([]string, error)
+	ReleaseLeases(context.Context, string, []string) error
+	ReserveIdempotencyKey(context.Context, string, string, string) (models.IdempotencyRecord, error)
+	SaveIdempotentResponse(context.Context, models.IdempotencyRecord) error
+	DeleteIdempotencyKey(context.Context, string, string) error
+	DeleteExpiredIdempotencyKeys(context.Context, time.Duration) (int64, error)
+	GetOrderEvents(context.Context, string, int64, int) ([]models.OrderEvent, error)
+	LastOrderEventID(context.Context, string) (int64, error)
+	ListenOrderEvents(context.Context, func(string)) error
+	AddWebhook(context.Context, string, string, string) (models.Webhook, error)
+	GetWebhooks(context.Context, string) ([]models.Webhook, error)
+	DeleteWebhook(context.Context, string, int64) error
+	GetWebhookDeliveries(context.Context, string, int64, int) ([]models.WebhookDelivery, error)
+	LeaseWebhookDeliveries(context.Context, string, int, time.Duration) ([]models.WebhookDelivery, error)
+	SaveWebhookAttempt(context.Context, models.WebhookAttempt) error
+	DeleteFinishedWebhookDeliveries(context.Context, time.Duration) (int64, error)
+	LeaseOutbox(context.Context, string, int, time.Duration) ([]models.OutboxEvent, error)
+	MarkOutboxPublished(context.Context, []int64) error
+	DeleteOutboxPublished(context.Context, time.Duration) (int64, error)
+	RetryOutboxEvent(context.Context, int64, time.Duration, string) error
+	AddSession(context.Context, string, string, string, time.Duration) error
+	RotateRefreshToken(context.Context, string, string, time.Duration) (models.Session, error)
+	RevokeSession(context.Context, string, string) error
+	RevokeAllSessions(context.Context, string) (int64, error)
+	RevokeToken(context.Context, string, time.Duration) error
+	IsTokenRevoked(context.Context, string, string) (bool, error)
+	DeleteExpiredTokens(context.Context) (int64, error)
+	LoginLockedFor(context.Context, ...string) (time.Duration, error)
+	RegisterLoginFailure(context.Context, string, LockoutPolicy) (time.Duration, error)
+	ResetLoginFailures(context.Context, string) error
+	DeleteStaleLoginAttempts(context.Context, time.Duration) (int64, error)
+	ChangePassword(context.Context, string, string, string) (int64, error)
+	AddPasswordResetToken(context.Context, string, string, time.Duration) error
+	ResetPassword(context.Context, string, string) (string, error)
+	GetTwoFactor(context.Context, string) (models.TwoFactor, error)
+	StartTOTPEnrollment(context.Context, string, []byte) error
+	ConfirmTOTP(context.Context, string, int64, []string) error
+	UseTOTPStep(context.Context, string, int64) (bool, error)
+	UseRecoveryCode(context.Context, string, string) (bool, error)
+	ReplaceRecoveryCodes(context.Context, string, []string) error
+	DisableTwoFactor(context.Context, string) error
+	SetWithdrawalTwoFactor(context.Context, string, bool) error
+	AddMFAChallenge(context.Context, string, string, time.Duration) error
+	UseMFAChallenge(context.Context, string, int) (string, error)
+	DeleteMFAChallenge(context.Context, string) error
+	SetUserRole(context.Context, string, string) error
+	BlockUser(context.Context, string, string) error
+	UnblockUser(context.Context, string) error
+	AdjustBalance(context.Context, models.BalanceAdjustment) (models.BalanceAdjustment, error)
+	GetBalanceAdjustments(context.Context, string) ([]models.BalanceAdjustment, error)
+	Stats() PoolStats
+}
+
+// PoolOptions - настройки пула соединений, нулевые значения - по умолчанию pgxpool.
+type PoolOptions struct {
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	HealthCheckPeriod time.Duration
 }
 
 type Storage struct {
 	DatabaseURI string
-	DB          *sql.DB
+	Pool        *pgxpool.Pool
 	logger      *zap.SugaredLogger
+	retry       RetryPolicy
+	// сколько статусов PutStatuses отправляет в базу одной пачкой
+	statusBatchSize int
 }
 
+const defaultStatusBatchSize = 500
+
 // подключение к postgress и migrationsUp
-func New(ctx context.Context, DatabaseURI string, MigrationsPath string, logger *zap.SugaredLogger) (*Storage, error) {
+func New(ctx context.Context, DatabaseURI string, MigrationsPath string, logger *zap.SugaredLogger, retry RetryPolicy, options PoolOptions, statusBatchSize int) (*Storage, error) {
+
+	if err := migrationsUp(DatabaseURI, MigrationsPath); err != nil {
+		return nil, err
+	}
 
-	conn, err := sql.Open("pgx", DatabaseURI)
+	config, err := pgxpool.ParseConfig(DatabaseURI)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка открытия базы данных %w", err)
+		return nil, fmt.Errorf("ошибка разбора адреса базы данных %w", err)
+	}
+	if options.MaxConns > 0 {
+		config.MaxConns = options.MaxConns
+	}
+	if options.MinConns > 0 {
+		config.MinConns = options.MinConns
+	}
+	if options.MaxConnLifetime > 0 {
+		config.MaxConnLifetime = options.MaxConnLifetime
 	}
+	if options.HealthCheckPeriod > 0 {
+		config.HealthCheckPeriod = options.HealthCheckPeriod
+	}
+	config.AfterConnect = prepareStatements
 
-	db, err := migrationsUp(ctx, conn, DatabaseURI, MigrationsPath)
+	pool, err := pgxpool.NewWithConfig(ctx, config)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("ошибка открытия базы данных %w", err)
 	}
 
-	if err = db.PingContext(ctx); err != nil {
+	if err = pool.Ping(ctx); err != nil {
+		pool.Close()
 		return nil, fmt.Errorf("ошибка открытия базы данных(Ping) %w", err)
 	}
 
 	return &Storage{
-		DatabaseURI: DatabaseURI,
-		DB:          db,
-		logger:      logger,
+		DatabaseURI:     DatabaseURI,
+		Pool:            pool,
+		logger:          logger,
+		retry:           retry,
+		statusBatchSize: statusBatchSize,
 	}, nil
 }
 
 func (storage *Storage) Close() error {
 
-	return storage.DB.Close()
+	storage.Pool.Close()
+	return nil
 
 }
 
-func migrationsUp(ctx context.Context, db *sql.DB, DatabaseURI string, migrations string) (*sql.DB, error) {
+func migrationsUp(DatabaseURI string, migrations string) error {
 
 	rootDir, err := utils.FindProjectRoot()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	migrationPath := filepath.Join("file:", rootDir, "migrations")
 
 	m, err := migrate.New(migrationPath, DatabaseURI)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer m.Close()
+
 	if err = m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
-		return nil, err
+		return err
 	}
-	return db, nil
+	return nil
 
 	// проверяем существует ли база
 	// var exist string
@@ -110,43 +209,3 @@ func migrationsUp(ctx context.Context, db *sql.DB, DatabaseURI string, migration
 	// }
 
 }
-
-func (storage *Storage) WithRetry(ctx context.Context, txFunc DBOperation) (interface{}, error) {
-
-	var result interface{}
-	pauseDurations := []int{0, 1, 3, 5}
-
-	for _, pause := range pauseDurations {
-
-		select {
-		case <-ctx.Done():
-			return nil, nil
-		case <-time.After(time.Duration(pause) * time.Second):
-		}
-
-		tx, err := storage.DB.Begin()
-		defer tx.Rollback()
-		if err != nil {
-			return nil, fmt.Errorf("ошибка при создании транзакции %w", err)
-		}
-
-		result, err = txFunc(ctx, tx)
-
-		if err != nil {
-			if !utils.OnDialErr(err) {
-				return nil, fmt.Errorf("НЕвостановимая ошибка %w", err)
-			}
-			storage.logger.Info("восстановимая ошибка %v", err)
-		} else {
-			err = tx.Commit()
-			if err != nil {
-				return nil, fmt.Errorf("ошибка при выполнении commit %w", err)
-			}
-			break
-		}
-
-	}
-
-	return result, nil
-
-}
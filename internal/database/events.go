@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gophermart/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// orderEventsChannel - канал NOTIFY, в который триггер order_events_notify
+// отправляет пользователя, у которого появились события.
+const orderEventsChannel = "order_events"
+
+// putOrderEvents записывает события о примененных статусах в той же транзакции, что и billing.
+// Время события - CURRENT_TIMESTAMP, как у строки billing. Владельцы заказов блокируются
+// lockUsers: поток SSE продолжает с id > Last-Event-ID и не должен пропустить событие.
+func putOrderEvents(ctx context.Context, tx pgx.Tx, statuses []models.OrderStatusNew) error {
+
+	numbers := make([]string, 0, len(statuses))
+	for _, v := range statuses {
+		numbers = append(numbers, v.Number)
+	}
+	owners, err := orderOwners(ctx, tx, numbers)
+	if err != nil {
+		return err
+	}
+	users := make([]string, 0, len(owners))
+	seen := make(map[string]struct{})
+	for _, userID := range owners {
+		if _, ok := seen[userID]; !ok {
+			seen[userID] = struct{}{}
+			users = append(users, userID)
+		}
+	}
+	if err := lockUsers(ctx, tx, users); err != nil {
+		return err
+	}
+
+	statusList := make([]string, 0, len(statuses))
+	accruals := make([]int64, 0, len(statuses))
+	for _, v := range statuses {
+		statusList = append(statusList, v.Status)
+		accruals = append(accruals, int64(v.Accrual))
+	}
+
+	query := `
+	INSERT INTO order_events (user_id, order_number, status, accrual, created_at)
+	SELECT o.user_id, v.number, v.status, v.accrual, CURRENT_TIMESTAMP
+	FROM unnest($1::varchar[], $2::varchar[], $3::bigint[]) WITH ORDINALITY AS v(number, status, accrual, n)
+	JOIN orders o ON o.number = v.number
+	ORDER BY v.n`
+
+	if _, err := tx.Exec(ctx, query, numbers, statusList, accruals); err != nil {
+		return fmt.Errorf("ошибка записи событий заказов %w", err)
+	}
+	return nil
+}
+
+// GetOrderEvents возвращает до limit событий пользователя с id больше afterID по возрастанию id.
+func (storage *Storage) GetOrderEvents(ctx context.Context, userID string, afterID int64, limit int) ([]models.OrderEvent, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) ([]models.OrderEvent, error) {
+
+		query := `SELECT id, user_id, order_number, status, accrual, created_at
+				  FROM order_events
+				  WHERE user_id = $1 AND id > $2
+				  ORDER BY id ASC
+				  LIMIT $3`
+
+		rows, err := tx.Query(ctx, query, userID, afterID, limit)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var events []models.OrderEvent
+		for rows.Next() {
+			var e models.OrderEvent
+			if err := rows.Scan(&e.ID, &e.UserID, &e.Number, &e.Status, &e.Accrual, &e.Time); err != nil {
+				return nil, err
+			}
+			events = append(events, e)
+		}
+		if err := rows.Err(); err != nil {
+			return events, err
+		}
+		return events, nil
+	})
+}
+
+// LastOrderEventID - id последнего события пользователя, 0 - событий еще не было.
+func (storage *Storage) LastOrderEventID(ctx context.Context, userID string) (int64, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (int64, error) {
+
+		query := `SELECT COALESCE(MAX(id), 0) FROM order_events WHERE user_id = $1`
+
+		var id int64
+		err := tx.QueryRow(ctx, query, userID).Scan(&id)
+		return id, err
+	})
+}
+
+// ListenOrderEvents держит отдельное соединение с LISTEN order_events и вызывает notify
+// для каждого уведомления, в том числе о событиях, записанных другими экземплярами.
+// Возвращает ctx.Err() после отмены ctx или ошибку соединения - тогда слушать нужно заново.
+func (storage *Storage) ListenOrderEvents(ctx context.Context, notify func(userID string)) error {
+
+	conn, err := storage.Pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка получения соединения для LISTEN %w", err)
+	}
+	// соединение остается подписанным на канал, в пул его не возвращаем
+	defer func() {
+		conn.Conn().Close(context.Background())
+		conn.Release()
+	}()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+orderEventsChannel); err != nil {
+		return fmt.Errorf("ошибка LISTEN %w", err)
+	}
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil && errors.Is(err, ctxErr) {
+				return ctxErr
+			}
+			return fmt.Errorf("ошибка ожидания NOTIFY %w", err)
+		}
+		notify(notification.Payload)
+	}
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"gophermart/internal/models"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ReserveIdempotencyKey занимает ключ за текущим запросом. Если ключ уже занят,
+// возвращает сохраненную запись (Reserved=false) - ответ или отметку, что запрос еще выполняется.
+func (storage *Storage) ReserveIdempotencyKey(ctx context.Context, userID, key, requestHash string) (models.IdempotencyRecord, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (models.IdempotencyRecord, error) {
+
+		reserveQuery := `INSERT INTO idempotency_keys (user_id, key, request_hash, created_at)
+						 VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+						 ON CONFLICT (user_id, key) DO NOTHING`
+
+		tag, err := tx.Exec(ctx, reserveQuery, userID, key, requestHash)
+		if err != nil {
+			return models.IdempotencyRecord{}, err
+		}
+		if tag.RowsAffected() == 1 {
+			return models.IdempotencyRecord{UserID: userID, Key: key, RequestHash: requestHash, Reserved: true}, nil
+		}
+
+		getQuery := `SELECT request_hash, COALESCE(status_code, 0), COALESCE(content_type, ''), body
+					 FROM idempotency_keys WHERE user_id = $1 AND key = $2`
+
+		record := models.IdempotencyRecord{UserID: userID, Key: key}
+		err = tx.QueryRow(ctx, getQuery, userID, key).Scan(&record.RequestHash, &record.StatusCode, &record.ContentType, &record.Body)
+
+		return record, err
+	})
+}
+
+func (storage *Storage) SaveIdempotentResponse(ctx context.Context, record models.IdempotencyRecord) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
+
+		saveQuery := `UPDATE idempotency_keys SET status_code = $3, content_type = $4, body = $5
+					  WHERE user_id = $1 AND key = $2`
+
+		_, err := tx.Exec(ctx, saveQuery, record.UserID, record.Key, record.StatusCode, record.ContentType, record.Body)
+		return err
+	})
+}
+
+// DeleteIdempotencyKey освобождает ключ, например если запрос завершился внутренней ошибкой
+// и клиент должен иметь возможность повторить его.
+func (storage *Storage) DeleteIdempotencyKey(ctx context.Context, userID, key string) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
+
+		deleteQuery := `DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2`
+
+		_, err := tx.Exec(ctx, deleteQuery, userID, key)
+		return err
+	})
+}
+
+// DeleteExpiredIdempotencyKeys удаляет ключи старше ttl, возвращает количество удаленных.
+func (storage *Storage) DeleteExpiredIdempotencyKeys(ctx context.Context, ttl time.Duration) (int64, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (int64, error) {
+
+		deleteQuery := `DELETE FROM idempotency_keys
+						WHERE created_at < CURRENT_TIMESTAMP - $1::float8 * INTERVAL '1 second'`
+
+		tag, err := tx.Exec(ctx, deleteQuery, ttl.Seconds())
+		if err != nil {
+			return int64(0), err
+		}
+		return tag.RowsAffected(), nil
+	})
+}
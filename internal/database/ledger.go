@@ -0,0 +1,200 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gophermart/internal/models"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Счета журнала. У пользователя один счет баллов, системные счета общие.
+const (
+	accountPoints     = "points"
+	accountAccrual    = "accrual"
+	accountWithdrawal = "withdrawal"
+	accountAdjustment = "adjustment"
+)
+
+var ErrUnbalancedEntry = errors.New("ledger entry is not balanced")
+
+// posting - движение по одному счету. Сумма posting'ов одной записи равна нулю.
+type posting struct {
+	userID  string // пусто для системного счета
+	account string
+	amount  models.Amount
+}
+
+type ledgerEntry struct {
+	userID      string
+	kind        string
+	orderNumber string
+	createdAt   time.Time
+	postings    []posting
+}
+
+// postEntry записывает проводку и обновляет кеш баланса пользователя в той же транзакции.
+// Возвращает id записи журнала.
+func postEntry(ctx context.Context, tx pgx.Tx, entry ledgerEntry) (int64, error) {
+
+	var sum models.Amount
+	for _, p := range entry.postings {
+		sum += p.amount
+	}
+	if sum != 0 || len(entry.postings) < 2 {
+		return 0, fmt.Errorf("%w: %s %s", ErrUnbalancedEntry, entry.kind, entry.orderNumber)
+	}
+
+	var entryID int64
+	addEntryQuery := `INSERT INTO ledger_entries (user_id, kind, order_number, created_at)
+					  VALUES ($1, $2, NULLIF($3, ''), $4) RETURNING id`
+	err := tx.QueryRow(ctx, addEntryQuery, entry.userID, entry.kind, entry.orderNumber, entry.createdAt).Scan(&entryID)
+	if err != nil {
+		return 0, err
+	}
+
+	var current, withdrawn models.Amount
+	for _, p := range entry.postings {
+
+		accountID, err := ledgerAccount(ctx, tx, p.userID, p.account)
+		if err != nil {
+			return 0, err
+		}
+
+		addPostingQuery := `INSERT INTO ledger_postings (entry_id, account_id, amount) VALUES ($1, $2, $3)`
+		if _, err := tx.Exec(ctx, addPostingQuery, entryID, accountID, p.amount); err != nil {
+			return 0, err
+		}
+
+		switch {
+		case p.userID == entry.userID && p.account == accountPoints:
+			current += p.amount
+		case p.userID == "" && p.account == accountWithdrawal:
+			withdrawn += p.amount
+		}
+	}
+
+	updateBalanceQuery := `
+	INSERT INTO user_balances (user_id, current, withdrawn) VALUES ($1, $2, $3)
+	ON CONFLICT (user_id) DO UPDATE
+	SET current = user_balances.current + EXCLUDED.current,
+		withdrawn = user_balances.withdrawn + EXCLUDED.withdrawn`
+	_, err = tx.Exec(ctx, updateBalanceQuery, entry.userID, current, withdrawn)
+
+	return entryID, err
+}
+
+// ledgerAccount возвращает id счета, счет пользователя создается при первой проводке.
+func ledgerAccount(ctx context.Context, tx pgx.Tx, userID, kind string) (int64, error) {
+
+	var id int64
+	if userID == "" {
+		query := `SELECT id FROM ledger_accounts WHERE user_id IS NULL AND kind = $1`
+		err := tx.QueryRow(ctx, query, kind).Scan(&id)
+		return id, err
+	}
+
+	query := `
+	WITH created AS (
+		INSERT INTO ledger_accounts (user_id, kind) VALUES ($1, $2)
+		ON CONFLICT (user_id, kind) WHERE user_id IS NOT NULL DO NOTHING
+		RETURNING id
+	)
+	SELECT id FROM created
+	UNION ALL
+	SELECT id FROM ledger_accounts WHERE user_id = $1 AND kind = $2
+	LIMIT 1`
+	err := tx.QueryRow(ctx, query, userID, kind).Scan(&id)
+	return id, err
+}
+
+// accrualEntry - начисление баллов по заказу из системы расчета.
+func accrualEntry(userID, orderNumber string, amount models.Amount, t time.Time) ledgerEntry {
+	return ledgerEntry{
+		userID:      userID,
+		kind:        models.LedgerKindAccrual,
+		orderNumber: orderNumber,
+		createdAt:   t,
+		postings: []posting{
+			{account: accountAccrual, amount: -amount},
+			{userID: userID, account: accountPoints, amount: amount},
+		},
+	}
+}
+
+// withdrawalEntry - списание баллов в счет оплаты заказа.
+func withdrawalEntry(userID, orderNumber string, amount models.Amount, t time.Time) ledgerEntry {
+	return ledgerEntry{
+		userID:      userID,
+		kind:        models.LedgerKindWithdrawal,
+		orderNumber: orderNumber,
+		createdAt:   t,
+		postings: []posting{
+			{userID: userID, account: accountPoints, amount: -amount},
+			{account: accountWithdrawal, amount: amount},
+		},
+	}
+}
+
+// adjustmentEntry - ручная корректировка баланса: amount > 0 - начисление, < 0 - списание.
+func adjustmentEntry(userID string, amount models.Amount, t time.Time) ledgerEntry {
+	return ledgerEntry{
+		userID:    userID,
+		kind:      models.LedgerKindAdjustment,
+		createdAt: t,
+		postings: []posting{
+			{account: accountAdjustment, amount: -amount},
+			{userID: userID, account: accountPoints, amount: amount},
+		},
+	}
+}
+
+// refundEntry - возврат баллов, списанных в счет заказа: обратная withdrawalEntry проводка.
+func refundEntry(userID, orderNumber string, amount models.Amount, t time.Time) ledgerEntry {
+	return ledgerEntry{
+		userID:      userID,
+		kind:        models.LedgerKindRefund,
+		orderNumber: orderNumber,
+		createdAt:   t,
+		postings: []posting{
+			{account: accountWithdrawal, amount: -amount},
+			{userID: userID, account: accountPoints, amount: amount},
+		},
+	}
+}
+
+// GetLedger возвращает историю движения баллов пользователя в хронологическом порядке.
+func (storage *Storage) GetLedger(ctx context.Context, userID string) ([]models.LedgerEntry, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) ([]models.LedgerEntry, error) {
+
+		query := `
+		SELECT e.id, e.kind, COALESCE(e.order_number, ''), p.amount, COALESCE(b.reason_code, ''), e.created_at
+		FROM ledger_entries e
+		JOIN ledger_postings p ON p.entry_id = e.id
+		JOIN ledger_accounts a ON a.id = p.account_id
+		LEFT JOIN balance_adjustments b ON b.entry_id = e.id
+		WHERE e.user_id = $1 AND a.user_id = $1 AND a.kind = 'points'
+		ORDER BY e.created_at ASC, e.id ASC`
+
+		rows, err := tx.Query(ctx, query, userID)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var entries []models.LedgerEntry
+		for rows.Next() {
+			var e models.LedgerEntry
+			if err := rows.Scan(&e.ID, &e.Kind, &e.OrderNumber, &e.Amount, &e.ReasonCode, &e.CreatedAt); err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+		}
+		if err := rows.Err(); err != nil {
+			return entries, err
+		}
+		return entries, nil
+	})
+}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// LockoutPolicy - сколько неудачных входов допускается по ключу и на сколько ключ блокируется после них.
+// Первые FreeAttempts неудач не блокируют, дальше каждая неудача блокирует ключ на паузу
+// от BaseDelay, удваивающуюся до MaxDelay, а после LockoutThreshold неудач - на LockoutDuration.
+// Счетчик начинается заново, если неудач не было дольше Window (0 - не начинается).
+type LockoutPolicy struct {
+	FreeAttempts     int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	Window           time.Duration
+}
+
+// DefaultLoginPolicy - политика для ключа логина.
+func DefaultLoginPolicy() LockoutPolicy {
+	return LockoutPolicy{
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         30 * time.Second,
+		LockoutThreshold: 10,
+		LockoutDuration:  15 * time.Minute,
+		Window:           15 * time.Minute,
+	}
+}
+
+// DefaultIPLoginPolicy - политика для ключа IP: с одного адреса могут входить многие пользователи.
+func DefaultIPLoginPolicy() LockoutPolicy {
+	p := DefaultLoginPolicy()
+	p.FreeAttempts = 20
+	p.LockoutThreshold = 100
+	return p
+}
+
+// Delay - на сколько блокируется ключ после failures неудач подряд.
+func (p LockoutPolicy) Delay(failures int) time.Duration {
+
+	switch {
+	case p.LockoutThreshold > 0 && failures >= p.LockoutThreshold:
+		return p.LockoutDuration
+	case failures <= p.FreeAttempts || p.BaseDelay <= 0:
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures; i++ {
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			break
+		}
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// LoginLockedFor - сколько еще заблокирован вход: наибольшая оставшаяся блокировка по ключам, 0 - вход разрешен.
+func (storage *Storage) LoginLockedFor(ctx context.Context, keys ...string) (time.Duration, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (time.Duration, error) {
+
+		var seconds float64
+		if err := tx.QueryRow(ctx, stmtLoginLockedFor, keys).Scan(&seconds); err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	})
+}
+
+// RegisterLoginFailure учитывает неудачный вход по ключу и возвращает, на сколько ключ заблокирован.
+func (storage *Storage) RegisterLoginFailure(ctx context.Context, key string, policy LockoutPolicy) (time.Duration, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (time.Duration, error) {
+
+		// истекшая серия неудач начинается заново
+		query := `INSERT INTO login_attempts (key, failures, last_failure_at)
+				  VALUES ($1, 1, CURRENT_TIMESTAMP)
+				  ON CONFLICT (key) DO UPDATE SET
+					  failures = CASE
+						  WHEN $2 > 0 AND login_attempts.last_failure_at < CURRENT_TIMESTAMP - $2::float8 * INTERVAL '1 second'
+						  AND (login_attempts.locked_until IS NULL OR login_attempts.locked_until < CURRENT_TIMESTAMP)
+						  THEN 1 ELSE login_attempts.failures + 1 END,
+					  last_failure_at = CURRENT_TIMESTAMP
+				  RETURNING failures`
+
+		var failures int
+		if err := tx.QueryRow(ctx, query, key, policy.Window.Seconds()).Scan(&failures); err != nil {
+			return 0, err
+		}
+
+		delay := policy.Delay(failures)
+		if delay <= 0 {
+			return 0, nil
+		}
+
+		// параллельные неудачи не укорачивают уже назначенную блокировку
+		lockQuery := `UPDATE login_attempts
+					  SET locked_until = GREATEST(COALESCE(locked_until, CURRENT_TIMESTAMP),
+												  CURRENT_TIMESTAMP + $2::float8 * INTERVAL '1 second')
+					  WHERE key = $1`
+		if _, err := tx.Exec(ctx, lockQuery, key, delay.Seconds()); err != nil {
+			return 0, err
+		}
+		return delay, nil
+	})
+}
+
+// ResetLoginFailures снимает блокировку и сбрасывает счетчик неудач ключа:
+// после успешного входа и при разблокировке администратором.
+func (storage *Storage) ResetLoginFailures(ctx context.Context, key string) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
+
+		_, err := tx.Exec(ctx, `DELETE FROM login_attempts WHERE key = $1`, key)
+		return err
+	})
+}
+
+// DeleteStaleLoginAttempts удаляет незаблокированные ключи без неудач дольше ttl.
+func (storage *Storage) DeleteStaleLoginAttempts(ctx context.Context, ttl time.Duration) (int64, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (int64, error) {
+
+		query := `DELETE FROM login_attempts
+				  WHERE last_failure_at < CURRENT_TIMESTAMP - $1::float8 * INTERVAL '1 second'
+				  AND (locked_until IS NULL OR locked_until < CURRENT_TIMESTAMP)`
+
+		tag, err := tx.Exec(ctx, query, ttl.Seconds())
+		if err != nil {
+			return 0, err
+		}
+		return tag.RowsAffected(), nil
+	})
+}
@@ -0,0 +1,36 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockoutPolicyDelay(t *testing.T) {
+
+	policy := LockoutPolicy{
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         5 * time.Second,
+		LockoutThreshold: 10,
+		LockoutDuration:  15 * time.Minute,
+	}
+
+	expected := map[int]time.Duration{
+		1:  0,
+		3:  0,
+		4:  time.Second,
+		5:  2 * time.Second,
+		6:  4 * time.Second,
+		7:  5 * time.Second,
+		9:  5 * time.Second,
+		10: 15 * time.Minute,
+		50: 15 * time.Minute,
+	}
+	for failures, delay := range expected {
+		assert.Equal(t, delay, policy.Delay(failures), failures)
+	}
+
+	assert.Zero(t, LockoutPolicy{}.Delay(100), "пустая политика не блокирует")
+}
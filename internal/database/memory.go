@@ -0,0 +1,1766 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"gophermart/internal/models"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// MemoryURIPrefix - DATABASE_URI вида memory:// включает хранилище в памяти.
+const MemoryURIPrefix = "memory://"
+
+var _ StoragerDB = &MemoryStorage{}
+
+func IsMemoryURI(databaseURI string) bool {
+	return strings.HasPrefix(databaseURI, MemoryURIPrefix)
+}
+
+type memOrder struct {
+	number            string
+	userID            string
+	uploadedAt        time.Time
+	unregisteredSince time.Time
+	lockedBy          string
+	lockedUntil       time.Time
+}
+
+type memBilling struct {
+	status     string
+	accrual    models.Amount
+	uploadedAt time.Time
+	time       time.Time
+}
+
+type memWebhook struct {
+	webhook models.Webhook
+	userID  string
+}
+
+type memDelivery struct {
+	delivery    models.WebhookDelivery
+	lockedBy    string
+	lockedUntil time.Time
+}
+
+type memOutbox struct {
+	event         models.OutboxEvent
+	nextAttemptAt time.Time
+	lastError     string
+	publishedAt   time.Time
+}
+
+type memSession struct {
+	userID  string
+	revoked bool
+}
+
+type memRefreshToken struct {
+	sessionID string
+	expiresAt time.Time
+	used      bool
+}
+
+type memResetToken struct {
+	userID    string
+	expiresAt time.Time
+	used      bool
+}
+
+type memTOTP struct {
+	secret                []byte
+	enabled               bool
+	lastStep              int64
+	requireForWithdrawals bool
+	// recovery - хеши кодов восстановления, true - код использован
+	recovery map[string]bool
+}
+
+type memChallenge struct {
+	userID    string
+	attempts  int
+	expiresAt time.Time
+}
+
+type memLoginAttempt struct {
+	failures      int
+	lastFailureAt time.Time
+	lockedUntil   time.Time
+}
+
+type memIdempotency struct {
+	record    models.IdempotencyRecord
+	createdAt time.Time
+}
+
+// MemoryStorage - хранилище в памяти для локальной разработки и тестов.
+// Повторяет семантику Storage: уникальность, конфликты заказов, ErrNotEnoughFunds.
+// Операции выполняются под общим мьютексом, поэтому каждая из них атомарна;
+// любая операция сначала проверяет данные и только потом их меняет.
+type MemoryStorage struct {
+	mu          sync.Mutex
+	logger      *zap.SugaredLogger
+	users       map[string]models.User
+	orders      map[string]*memOrder
+	billing     map[string][]memBilling
+	ledger      []models.LedgerEntry
+	ledgerUser  map[int64]string
+	ledgerKeys  map[string]struct{}
+	balances    map[string]models.Balance
+	idempotency map[string]*memIdempotency
+	nextID      int64
+	events      []models.OrderEvent
+	nextEventID int64
+	listeners   map[int]func(string)
+	nextListen  int
+	webhooks    map[int64]*memWebhook
+	deliveries  []*memDelivery
+	nextHookID  int64
+	outbox      []*memOutbox
+	nextOutbox  int64
+	relayBy     string
+	relayUntil  time.Time
+	sessions    map[string]*memSession
+	refresh     map[string]*memRefreshToken
+	revoked     map[string]time.Time
+	attempts    map[string]*memLoginAttempt
+	resets      map[string]*memResetToken
+	totp        map[string]*memTOTP
+	challenges  map[string]*memChallenge
+	adjustments []models.BalanceAdjustment
+}
+
+func NewMemory(logger *zap.SugaredLogger) *MemoryStorage {
+	s := &MemoryStorage{logger: logger, listeners: make(map[int]func(string))}
+	s.reset()
+	return s
+}
+
+func (storage *MemoryStorage) reset() {
+	storage.users = make(map[string]models.User)
+	storage.orders = make(map[string]*memOrder)
+	storage.billing = make(map[string][]memBilling)
+	storage.ledger = nil
+	storage.ledgerUser = make(map[int64]string)
+	storage.ledgerKeys = make(map[string]struct{})
+	storage.balances = make(map[string]models.Balance)
+	storage.idempotency = make(map[string]*memIdempotency)
+	storage.events = nil
+	storage.webhooks = make(map[int64]*memWebhook)
+	storage.deliveries = nil
+	storage.outbox = nil
+	storage.relayBy, storage.relayUntil = "", time.Time{}
+	storage.sessions = make(map[string]*memSession)
+	storage.refresh = make(map[string]*memRefreshToken)
+	storage.revoked = make(map[string]time.Time)
+	storage.attempts = make(map[string]*memLoginAttempt)
+	storage.resets = make(map[string]*memResetToken)
+	storage.totp = make(map[string]*memTOTP)
+	storage.challenges = make(map[string]*memChallenge)
+	storage.adjustments = nil
+}
+
+func (storage *MemoryStorage) Close() error {
+	return nil
+}
+
+// memTx - аналог RunTx: операции выполняются по одной под мьютексом хранилища.
+func memTx[T any](ctx context.Context, storage *MemoryStorage, op func() (T, error)) (T, error) {
+
+	var zero T
+
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	result, err := op()
+	if err != nil {
+		return zero, fmt.Errorf("НЕвостановимая ошибка %w", err)
+	}
+	return result, nil
+}
+
+func memExec(ctx context.Context, storage *MemoryStorage, op func() error) error {
+	_, err := memTx(ctx, storage, func() (struct{}, error) {
+		return struct{}{}, op()
+	})
+	return err
+}
+
+func (storage *MemoryStorage) GetUser(ctx context.Context, login string) (models.User, error) {
+	return memTx(ctx, storage, func() (models.User, error) {
+
+		user, ok := storage.users[login]
+		if !ok {
+			return models.User{}, sql.ErrNoRows
+		}
+		return user, nil
+	})
+}
+
+func (storage *MemoryStorage) AddUser(ctx context.Context, UserID, hash string) error {
+	return memExec(ctx, storage, func() error {
+
+		if UserID == "" || hash == "" {
+			return ErrEmptyValue
+		}
+		if _, ok := storage.users[UserID]; ok {
+			return fmt.Errorf("%w: %s", ErrUserExists, UserID)
+		}
+		storage.users[UserID] = models.User{Login: UserID, Hash: hash, Role: models.RoleUser}
+		return nil
+	})
+}
+
+func (storage *MemoryStorage) UpdateUserHash(ctx context.Context, UserID, hash string) error {
+	return memExec(ctx, storage, func() error {
+
+		if hash == "" {
+			return ErrEmptyValue
+		}
+		if user, ok := storage.users[UserID]; ok {
+			user.Hash = hash
+			storage.users[UserID] = user
+		}
+		return nil
+	})
+}
+
+func (storage *MemoryStorage) AddOrder(ctx context.Context, orderNumber string, userID string) (models.OrderUserID, error) {
+	return memTx(ctx, storage, func() (models.OrderUserID, error) {
+
+		if order, ok := storage.orders[orderNumber]; ok {
+			return models.OrderUserID{OrderNumber: order.number, UserID: order.userID}, nil
+		}
+		if orderNumber == "" || userID == "" {
+			return models.OrderUserID{}, ErrEmptyValue
+		}
+		if _, ok := storage.users[userID]; !ok {
+			return models.OrderUserID{}, fmt.Errorf("%w: %s", ErrUserNotFound, userID)
+		}
+
+		t := time.Now()
+		storage.orders[orderNumber] = &memOrder{number: orderNumber, userID: userID, uploadedAt: t}
+		storage.billing[orderNumber] = []memBilling{{status: models.StatusNew, uploadedAt: t, time: t}}
+
+		event, err := newOutboxEvent(userID, models.OutboxOrderUploaded, models.OutboxPayload{Order: orderNumber})
+		if err != nil {
+			return models.OrderUserID{}, err
+		}
+		storage.putOutbox([]models.OutboxEvent{event}, t)
+
+		return models.OrderUserID{}, nil
+	})
+}
+
+func (storage *MemoryStorage) GetOrders(ctx context.Context, userID string) ([]models.OrderStatus, error) {
+	return memTx(ctx, storage, func() ([]models.OrderStatus, error) {
+
+		type row struct {
+			status models.OrderStatus
+			time   time.Time
+		}
+		var rows []row
+		for _, order := range storage.orders {
+			if order.userID != userID {
+				continue
+			}
+			latest, ok := storage.latestBilling(order.number)
+			if !ok {
+				continue
+			}
+			rows = append(rows, row{
+				status: models.OrderStatus{
+					Number:     order.number,
+					Status:     latest.status,
+					Accrual:    latest.accrual,
+					UploadedAt: latest.uploadedAt,
+				},
+				time: latest.time,
+			})
+		}
+
+		sort.SliceStable(rows, func(i, j int) bool {
+			if !rows[i].time.Equal(rows[j].time) {
+				return rows[i].time.Before(rows[j].time)
+			}
+			return storage.orders[rows[i].status.Number].uploadedAt.Before(storage.orders[rows[j].status.Number].uploadedAt)
+		})
+
+		var orderStatusList []models.OrderStatus
+		for _, r := range rows {
+			orderStatusList = append(orderStatusList, r.status)
+		}
+		return orderStatusList, nil
+	})
+}
+
+func (storage *MemoryStorage) GetOrder(ctx context.Context, number string) (models.OrderDetails, error) {
+	return memTx(ctx, storage, func() (models.OrderDetails, error) {
+
+		order, ok := storage.orders[number]
+		if !ok {
+			return models.OrderDetails{}, ErrOrderNotFound
+		}
+
+		details := models.OrderDetails{Number: order.number, UserID: order.userID, UploadedAt: order.uploadedAt}
+		for _, b := range storage.billing[number] {
+			details.History = append(details.History, models.StatusTransition{Status: b.status, Accrual: b.accrual, Time: b.time})
+		}
+		sort.SliceStable(details.History, func(i, j int) bool {
+			return details.History[i].Time.Before(details.History[j].Time)
+		})
+
+		if latest, ok := storage.latestBilling(number); ok {
+			details.Status = latest.status
+			details.Accrual = latest.accrual
+		}
+		return details, nil
+	})
+}
+
+func (storage *MemoryStorage) GetBalance(ctx context.Context, userID string) (models.Balance, error) {
+	return memTx(ctx, storage, func() (models.Balance, error) {
+		return storage.balances[userID], nil
+	})
+}
+
+func (storage *MemoryStorage) WithdrawBalance(ctx context.Context, userID string, orderSum models.OrderSum) error {
+	return memExec(ctx, storage, func() error {
+
+		if orderSum.Sum <= 0 {
+			return ErrNonPositiveSum
+		}
+		if storage.balances[userID].Current < orderSum.Sum {
+			return ErrNotEnoughFunds
+		}
+		if order, ok := storage.orders[orderSum.OrderNumber]; ok && order.userID != userID {
+			return fmt.Errorf("нельзя вывести деньги другому пользователю %s", order.userID)
+		}
+
+		t := time.Now()
+		_, err := storage.postEntry(userID, models.LedgerKindWithdrawal, orderSum.OrderNumber, -orderSum.Sum, t)
+		if err != nil {
+			return err
+		}
+		event, err := newOutboxEvent(userID, models.OutboxWithdrawal, models.OutboxPayload{Order: orderSum.OrderNumber, Sum: orderSum.Sum})
+		if err != nil {
+			return err
+		}
+		storage.putOutbox([]models.OutboxEvent{event}, t)
+
+		return storage.enqueueWebhooks(userID, models.WebhookEvent{
+			Type:  models.WebhookEventWithdrawal,
+			Order: orderSum.OrderNumber,
+			Sum:   orderSum.Sum,
+			Time:  t,
+		})
+	})
+}
+
+func (storage *MemoryStorage) GetWithdrawals(ctx context.Context, userID string) ([]models.Withdrawal, error) {
+	return memTx(ctx, storage, func() ([]models.Withdrawal, error) {
+
+		var withdrawalsList []models.Withdrawal
+		for _, e := range storage.ledger {
+			if storage.ledgerUser[e.ID] != userID || e.Kind != models.LedgerKindWithdrawal {
+				continue
+			}
+			withdrawalsList = append(withdrawalsList, models.Withdrawal{
+				OrderNumber: e.OrderNumber,
+				Sum:         -e.Amount,
+				ProcessedAt: e.CreatedAt,
+			})
+		}
+		return withdrawalsList, nil
+	})
+}
+
+func (storage *MemoryStorage) GetOrdersPage(ctx context.Context, userID string, query models.PageQuery) (models.OrdersPage, error) {
+
+	cursor, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return models.OrdersPage{}, err
+	}
+
+	return memTx(ctx, storage, func() (models.OrdersPage, error) {
+
+		var orders []*memOrder
+		for _, order := range storage.orders {
+			if order.userID != userID || !inTimeRange(order.uploadedAt, query) {
+				continue
+			}
+			if cursor != nil && !afterCursor(order.uploadedAt, order.number < cursor.Number, order.number == cursor.Number, *cursor, query.Desc) {
+				continue
+			}
+			orders = append(orders, order)
+		}
+		sort.Slice(orders, func(i, j int) bool {
+			less := orders[i].uploadedAt.Before(orders[j].uploadedAt) ||
+				orders[i].uploadedAt.Equal(orders[j].uploadedAt) && orders[i].number < orders[j].number
+			return less != query.Desc
+		})
+
+		var page models.OrdersPage
+		for _, order := range orders {
+			latest, ok := storage.latestBilling(order.number)
+			if !ok || len(query.Statuses) > 0 && !containsString(query.Statuses, latest.status) {
+				continue
+			}
+			if len(page.Orders) == query.PageLimit() {
+				last := page.Orders[len(page.Orders)-1].Number
+				page.NextCursor = encodeCursor(pageCursor{Time: storage.orders[last].uploadedAt, Number: last})
+				break
+			}
+			page.Orders = append(page.Orders, models.OrderStatus{
+				Number:     order.number,
+				Status:     latest.status,
+				Accrual:    latest.accrual,
+				UploadedAt: latest.uploadedAt,
+			})
+		}
+		return page, nil
+	})
+}
+
+func (storage *MemoryStorage) GetWithdrawalsPage(ctx context.Context, userID string, query models.PageQuery) (models.WithdrawalsPage, error) {
+
+	cursor, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return models.WithdrawalsPage{}, err
+	}
+
+	return memTx(ctx, storage, func() (models.WithdrawalsPage, error) {
+
+		var entries []models.LedgerEntry
+		for _, e := range storage.ledger {
+			if storage.ledgerUser[e.ID] != userID || e.Kind != models.LedgerKindWithdrawal || !inTimeRange(e.CreatedAt, query) {
+				continue
+			}
+			if cursor != nil && !afterCursor(e.CreatedAt, e.ID < cursor.ID, e.ID == cursor.ID, *cursor, query.Desc) {
+				continue
+			}
+			entries = append(entries, e)
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			if entries[i].CreatedAt.Equal(entries[j].CreatedAt) {
+				return (entries[i].ID < entries[j].ID) != query.Desc
+			}
+			return entries[i].CreatedAt.Before(entries[j].CreatedAt) != query.Desc
+		})
+
+		var page models.WithdrawalsPage
+		for i, e := range entries {
+			if i == query.PageLimit() {
+				prev := entries[i-1]
+				page.NextCursor = encodeCursor(pageCursor{Time: prev.CreatedAt, ID: prev.ID})
+				break
+			}
+			page.Withdrawals = append(page.Withdrawals, models.Withdrawal{
+				OrderNumber: e.OrderNumber,
+				Sum:         -e.Amount,
+				ProcessedAt: e.CreatedAt,
+			})
+		}
+		return page, nil
+	})
+}
+
+func (storage *MemoryStorage) GetLedger(ctx context.Context, userID string) ([]models.LedgerEntry, error) {
+	return memTx(ctx, storage, func() ([]models.LedgerEntry, error) {
+
+		var entries []models.LedgerEntry
+		for _, e := range storage.ledger {
+			if storage.ledgerUser[e.ID] == userID {
+				entries = append(entries, e)
+			}
+		}
+		return entries, nil
+	})
+}
+
+func (storage *MemoryStorage) PutStatuses(ctx context.Context, orderStatus *[]models.OrderStatusNew) ([]models.OrderStatusNew, error) {
+	return memTx(ctx, storage, func() ([]models.OrderStatusNew, error) {
+
+		current := make(map[string]string)
+		for _, v := range *orderStatus {
+			current[v.Number] = storage.currentStatus(v.Number)
+		}
+
+		var applied []models.OrderStatusNew
+		index := make(map[string]int)
+		for _, v := range *orderStatus {
+			if !models.CanTransition(current[v.Number], v.Status) {
+				continue
+			}
+			current[v.Number] = v.Status
+			if i, ok := index[v.Number]; ok {
+				applied[i] = v
+				continue
+			}
+			index[v.Number] = len(applied)
+			applied = append(applied, v)
+		}
+
+		if len(applied) == 0 {
+			return applied, nil
+		}
+
+		// проверяем проводки заранее, чтобы ошибка не оставила половину статусов
+		for _, v := range applied {
+			if v.Status == models.StatusProcessed && v.Accrual > 0 {
+				if err := storage.checkEntry(storage.orders[v.Number].userID, models.LedgerKindAccrual, v.Number, v.Accrual); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		t := time.Now()
+		for _, v := range applied {
+			storage.billing[v.Number] = append(storage.billing[v.Number], memBilling{
+				status:     v.Status,
+				accrual:    v.Accrual,
+				uploadedAt: t,
+				time:       t,
+			})
+			if v.Status == models.StatusProcessed && v.Accrual > 0 {
+				if _, err := storage.postEntry(storage.orders[v.Number].userID, models.LedgerKindAccrual, v.Number, v.Accrual, t); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		for _, v := range *orderStatus {
+			if order, ok := storage.orders[v.Number]; ok {
+				order.unregisteredSince = time.Time{}
+			}
+		}
+
+		storage.addOrderEvents(applied, t)
+		if err := storage.putStatusOutbox(applied, t); err != nil {
+			return nil, err
+		}
+		if err := storage.enqueueStatusWebhooks(applied, t); err != nil {
+			return nil, err
+		}
+		return applied, nil
+	})
+}
+
+func (storage *MemoryStorage) MarkUnregistered(ctx context.Context, numbers []string, giveUpAfter time.Duration) ([]models.OrderStatusNew, error) {
+	return memTx(ctx, storage, func() ([]models.OrderStatusNew, error) {
+
+		now := time.Now()
+		var invalid []models.OrderStatusNew
+		for _, number := range numbers {
+			order, ok := storage.orders[number]
+			if !ok {
+				continue
+			}
+			if order.unregisteredSince.IsZero() {
+				order.unregisteredSince = now
+				continue
+			}
+			if giveUpAfter <= 0 || !order.unregisteredSince.Before(now.Add(-giveUpAfter)) {
+				continue
+			}
+			if status := storage.currentStatus(number); models.IsFinalStatus(status) || status == "" {
+				continue
+			}
+			storage.billing[number] = append(storage.billing[number], memBilling{
+				status:     models.StatusInvalid,
+				uploadedAt: now,
+				time:       now,
+			})
+			invalid = append(invalid, models.OrderStatusNew{Number: number, Status: models.StatusInvalid, UploadedAt: now})
+		}
+		storage.addOrderEvents(invalid, now)
+		if err := storage.putStatusOutbox(invalid, now); err != nil {
+			return nil, err
+		}
+		if err := storage.enqueueStatusWebhooks(invalid, now); err != nil {
+			return nil, err
+		}
+		return invalid, nil
+	})
+}
+
+func (storage *MemoryStorage) LeaseOrders(ctx context.Context, instanceID string, limit int, ttl time.Duration) ([]string, error) {
+	return memTx(ctx, storage, func() ([]string, error) {
+
+		now := time.Now()
+		orders := storage.sortedOrders()
+		sort.SliceStable(orders, func(i, j int) bool {
+			return orders[i].uploadedAt.Before(orders[j].uploadedAt)
+		})
+
+		var ordersList []string
+		for _, order := range orders {
+			if len(ordersList) >= limit {
+				break
+			}
+			if order.lockedUntil.After(now) || !storage.awaitingAccrual(order.number) {
+				continue
+			}
+			order.lockedBy = instanceID
+			order.lockedUntil = now.Add(ttl)
+			ordersList = append(ordersList, order.number)
+		}
+		return ordersList, nil
+	})
+}
+
+func (storage *MemoryStorage) RenewLeases(ctx context.Context, instanceID string, numbers []string, ttl time.Duration) ([]string, error) {
+	return memTx(ctx, storage, func() ([]string, error) {
+
+		now := time.Now()
+		var renewed []string
+		for _, number := range numbers {
+			if order, ok := storage.orders[number]; ok && order.lockedBy == instanceID {
+				order.lockedUntil = now.Add(ttl)
+				renewed = append(renewed, number)
+			}
+		}
+		return renewed, nil
+	})
+}
+
+func (storage *MemoryStorage) ReleaseLeases(ctx context.Context, instanceID string, numbers []string) error {
+	return memExec(ctx, storage, func() error {
+
+		for _, number := range numbers {
+			if order, ok := storage.orders[number]; ok && order.lockedBy == instanceID {
+				order.lockedBy = ""
+				order.lockedUntil = time.Time{}
+			}
+		}
+		return nil
+	})
+}
+
+func (storage *MemoryStorage) ReserveIdempotencyKey(ctx context.Context, userID, key, requestHash string) (models.IdempotencyRecord, error) {
+	return memTx(ctx, storage, func() (models.IdempotencyRecord, error) {
+
+		if key == "" {
+			return models.IdempotencyRecord{}, ErrEmptyValue
+		}
+		if _, ok := storage.users[userID]; !ok {
+			return models.IdempotencyRecord{}, fmt.Errorf("%w: %s", ErrUserNotFound, userID)
+		}
+
+		if row, ok := storage.idempotency[userID+"\x00"+key]; ok {
+			return row.record, nil
+		}
+
+		record := models.IdempotencyRecord{UserID: userID, Key: key, RequestHash: requestHash}
+		storage.idempotency[userID+"\x00"+key] = &memIdempotency{record: record, createdAt: time.Now()}
+
+		record.Reserved = true
+		return record, nil
+	})
+}
+
+func (storage *MemoryStorage) SaveIdempotentResponse(ctx context.Context, record models.IdempotencyRecord) error {
+	return memExec(ctx, storage, func() error {
+
+		if row, ok := storage.idempotency[record.UserID+"\x00"+record.Key]; ok {
+			row.record.StatusCode = record.StatusCode
+			row.record.ContentType = record.ContentType
+			row.record.Body = append([]byte(nil), record.Body...)
+		}
+		return nil
+	})
+}
+
+func (storage *MemoryStorage) DeleteIdempotencyKey(ctx context.Context, userID, key string) error {
+	return memExec(ctx, storage, func() error {
+
+		delete(storage.idempotency, userID+"\x00"+key)
+		return nil
+	})
+}
+
+func (storage *MemoryStorage) DeleteExpiredIdempotencyKeys(ctx context.Context, ttl time.Duration) (int64, error) {
+	return memTx(ctx, storage, func() (int64, error) {
+
+		deadline := time.Now().Add(-ttl)
+		var deleted int64
+		for k, row := range storage.idempotency {
+			if row.createdAt.Before(deadline) {
+				delete(storage.idempotency, k)
+				deleted++
+			}
+		}
+		return deleted, nil
+	})
+}
+
+// checkEntry проверяет проводку так же, как ограничения таблиц журнала.
+func (storage *MemoryStorage) GetOrderEvents(ctx context.Context, userID string, afterID int64, limit int) ([]models.OrderEvent, error) {
+	return memTx(ctx, storage, func() ([]models.OrderEvent, error) {
+
+		var events []models.OrderEvent
+		for _, e := range storage.events {
+			if len(events) == limit {
+				break
+			}
+			if e.UserID == userID && e.ID > afterID {
+				events = append(events, e)
+			}
+		}
+		return events, nil
+	})
+}
+
+func (storage *MemoryStorage) LastOrderEventID(ctx context.Context, userID string) (int64, error) {
+	return memTx(ctx, storage, func() (int64, error) {
+
+		for i := len(storage.events) - 1; i >= 0; i-- {
+			if storage.events[i].UserID == userID {
+				return storage.events[i].ID, nil
+			}
+		}
+		return 0, nil
+	})
+}
+
+// ListenOrderEvents - аналог LISTEN: notify вызывается под мьютексом хранилища
+// сразу после записи событий, поэтому не должен блокироваться.
+func (storage *MemoryStorage) ListenOrderEvents(ctx context.Context, notify func(userID string)) error {
+
+	storage.mu.Lock()
+	id := storage.nextListen
+	storage.nextListen++
+	storage.listeners[id] = notify
+	storage.mu.Unlock()
+
+	<-ctx.Done()
+
+	storage.mu.Lock()
+	delete(storage.listeners, id)
+	storage.mu.Unlock()
+
+	return ctx.Err()
+}
+
+// addOrderEvents - аналог putOrderEvents, вызывается после изменения billing.
+func (storage *MemoryStorage) addOrderEvents(statuses []models.OrderStatusNew, t time.Time) {
+
+	users := make(map[string]struct{})
+	for _, v := range statuses {
+		order, ok := storage.orders[v.Number]
+		if !ok {
+			continue
+		}
+		storage.nextEventID++
+		storage.events = append(storage.events, models.OrderEvent{
+			ID:      storage.nextEventID,
+			UserID:  order.userID,
+			Number:  v.Number,
+			Status:  v.Status,
+			Accrual: v.Accrual,
+			Time:    t,
+		})
+		users[order.userID] = struct{}{}
+	}
+
+	for userID := range users {
+		for _, notify := range storage.listeners {
+			notify(userID)
+		}
+	}
+}
+
+func (storage *MemoryStorage) AddWebhook(ctx context.Context, userID, url, secret string) (models.Webhook, error) {
+	return memTx(ctx, storage, func() (models.Webhook, error) {
+
+		if _, ok := storage.users[userID]; !ok {
+			return models.Webhook{}, ErrUserNotFound
+		}
+		if url == "" || secret == "" {
+			return models.Webhook{}, ErrEmptyValue
+		}
+
+		storage.nextHookID++
+		webhook := models.Webhook{ID: storage.nextHookID, URL: url, Secret: secret, CreatedAt: time.Now()}
+		storage.webhooks[webhook.ID] = &memWebhook{webhook: webhook, userID: userID}
+		return webhook, nil
+	})
+}
+
+func (storage *MemoryStorage) GetWebhooks(ctx context.Context, userID string) ([]models.Webhook, error) {
+	return memTx(ctx, storage, func() ([]models.Webhook, error) {
+
+		var webhooks []models.Webhook
+		for _, w := range storage.webhooks {
+			if w.userID == userID {
+				webhook := w.webhook
+				webhook.Secret = ""
+				webhooks = append(webhooks, webhook)
+			}
+		}
+		sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].ID < webhooks[j].ID })
+		return webhooks, nil
+	})
+}
+
+func (storage *MemoryStorage) DeleteWebhook(ctx context.Context, userID string, webhookID int64) error {
+	return memExec(ctx, storage, func() error {
+
+		w, ok := storage.webhooks[webhookID]
+		if !ok || w.userID != userID {
+			return ErrWebhookNotFound
+		}
+		delete(storage.webhooks, webhookID)
+
+		kept := storage.deliveries[:0]
+		for _, d := range storage.deliveries {
+			if d.delivery.WebhookID != webhookID {
+				kept = append(kept, d)
+			}
+		}
+		storage.deliveries = kept
+		return nil
+	})
+}
+
+func (storage *MemoryStorage) GetWebhookDeliveries(ctx context.Context, userID string, webhookID int64, limit int) ([]models.WebhookDelivery, error) {
+	return memTx(ctx, storage, func() ([]models.WebhookDelivery, error) {
+
+		w, ok := storage.webhooks[webhookID]
+		if !ok || w.userID != userID {
+			return nil, ErrWebhookNotFound
+		}
+
+		var deliveries []models.WebhookDelivery
+		for i := len(storage.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+			if d := storage.deliveries[i].delivery; d.WebhookID == webhookID {
+				d.URL, d.Secret = "", ""
+				deliveries = append(deliveries, d)
+			}
+		}
+		return deliveries, nil
+	})
+}
+
+func (storage *MemoryStorage) LeaseWebhookDeliveries(ctx context.Context, instanceID string, limit int, ttl time.Duration) ([]models.WebhookDelivery, error) {
+	return memTx(ctx, storage, func() ([]models.WebhookDelivery, error) {
+
+		now := time.Now()
+		var due []*memDelivery
+		for _, d := range storage.deliveries {
+			if d.delivery.Status == models.DeliveryPending && !d.delivery.NextAttemptAt.After(now) && d.lockedUntil.Before(now) {
+				due = append(due, d)
+			}
+		}
+		sort.SliceStable(due, func(i, j int) bool {
+			return due[i].delivery.NextAttemptAt.Before(due[j].delivery.NextAttemptAt)
+		})
+
+		var deliveries []models.WebhookDelivery
+		for _, d := range due {
+			if len(deliveries) == limit {
+				break
+			}
+			d.lockedBy = instanceID
+			d.lockedUntil = now.Add(ttl)
+			delivery := d.delivery
+			w := storage.webhooks[delivery.WebhookID]
+			delivery.URL, delivery.Secret = w.webhook.URL, w.webhook.Secret
+			deliveries = append(deliveries, delivery)
+		}
+		return deliveries, nil
+	})
+}
+
+func (storage *MemoryStorage) SaveWebhookAttempt(ctx context.Context, attempt models.WebhookAttempt) error {
+	return memExec(ctx, storage, func() error {
+
+		for _, d := range storage.deliveries {
+			if d.delivery.ID != attempt.DeliveryID {
+				continue
+			}
+			if d.lockedBy != attempt.InstanceID {
+				return ErrWebhookLeaseLost
+			}
+			now := time.Now()
+			d.delivery.Status = attempt.Status
+			d.delivery.Attempts++
+			d.delivery.LastStatusCode = attempt.StatusCode
+			d.delivery.LastError = attempt.Error
+			switch attempt.Status {
+			case models.DeliveryPending:
+				d.delivery.NextAttemptAt = now.Add(attempt.RetryIn)
+			case models.DeliveryDelivered:
+				d.delivery.DeliveredAt = &now
+			}
+			d.lockedBy, d.lockedUntil = "", time.Time{}
+			return nil
+		}
+		return ErrWebhookLeaseLost
+	})
+}
+
+func (storage *MemoryStorage) DeleteFinishedWebhookDeliveries(ctx context.Context, olderThan time.Duration) (int64, error) {
+	return memTx(ctx, storage, func() (int64, error) {
+
+		deadline := time.Now().Add(-olderThan)
+		kept := storage.deliveries[:0]
+		for _, d := range storage.deliveries {
+			if d.delivery.Status == models.DeliveryPending || !d.delivery.CreatedAt.Before(deadline) {
+				kept = append(kept, d)
+			}
+		}
+		deleted := int64(len(storage.deliveries) - len(kept))
+		clear(storage.deliveries[len(kept):])
+		storage.deliveries = kept
+		return deleted, nil
+	})
+}
+
+func (storage *MemoryStorage) LeaseOutbox(ctx context.Context, instanceID string, limit int, ttl time.Duration) ([]models.OutboxEvent, error) {
+	return memTx(ctx, storage, func() ([]models.OutboxEvent, error) {
+
+		now := time.Now()
+		if storage.relayBy != instanceID && storage.relayUntil.After(now) {
+			return nil, nil
+		}
+		storage.relayBy, storage.relayUntil = instanceID, now.Add(ttl)
+
+		var events []models.OutboxEvent
+		delayed := make(map[string]struct{})
+		for _, o := range storage.outbox {
+			if len(events) == limit {
+				break
+			}
+			if !o.publishedAt.IsZero() {
+				continue
+			}
+			if o.nextAttemptAt.After(now) {
+				delayed[o.event.UserID] = struct{}{}
+			}
+			if _, ok := delayed[o.event.UserID]; ok {
+				continue
+			}
+			events = append(events, o.event)
+		}
+		return events, nil
+	})
+}
+
+func (storage *MemoryStorage) MarkOutboxPublished(ctx context.Context, ids []int64) error {
+	return memExec(ctx, storage, func() error {
+
+		published := make(map[int64]struct{}, len(ids))
+		for _, id := range ids {
+			published[id] = struct{}{}
+		}
+		now := time.Now()
+		for _, o := range storage.outbox {
+			if _, ok := published[o.event.ID]; ok && o.publishedAt.IsZero() {
+				o.publishedAt = now
+				o.lastError = ""
+			}
+		}
+		return nil
+	})
+}
+
+func (storage *MemoryStorage) DeleteOutboxPublished(ctx context.Context, olderThan time.Duration) (int64, error) {
+	return memTx(ctx, storage, func() (int64, error) {
+
+		deadline := time.Now().Add(-olderThan)
+		kept := storage.outbox[:0]
+		for _, o := range storage.outbox {
+			if o.publishedAt.IsZero() || !o.publishedAt.Before(deadline) {
+				kept = append(kept, o)
+			}
+		}
+		deleted := int64(len(storage.outbox) - len(kept))
+		clear(storage.outbox[len(kept):])
+		storage.outbox = kept
+		return deleted, nil
+	})
+}
+
+func (storage *MemoryStorage) RetryOutboxEvent(ctx context.Context, id int64, retryIn time.Duration, lastError string) error {
+	return memExec(ctx, storage, func() error {
+
+		for _, o := range storage.outbox {
+			if o.event.ID == id {
+				o.event.Attempts++
+				o.nextAttemptAt = time.Now().Add(retryIn)
+				o.lastError = lastError
+			}
+		}
+		return nil
+	})
+}
+
+// putOutbox - аналог putOutbox для Postgres. Операции выполняются под общим мьютексом,
+// поэтому id событий и так идут в порядке изменений.
+func (storage *MemoryStorage) putOutbox(events []models.OutboxEvent, t time.Time) {
+	for _, e := range events {
+		storage.nextOutbox++
+		e.ID = storage.nextOutbox
+		e.CreatedAt = t
+		storage.outbox = append(storage.outbox, &memOutbox{event: e, nextAttemptAt: t})
+	}
+}
+
+func (storage *MemoryStorage) putStatusOutbox(statuses []models.OrderStatusNew, t time.Time) error {
+
+	owners := make(map[string]string, len(statuses))
+	for _, v := range statuses {
+		owners[v.Number] = storage.orders[v.Number].userID
+	}
+	events, err := statusOutboxEvents(owners, statuses)
+	if err != nil {
+		return err
+	}
+	storage.putOutbox(events, t)
+	return nil
+}
+
+func (storage *MemoryStorage) AddSession(ctx context.Context, userID, sessionID, refreshHash string, ttl time.Duration) error {
+	return memExec(ctx, storage, func() error {
+
+		if _, ok := storage.users[userID]; !ok {
+			return ErrUserNotFound
+		}
+		if _, ok := storage.sessions[sessionID]; ok {
+			return ErrDuplicateKey
+		}
+		if _, ok := storage.refresh[refreshHash]; ok {
+			return ErrDuplicateKey
+		}
+		storage.sessions[sessionID] = &memSession{userID: userID}
+		storage.refresh[refreshHash] = &memRefreshToken{sessionID: sessionID, expiresAt: time.Now().Add(ttl)}
+		return nil
+	})
+}
+
+func (storage *MemoryStorage) RotateRefreshToken(ctx context.Context, oldHash, newHash string, ttl time.Duration) (models.Session, error) {
+
+	result, err := memTx(ctx, storage, func() (rotation, error) {
+
+		token, ok := storage.refresh[oldHash]
+		if !ok {
+			return rotation{}, ErrInvalidRefreshToken
+		}
+		session := storage.sessions[token.sessionID]
+		if session == nil {
+			return rotation{}, ErrInvalidRefreshToken
+		}
+		if token.used {
+			session.revoked = true
+			return rotation{session: models.Session{ID: token.sessionID, UserID: session.userID}, reused: true}, nil
+		}
+		now := time.Now()
+		user := storage.users[session.userID]
+		if session.revoked || token.expiresAt.Before(now) || user.Blocked {
+			return rotation{}, ErrInvalidRefreshToken
+		}
+		if _, ok := storage.refresh[newHash]; ok {
+			return rotation{}, ErrDuplicateKey
+		}
+
+		token.used = true
+		storage.refresh[newHash] = &memRefreshToken{sessionID: token.sessionID, expiresAt: now.Add(ttl)}
+		return rotation{session: models.Session{ID: token.sessionID, UserID: session.userID, Role: user.Role}}, nil
+	})
+
+	switch {
+	case err != nil:
+		return models.Session{}, err
+	case result.reused:
+		return models.Session{}, ErrRefreshTokenReused
+	}
+	return result.session, nil
+}
+
+func (storage *MemoryStorage) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	return memExec(ctx, storage, func() error {
+
+		if session, ok := storage.sessions[sessionID]; ok && session.userID == userID {
+			session.revoked = true
+		}
+		return nil
+	})
+}
+
+func (storage *MemoryStorage) RevokeAllSessions(ctx context.Context, userID string) (int64, error) {
+	return memTx(ctx, storage, func() (int64, error) {
+
+		var n int64
+		for _, session := range storage.sessions {
+			if session.userID == userID && !session.revoked {
+				session.revoked = true
+				n++
+			}
+		}
+		return n, nil
+	})
+}
+
+func (storage *MemoryStorage) RevokeToken(ctx context.Context, jti string, ttl time.Duration) error {
+	return memExec(ctx, storage, func() error {
+
+		if _, ok := storage.revoked[jti]; !ok {
+			storage.revoked[jti] = time.Now().Add(ttl)
+		}
+		return nil
+	})
+}
+
+func (storage *MemoryStorage) IsTokenRevoked(ctx context.Context, jti, sessionID string) (bool, error) {
+	return memTx(ctx, storage, func() (bool, error) {
+
+		if _, ok := storage.revoked[jti]; ok {
+			return true, nil
+		}
+		if sessionID == "" {
+			return false, nil
+		}
+		session, ok := storage.sessions[sessionID]
+		return !ok || session.revoked, nil
+	})
+}
+
+func (storage *MemoryStorage) DeleteExpiredTokens(ctx context.Context) (int64, error) {
+	return memTx(ctx, storage, func() (int64, error) {
+
+		now := time.Now()
+		var n int64
+		for jti, expiresAt := range storage.revoked {
+			if expiresAt.Before(now) {
+				delete(storage.revoked, jti)
+				n++
+			}
+		}
+
+		active := make(map[string]struct{})
+		for _, token := range storage.refresh {
+			if !token.used && token.expiresAt.After(now) {
+				active[token.sessionID] = struct{}{}
+			}
+		}
+		for id, session := range storage.sessions {
+			if _, ok := active[id]; session.revoked || !ok {
+				delete(storage.sessions, id)
+				n++
+			}
+		}
+		for hash, token := range storage.refresh {
+			if _, ok := storage.sessions[token.sessionID]; !ok {
+				delete(storage.refresh, hash)
+			}
+		}
+		for hash, token := range storage.resets {
+			if token.used || token.expiresAt.Before(now) {
+				delete(storage.resets, hash)
+				n++
+			}
+		}
+		for hash, challenge := range storage.challenges {
+			if challenge.expiresAt.Before(now) {
+				delete(storage.challenges, hash)
+				n++
+			}
+		}
+		return n, nil
+	})
+}
+
+func (storage *MemoryStorage) LoginLockedFor(ctx context.Context, keys ...string) (time.Duration, error) {
+	return memTx(ctx, storage, func() (time.Duration, error) {
+
+		var locked time.Duration
+		for _, key := range keys {
+			if a, ok := storage.attempts[key]; ok {
+				if d := time.Until(a.lockedUntil); d > locked {
+					locked = d
+				}
+			}
+		}
+		return locked, nil
+	})
+}
+
+func (storage *MemoryStorage) RegisterLoginFailure(ctx context.Context, key string, policy LockoutPolicy) (time.Duration, error) {
+	return memTx(ctx, storage, func() (time.Duration, error) {
+
+		now := time.Now()
+		a, ok := storage.attempts[key]
+		switch {
+		case !ok:
+			a = &memLoginAttempt{}
+			storage.attempts[key] = a
+		case policy.Window > 0 && a.lastFailureAt.Before(now.Add(-policy.Window)) && !a.lockedUntil.After(now):
+			a.failures = 0
+		}
+		a.failures++
+		a.lastFailureAt = now
+
+		delay := policy.Delay(a.failures)
+		if delay <= 0 {
+			return 0, nil
+		}
+		if until := now.Add(delay); until.After(a.lockedUntil) {
+			a.lockedUntil = until
+		}
+		return delay, nil
+	})
+}
+
+func (storage *MemoryStorage) ResetLoginFailures(ctx context.Context, key string) error {
+	return memExec(ctx, storage, func() error {
+		delete(storage.attempts, key)
+		return nil
+	})
+}
+
+func (storage *MemoryStorage) DeleteStaleLoginAttempts(ctx context.Context, ttl time.Duration) (int64, error) {
+	return memTx(ctx, storage, func() (int64, error) {
+
+		now := time.Now()
+		var n int64
+		for key, a := range storage.attempts {
+			if a.lastFailureAt.Before(now.Add(-ttl)) && !a.lockedUntil.After(now) {
+				delete(storage.attempts, key)
+				n++
+			}
+		}
+		return n, nil
+	})
+}
+
+func (storage *MemoryStorage) ChangePassword(ctx context.Context, userID, hash, keepSessionID string) (int64, error) {
+	return memTx(ctx, storage, func() (int64, error) {
+		return storage.changePassword(userID, hash, keepSessionID)
+	})
+}
+
+// changePassword - аналог changePassword для Postgres.
+func (storage *MemoryStorage) changePassword(userID, hash, keepSessionID string) (int64, error) {
+
+	if hash == "" {
+		return 0, ErrEmptyValue
+	}
+	user, ok := storage.users[userID]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	user.Hash = hash
+	storage.users[userID] = user
+
+	for _, token := range storage.resets {
+		if token.userID == userID {
+			token.used = true
+		}
+	}
+
+	var n int64
+	for id, session := range storage.sessions {
+		if session.userID == userID && id != keepSessionID && !session.revoked {
+			session.revoked = true
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (storage *MemoryStorage) AddPasswordResetToken(ctx context.Context, userID, tokenHash string, ttl time.Duration) error {
+	return memExec(ctx, storage, func() error {
+
+		if _, ok := storage.users[userID]; !ok {
+			return sql.ErrNoRows
+		}
+		if _, ok := storage.resets[tokenHash]; ok {
+			return fmt.Errorf("токен сброса уже существует")
+		}
+		for _, token := range storage.resets {
+			if token.userID == userID {
+				token.used = true
+			}
+		}
+		storage.resets[tokenHash] = &memResetToken{userID: userID, expiresAt: time.Now().Add(ttl)}
+		return nil
+	})
+}
+
+func (storage *MemoryStorage) ResetPassword(ctx context.Context, tokenHash, hash string) (string, error) {
+	return memTx(ctx, storage, func() (string, error) {
+
+		token, ok := storage.resets[tokenHash]
+		if !ok || token.used || !token.expiresAt.After(time.Now()) {
+			return "", ErrInvalidResetToken
+		}
+
+		// changePassword гасит и этот токен
+		if _, err := storage.changePassword(token.userID, hash, ""); err != nil {
+			return "", err
+		}
+		return token.userID, nil
+	})
+}
+
+func (storage *MemoryStorage) GetTwoFactor(ctx context.Context, userID string) (models.TwoFactor, error) {
+	return memTx(ctx, storage, func() (models.TwoFactor, error) {
+
+		t, ok := storage.totp[userID]
+		if !ok {
+			return models.TwoFactor{}, sql.ErrNoRows
+		}
+		return models.TwoFactor{
+			UserID:                userID,
+			Secret:                append([]byte(nil), t.secret...),
+			Enabled:               t.enabled,
+			LastStep:              t.lastStep,
+			RequireForWithdrawals: t.requireForWithdrawals,
+		}, nil
+	})
+}
+
+func (storage *MemoryStorage) StartTOTPEnrollment(ctx context.Context, userID string, secret []byte) error {
+	return memExec(ctx, storage, func() error {
+
+		if _, ok := storage.users[userID]; !ok {
+			return sql.ErrNoRows
+		}
+		if t, ok := storage.totp[userID]; ok && t.enabled {
+			return ErrTwoFactorEnabled
+		}
+		storage.totp[userID] = &memTOTP{secret: append([]byte(nil), secret...), recovery: make(map[string]bool)}
+		return nil
+	})
+}
+
+func (storage *MemoryStorage) ConfirmTOTP(ctx context.Context, userID string, step int64, codeHashes []string) error {
+	return memExec(ctx, storage, func() error {
+
+		t, ok := storage.totp[userID]
+		if !ok || t.enabled {
+			return ErrTwoFactorEnabled
+		}
+		t.enabled = true
+		t.lastStep = step
+		t.recovery = memRecoveryCodes(codeHashes)
+		return nil
+	})
+}
+
+func (storage *MemoryStorage) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	return memTx(ctx, storage, func() (bool, error) {
+
+		t, ok := storage.totp[userID]
+		if !ok || !t.enabled || t.lastStep >= step {
+			return false, nil
+		}
+		t.lastStep = step
+		return true, nil
+	})
+}
+
+func (storage *MemoryStorage) UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error) {
+	return memTx(ctx, storage, func() (bool, error) {
+
+		t, ok := storage.totp[userID]
+		if !ok {
+			return false, nil
+		}
+		if used, ok := t.recovery[codeHash]; !ok || used {
+			return false, nil
+		}
+		t.recovery[codeHash] = true
+		return true, nil
+	})
+}
+
+func (storage *MemoryStorage) ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error {
+	return memExec(ctx, storage, func() error {
+
+		t, ok := storage.totp[userID]
+		if !ok {
+			return ErrTwoFactorNotEnabled
+		}
+		t.recovery = memRecoveryCodes(codeHashes)
+		return nil
+	})
+}
+
+func memRecoveryCodes(codeHashes []string) map[string]bool {
+	recovery := make(map[string]bool, len(codeHashes))
+	for _, hash := range codeHashes {
+		recovery[hash] = false
+	}
+	return recovery
+}
+
+func (storage *MemoryStorage) DisableTwoFactor(ctx context.Context, userID string) error {
+	return memExec(ctx, storage, func() error {
+
+		delete(storage.totp, userID)
+		for hash, challenge := range storage.challenges {
+			if challenge.userID == userID {
+				delete(storage.challenges, hash)
+			}
+		}
+		return nil
+	})
+}
+
+func (storage *MemoryStorage) SetWithdrawalTwoFactor(ctx context.Context, userID string, require bool) error {
+	return memExec(ctx, storage, func() error {
+
+		t, ok := storage.totp[userID]
+		if !ok || !t.enabled {
+			return ErrTwoFactorNotEnabled
+		}
+		t.requireForWithdrawals = require
+		return nil
+	})
+}
+
+func (storage *MemoryStorage) AddMFAChallenge(ctx context.Context, userID, tokenHash string, ttl time.Duration) error {
+	return memExec(ctx, storage, func() error {
+
+		if _, ok := storage.challenges[tokenHash]; ok {
+			return fmt.Errorf("токен второго шага уже существует")
+		}
+		storage.challenges[tokenHash] = &memChallenge{userID: userID, expiresAt: time.Now().Add(ttl)}
+		return nil
+	})
+}
+
+func (storage *MemoryStorage) UseMFAChallenge(ctx context.Context, tokenHash string, maxAttempts int) (string, error) {
+	return memTx(ctx, storage, func() (string, error) {
+
+		challenge, ok := storage.challenges[tokenHash]
+		if !ok || !challenge.expiresAt.After(time.Now()) || challenge.attempts >= maxAttempts {
+			return "", ErrInvalidMFAToken
+		}
+		challenge.attempts++
+		return challenge.userID, nil
+	})
+}
+
+func (storage *MemoryStorage) DeleteMFAChallenge(ctx context.Context, tokenHash string) error {
+	return memExec(ctx, storage, func() error {
+		delete(storage.challenges, tokenHash)
+		return nil
+	})
+}
+
+func (storage *MemoryStorage) SetUserRole(ctx context.Context, login, role string) error {
+	return memExec(ctx, storage, func() error {
+
+		if !models.ValidRole(role) {
+			return ErrInvalidRole
+		}
+		user, ok := storage.users[login]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		if user.Role == role {
+			return nil
+		}
+		user.Role = role
+		storage.users[login] = user
+		storage.revokeUserSessions(login)
+		return nil
+	})
+}
+
+func (storage *MemoryStorage) BlockUser(ctx context.Context, login, reason string) error {
+	return memExec(ctx, storage, func() error {
+
+		user, ok := storage.users[login]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		user.Blocked, user.BlockedReason = true, reason
+		storage.users[login] = user
+		storage.revokeUserSessions(login)
+		return nil
+	})
+}
+
+func (storage *MemoryStorage) UnblockUser(ctx context.Context, login string) error {
+	return memExec(ctx, storage, func() error {
+
+		user, ok := storage.users[login]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		user.Blocked, user.BlockedReason = false, ""
+		storage.users[login] = user
+		return nil
+	})
+}
+
+func (storage *MemoryStorage) revokeUserSessions(userID string) {
+	for _, session := range storage.sessions {
+		if session.userID == userID {
+			session.revoked = true
+		}
+	}
+}
+
+func (storage *MemoryStorage) AdjustBalance(ctx context.Context, adjustment models.BalanceAdjustment) (models.BalanceAdjustment, error) {
+	return memTx(ctx, storage, func() (models.BalanceAdjustment, error) {
+
+		if err := validateAdjustment(adjustment); err != nil {
+			return models.BalanceAdjustment{}, err
+		}
+		if _, ok := storage.users[adjustment.UserID]; !ok {
+			return models.BalanceAdjustment{}, sql.ErrNoRows
+		}
+
+		kind, orderNumber := models.LedgerKindAdjustment, ""
+		if adjustment.ReasonCode == models.AdjustmentRefund {
+			withdrawn, err := storage.refundableSum(adjustment.UserID, adjustment.OrderNumber)
+			if err != nil {
+				return models.BalanceAdjustment{}, err
+			}
+			if adjustment.Amount, err = refundAmount(adjustment, withdrawn); err != nil {
+				return models.BalanceAdjustment{}, err
+			}
+			kind, orderNumber = models.LedgerKindRefund, adjustment.OrderNumber
+		}
+
+		adjustment.CreatedAt = time.Now()
+		if _, err := storage.postEntry(adjustment.UserID, kind, orderNumber, adjustment.Amount, adjustment.CreatedAt); err != nil {
+			return models.BalanceAdjustment{}, err
+		}
+		storage.ledger[len(storage.ledger)-1].ReasonCode = adjustment.ReasonCode
+		adjustment.ID = int64(len(storage.adjustments) + 1)
+		storage.adjustments = append(storage.adjustments, adjustment)
+		return adjustment, nil
+	})
+}
+
+// refundableSum - аналог refundableSum для Postgres.
+func (storage *MemoryStorage) refundableSum(userID, orderNumber string) (models.Amount, error) {
+
+	var withdrawn models.Amount
+	found := false
+	for _, e := range storage.ledger {
+		if e.Kind == models.LedgerKindWithdrawal && e.OrderNumber == orderNumber && storage.ledgerUser[e.ID] == userID {
+			withdrawn, found = -e.Amount, true
+			break
+		}
+	}
+	if !found {
+		return 0, fmt.Errorf("%w: нет списания по заказу %s", ErrInvalidRefund, orderNumber)
+	}
+
+	var refunded models.Amount
+	for _, a := range storage.adjustments {
+		if a.UserID == userID && a.OrderNumber == orderNumber && a.ReasonCode == models.AdjustmentRefund {
+			refunded += a.Amount
+		}
+	}
+	return remainingRefund(orderNumber, withdrawn, refunded)
+}
+
+func (storage *MemoryStorage) GetBalanceAdjustments(ctx context.Context, userID string) ([]models.BalanceAdjustment, error) {
+	return memTx(ctx, storage, func() ([]models.BalanceAdjustment, error) {
+
+		var adjustments []models.BalanceAdjustment
+		for _, a := range storage.adjustments {
+			if a.UserID == userID {
+				adjustments = append(adjustments, a)
+			}
+		}
+		return adjustments, nil
+	})
+}
+
+// enqueueWebhooks - аналог enqueueWebhooks для Postgres.
+func (storage *MemoryStorage) enqueueWebhooks(userID string, event models.WebhookEvent) error {
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	var ids []int64
+	for id, w := range storage.webhooks {
+		if w.userID == userID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	now := time.Now()
+	for _, id := range ids {
+		storage.nextID++
+		storage.deliveries = append(storage.deliveries, &memDelivery{delivery: models.WebhookDelivery{
+			ID:            storage.nextID,
+			WebhookID:     id,
+			Event:         event.Type,
+			Payload:       payload,
+			Status:        models.DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		}})
+	}
+	return nil
+}
+
+func (storage *MemoryStorage) enqueueStatusWebhooks(statuses []models.OrderStatusNew, t time.Time) error {
+
+	for _, v := range statuses {
+		event, ok := models.WebhookEventForStatus(v, t)
+		if !ok {
+			continue
+		}
+		if err := storage.enqueueWebhooks(storage.orders[v.Number].userID, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (storage *MemoryStorage) checkEntry(userID, kind, orderNumber string, amount models.Amount) error {
+
+	if orderNumber == "" && kind != models.LedgerKindAdjustment {
+		return ErrEmptyValue
+	}
+	if _, ok := storage.users[userID]; !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, userID)
+	}
+	// возвратов по заказу может быть несколько, как в частичном индексе ledger_entries_order_kind_idx
+	if _, ok := storage.ledgerKeys[orderNumber+"\x00"+kind]; ok && orderNumber != "" && kind != models.LedgerKindRefund {
+		return fmt.Errorf("%w: %s %s", ErrDuplicateKey, kind, orderNumber)
+	}
+
+	balance := storage.balances[userID]
+	if balance.Current+amount < 0 {
+		return ErrNotEnoughFunds
+	}
+	return nil
+}
+
+// postEntry - аналог проводки в журнале: amount - изменение баланса пользователя.
+// Возвращает id записи журнала.
+func (storage *MemoryStorage) postEntry(userID, kind, orderNumber string, amount models.Amount, t time.Time) (int64, error) {
+
+	if err := storage.checkEntry(userID, kind, orderNumber, amount); err != nil {
+		return 0, err
+	}
+
+	key := orderNumber + "\x00" + kind
+	balance := storage.balances[userID]
+	balance.Current += amount
+	// возврат уменьшает сумму списаний, как проводка на системный счет withdrawal в Postgres
+	if kind == models.LedgerKindWithdrawal || kind == models.LedgerKindRefund {
+		balance.Withdraw -= amount
+	}
+	storage.balances[userID] = balance
+
+	storage.nextID++
+	if orderNumber != "" {
+		storage.ledgerKeys[key] = struct{}{}
+	}
+	storage.ledgerUser[storage.nextID] = userID
+	storage.ledger = append(storage.ledger, models.LedgerEntry{
+		ID:          storage.nextID,
+		Kind:        kind,
+		OrderNumber: orderNumber,
+		Amount:      amount,
+		CreatedAt:   t,
+	})
+	return storage.nextID, nil
+}
+
+// latestBilling - строка billing с наибольшим time, как в GetOrders для Postgres.
+func (storage *MemoryStorage) latestBilling(number string) (memBilling, bool) {
+
+	rows := storage.billing[number]
+	if len(rows) == 0 {
+		return memBilling{}, false
+	}
+	latest := rows[0]
+	for _, b := range rows[1:] {
+		if !b.time.Before(latest.time) {
+			latest = b
+		}
+	}
+	return latest, true
+}
+
+func (storage *MemoryStorage) currentStatus(number string) string {
+
+	var current string
+	for _, b := range storage.billing[number] {
+		if models.StatusRank(b.status) > models.StatusRank(current) {
+			current = b.status
+		}
+	}
+	return current
+}
+
+func (storage *MemoryStorage) awaitingAccrual(number string) bool {
+	status := storage.currentStatus(number)
+	return status == models.StatusNew || status == models.StatusProcessing
+}
+
+// sortedOrders - заказы в порядке последнего изменения статуса.
+func (storage *MemoryStorage) sortedOrders() []*memOrder {
+
+	orders := make([]*memOrder, 0, len(storage.orders))
+	for _, order := range storage.orders {
+		orders = append(orders, order)
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		bi, _ := storage.latestBilling(orders[i].number)
+		bj, _ := storage.latestBilling(orders[j].number)
+		if !bi.time.Equal(bj.time) {
+			return bi.time.Before(bj.time)
+		}
+		return orders[i].number < orders[j].number
+	})
+	return orders
+}
+
+func inTimeRange(t time.Time, query models.PageQuery) bool {
+	return (query.From.IsZero() || !t.Before(query.From)) && (query.To.IsZero() || t.Before(query.To))
+}
+
+// afterCursor - строка со временем t идет после курсора в выбранном порядке.
+// keyLess и keyEqual сравнивают ключ строки с ключом курсора.
+func afterCursor(t time.Time, keyLess, keyEqual bool, cursor pageCursor, desc bool) bool {
+	if !t.Equal(cursor.Time) {
+		return t.After(cursor.Time) != desc
+	}
+	if keyEqual {
+		return false
+	}
+	return keyLess == desc
+}
+
+func containsString(list []string, value string) bool {
+	for _, s := range list {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats - у хранилища в памяти нет пула соединений.
+func (storage *MemoryStorage) Stats() PoolStats {
+	return PoolStats{}
+}
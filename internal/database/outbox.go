@@ -0,0 +1,210 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gophermart/internal/models"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// newOutboxEvent - событие для putOutbox с сериализованным payload.
+func newOutboxEvent(userID, eventType string, payload models.OutboxPayload) (models.OutboxEvent, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return models.OutboxEvent{}, err
+	}
+	return models.OutboxEvent{UserID: userID, Type: eventType, Payload: data}, nil
+}
+
+// statusOutboxEvents - события о примененных статусах заказов, owners - владельцы заказов.
+func statusOutboxEvents(owners map[string]string, statuses []models.OrderStatusNew) ([]models.OutboxEvent, error) {
+
+	events := make([]models.OutboxEvent, 0, len(statuses))
+	for _, v := range statuses {
+		event, err := newOutboxEvent(owners[v.Number], models.OutboxOrderStatus,
+			models.OutboxPayload{Order: v.Number, Status: v.Status, Accrual: v.Accrual})
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// putStatusOutbox записывает в outbox события о примененных статусах заказов.
+func putStatusOutbox(ctx context.Context, tx pgx.Tx, statuses []models.OrderStatusNew) error {
+
+	numbers := make([]string, 0, len(statuses))
+	for _, v := range statuses {
+		numbers = append(numbers, v.Number)
+	}
+	owners, err := orderOwners(ctx, tx, numbers)
+	if err != nil {
+		return err
+	}
+
+	events, err := statusOutboxEvents(owners, statuses)
+	if err != nil {
+		return err
+	}
+	return putOutbox(ctx, tx, events)
+}
+
+// putOutbox записывает события в outbox в транзакции, изменившей состояние.
+// Перед записью пользователи блокируются lockUsers, и relay не увидит событие
+// с большим id раньше события с меньшим.
+func putOutbox(ctx context.Context, tx pgx.Tx, events []models.OutboxEvent) error {
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	users := make([]string, 0, len(events))
+	seen := make(map[string]struct{})
+	userIDs := make([]string, 0, len(events))
+	types := make([]string, 0, len(events))
+	payloads := make([][]byte, 0, len(events))
+	for _, e := range events {
+		if _, ok := seen[e.UserID]; !ok {
+			seen[e.UserID] = struct{}{}
+			users = append(users, e.UserID)
+		}
+		userIDs = append(userIDs, e.UserID)
+		types = append(types, e.Type)
+		payloads = append(payloads, e.Payload)
+	}
+	if err := lockUsers(ctx, tx, users); err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO outbox (user_id, event_type, payload, attempts, next_attempt_at, created_at)
+	SELECT v.user_id, v.event_type, v.payload, 0, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+	FROM unnest($1::varchar[], $2::varchar[], $3::bytea[]) WITH ORDINALITY AS v(user_id, event_type, payload, n)
+	ORDER BY v.n`
+
+	if _, err := tx.Exec(ctx, query, userIDs, types, payloads); err != nil {
+		return fmt.Errorf("ошибка записи в outbox %w", err)
+	}
+	return nil
+}
+
+// lockUsers берет advisory-блокировку каждого пользователя до конца транзакции.
+// Id событий (outbox, order_events) выдаются при вставке, а не при commit: под блокировкой
+// параллельные транзакции одного пользователя получают id в порядке commit, и читатель,
+// идущий по id > последнего, не пропустит событие с меньшим id. Блокировка повторно
+// входима, ее можно брать перед каждой таблицей событий.
+func lockUsers(ctx context.Context, tx pgx.Tx, users []string) error {
+
+	// блокировки в одном порядке во всех транзакциях, чтобы не получить взаимоблокировку
+	sort.Strings(users)
+
+	lockQuery := `SELECT pg_advisory_xact_lock(hashtext('outbox:' || u))
+				  FROM unnest($1::varchar[]) WITH ORDINALITY AS v(u, n)
+				  ORDER BY v.n`
+	if _, err := tx.Exec(ctx, lockQuery, users); err != nil {
+		return fmt.Errorf("ошибка блокировки событий пользователей %w", err)
+	}
+	return nil
+}
+
+// LeaseOutbox захватывает relay на время ttl и возвращает до limit неопубликованных событий
+// по возрастанию id. Пока relay захвачен другим экземпляром, возвращает пустой список.
+// События пользователя, первое событие которого отложено после ошибки, не выдаются,
+// чтобы не нарушить порядок.
+func (storage *Storage) LeaseOutbox(ctx context.Context, instanceID string, limit int, ttl time.Duration) ([]models.OutboxEvent, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) ([]models.OutboxEvent, error) {
+
+		leaseQuery := `UPDATE outbox_relay
+					   SET locked_by = $1, locked_until = CURRENT_TIMESTAMP + $2::float8 * INTERVAL '1 second'
+					   WHERE id = 1 AND (locked_by = $1 OR locked_until IS NULL OR locked_until < CURRENT_TIMESTAMP)`
+
+		tag, err := tx.Exec(ctx, leaseQuery, instanceID, ttl.Seconds())
+		if err != nil {
+			return nil, err
+		}
+		if tag.RowsAffected() == 0 {
+			return nil, nil
+		}
+
+		query := `
+		SELECT o.id, o.user_id, o.event_type, o.payload, o.attempts, o.created_at
+		FROM outbox o
+		WHERE o.published_at IS NULL
+		AND NOT EXISTS (
+			SELECT 1 FROM outbox d
+			WHERE d.user_id = o.user_id AND d.published_at IS NULL
+			AND d.id <= o.id AND d.next_attempt_at > CURRENT_TIMESTAMP)
+		ORDER BY o.id
+		LIMIT $1`
+
+		rows, err := tx.Query(ctx, query, limit)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var events []models.OutboxEvent
+		for rows.Next() {
+			var e models.OutboxEvent
+			var payload []byte
+			if err := rows.Scan(&e.ID, &e.UserID, &e.Type, &payload, &e.Attempts, &e.CreatedAt); err != nil {
+				return nil, err
+			}
+			e.Payload = payload
+			events = append(events, e)
+		}
+		if err := rows.Err(); err != nil {
+			return events, err
+		}
+		return events, nil
+	})
+}
+
+// MarkOutboxPublished отмечает события, опубликованные во все sinks.
+func (storage *Storage) MarkOutboxPublished(ctx context.Context, ids []int64) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
+
+		query := `UPDATE outbox SET published_at = CURRENT_TIMESTAMP, last_error = NULL
+				  WHERE id = ANY($1) AND published_at IS NULL`
+
+		_, err := tx.Exec(ctx, query, ids)
+		return err
+	})
+}
+
+// DeleteOutboxPublished удаляет события, опубликованные раньше olderThan назад,
+// возвращает количество удаленных. Неопубликованные события не удаляются.
+func (storage *Storage) DeleteOutboxPublished(ctx context.Context, olderThan time.Duration) (int64, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (int64, error) {
+
+		deleteQuery := `DELETE FROM outbox
+						WHERE published_at < CURRENT_TIMESTAMP - $1::float8 * INTERVAL '1 second'`
+
+		tag, err := tx.Exec(ctx, deleteQuery, olderThan.Seconds())
+		if err != nil {
+			return int64(0), err
+		}
+		return tag.RowsAffected(), nil
+	})
+}
+
+// RetryOutboxEvent откладывает событие на retryIn после ошибки публикации.
+// Вместе с ним откладываются и следующие события пользователя, см. LeaseOutbox.
+func (storage *Storage) RetryOutboxEvent(ctx context.Context, id int64, retryIn time.Duration, lastError string) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
+
+		query := `UPDATE outbox
+				  SET attempts = attempts + 1,
+					  next_attempt_at = CURRENT_TIMESTAMP + $2::float8 * INTERVAL '1 second',
+					  last_error = $3
+				  WHERE id = $1`
+
+		_, err := tx.Exec(ctx, query, id, retryIn.Seconds(), lastError)
+		return err
+	})
+}
@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"gophermart/internal/models"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// pageFilter собирает условия WHERE и аргументы запроса постраничной выдачи.
+// В текст запроса попадают только номера параметров, значения передаются аргументами.
+type pageFilter struct {
+	conditions []string
+	args       []any
+}
+
+func (f *pageFilter) add(condition string, args ...any) {
+	placeholders := make([]any, len(args))
+	for i, arg := range args {
+		f.args = append(f.args, arg)
+		placeholders[i] = fmt.Sprintf("$%d", len(f.args))
+	}
+	f.conditions = append(f.conditions, fmt.Sprintf(condition, placeholders...))
+}
+
+func (f *pageFilter) where() string {
+	return strings.Join(f.conditions, " AND ")
+}
+
+// timeRange ограничивает column диапазоном [From, To) из query.
+// В таблицах timestamp без зоны с местным временем сервера, поэтому границы переводятся в time.Local.
+func (f *pageFilter) timeRange(column string, query models.PageQuery) {
+	if !query.From.IsZero() {
+		f.add(column+" >= %s", query.From.In(time.Local))
+	}
+	if !query.To.IsZero() {
+		f.add(column+" < %s", query.To.In(time.Local))
+	}
+}
+
+// pageOrder возвращает направление сортировки и оператор сравнения с курсором.
+func pageOrder(desc bool) (string, string) {
+	if desc {
+		return "DESC", "<"
+	}
+	return "ASC", ">"
+}
+
+// GetOrdersPage возвращает страницу заказов пользователя в порядке загрузки.
+// Статус заказа, как и в GetOrders, - последняя по времени строка billing.
+func (storage *Storage) GetOrdersPage(ctx context.Context, userID string, query models.PageQuery) (models.OrdersPage, error) {
+
+	cursor, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return models.OrdersPage{}, err
+	}
+
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (models.OrdersPage, error) {
+
+		direction, compare := pageOrder(query.Desc)
+		limit := query.PageLimit()
+
+		var filter pageFilter
+		filter.add("o.user_id = %s", userID)
+		if len(query.Statuses) > 0 {
+			filter.add("b.status = ANY(%s)", query.Statuses)
+		}
+		filter.timeRange("o.uploaded_at", query)
+		if cursor != nil {
+			filter.add("(o.uploaded_at, o.number) "+compare+" (%s::timestamp, %s::varchar)", cursor.Time, cursor.Number)
+		}
+		filter.args = append(filter.args, limit+1)
+
+		pageQuery := fmt.Sprintf(`
+		SELECT o.number, b.status, b.accrual, b.uploaded_at, o.uploaded_at
+		FROM orders o
+		JOIN LATERAL (
+			SELECT status, accrual, uploaded_at
+			FROM billing
+			WHERE billing.order_number = o.number
+			ORDER BY time DESC
+			LIMIT 1
+		) b ON true
+		WHERE %s
+		ORDER BY o.uploaded_at %s, o.number %s
+		LIMIT $%d`, filter.where(), direction, direction, len(filter.args))
+
+		rows, err := tx.Query(ctx, pageQuery, filter.args...)
+		if err != nil {
+			return models.OrdersPage{}, err
+		}
+		defer rows.Close()
+
+		var page models.OrdersPage
+		var last pageCursor
+		for rows.Next() {
+			var ordS models.OrderStatus
+			var uploadedAt time.Time
+			if err := rows.Scan(&ordS.Number, &ordS.Status, &ordS.Accrual, &ordS.UploadedAt, &uploadedAt); err != nil {
+				return models.OrdersPage{}, err
+			}
+			if len(page.Orders) == limit {
+				page.NextCursor = encodeCursor(last)
+				break
+			}
+			page.Orders = append(page.Orders, ordS)
+			last = pageCursor{Time: uploadedAt, Number: ordS.Number}
+		}
+		if err := rows.Err(); err != nil {
+			return models.OrdersPage{}, err
+		}
+		return page, nil
+	})
+}
+
+// GetWithdrawalsPage возвращает страницу списаний пользователя в порядке проведения.
+// Статусов у списаний нет, query.Statuses не учитывается.
+func (storage *Storage) GetWithdrawalsPage(ctx context.Context, userID string, query models.PageQuery) (models.WithdrawalsPage, error) {
+
+	cursor, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return models.WithdrawalsPage{}, err
+	}
+
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (models.WithdrawalsPage, error) {
+
+		direction, compare := pageOrder(query.Desc)
+		limit := query.PageLimit()
+
+		var filter pageFilter
+		filter.add("e.user_id = %s", userID)
+		filter.timeRange("e.created_at", query)
+		if cursor != nil {
+			filter.add("(e.created_at, e.id) "+compare+" (%s::timestamp, %s::bigint)", cursor.Time, cursor.ID)
+		}
+		filter.args = append(filter.args, limit+1)
+
+		pageQuery := fmt.Sprintf(`
+		SELECT e.id, e.order_number, p.amount AS sum, e.created_at AS processed_at
+		FROM ledger_entries e
+		JOIN ledger_postings p ON p.entry_id = e.id
+		JOIN ledger_accounts a ON a.id = p.account_id
+		WHERE e.kind = 'withdrawal'
+		AND a.user_id IS NULL AND a.kind = 'withdrawal'
+		AND %s
+		ORDER BY e.created_at %s, e.id %s
+		LIMIT $%d`, filter.where(), direction, direction, len(filter.args))
+
+		rows, err := tx.Query(ctx, pageQuery, filter.args...)
+		if err != nil {
+			return models.WithdrawalsPage{}, err
+		}
+		defer rows.Close()
+
+		var page models.WithdrawalsPage
+		var last pageCursor
+		for rows.Next() {
+			var id int64
+			var w models.Withdrawal
+			if err := rows.Scan(&id, &w.OrderNumber, &w.Sum, &w.ProcessedAt); err != nil {
+				return models.WithdrawalsPage{}, err
+			}
+			if len(page.Withdrawals) == limit {
+				page.NextCursor = encodeCursor(last)
+				break
+			}
+			page.Withdrawals = append(page.Withdrawals, w)
+			last = pageCursor{Time: w.ProcessedAt, ID: id}
+		}
+		if err := rows.Err(); err != nil {
+			return models.WithdrawalsPage{}, err
+		}
+		return page, nil
+	})
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrInvalidResetToken = errors.New("invalid password reset token")
+
+// ChangePassword сохраняет новый хеш пароля и отзывает все сессии пользователя, кроме keepSessionID,
+// а также неиспользованные токены сброса. Возвращает число отозванных сессий.
+func (storage *Storage) ChangePassword(ctx context.Context, userID, hash, keepSessionID string) (int64, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (int64, error) {
+		return changePassword(ctx, tx, userID, hash, keepSessionID)
+	})
+}
+
+func changePassword(ctx context.Context, tx pgx.Tx, userID, hash, keepSessionID string) (int64, error) {
+
+	tag, err := tx.Exec(ctx, `UPDATE users SET hash = $2 WHERE user_id = $1`, userID, hash)
+	if err != nil {
+		return 0, err
+	}
+	if tag.RowsAffected() == 0 {
+		return 0, sql.ErrNoRows
+	}
+
+	resetQuery := `UPDATE password_reset_tokens SET used_at = CURRENT_TIMESTAMP
+				   WHERE user_id = $1 AND used_at IS NULL`
+	if _, err := tx.Exec(ctx, resetQuery, userID); err != nil {
+		return 0, err
+	}
+
+	revokeQuery := `UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+					WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL`
+	revoked, err := tx.Exec(ctx, revokeQuery, userID, keepSessionID)
+	if err != nil {
+		return 0, err
+	}
+	return revoked.RowsAffected(), nil
+}
+
+// AddPasswordResetToken сохраняет токен сброса пароля со сроком ttl.
+// Действует только последний запрошенный токен: прежние неиспользованные гасятся.
+func (storage *Storage) AddPasswordResetToken(ctx context.Context, userID, tokenHash string, ttl time.Duration) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
+
+		expireQuery := `UPDATE password_reset_tokens SET used_at = CURRENT_TIMESTAMP
+						WHERE user_id = $1 AND used_at IS NULL`
+		if _, err := tx.Exec(ctx, expireQuery, userID); err != nil {
+			return err
+		}
+
+		query := `INSERT INTO password_reset_tokens (token_hash, user_id, created_at, expires_at)
+				  VALUES ($1, $2, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP + $3::float8 * INTERVAL '1 second')`
+		_, err := tx.Exec(ctx, query, tokenHash, userID, ttl.Seconds())
+		return err
+	})
+}
+
+// ResetPassword гасит токен сброса, сохраняет новый хеш пароля и отзывает все сессии пользователя.
+// Возвращает пользователя; использованный, истекший или неизвестный токен - ErrInvalidResetToken.
+func (storage *Storage) ResetPassword(ctx context.Context, tokenHash, hash string) (string, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (string, error) {
+
+		query := `UPDATE password_reset_tokens SET used_at = CURRENT_TIMESTAMP
+				  WHERE token_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+				  RETURNING user_id`
+
+		var userID string
+		err := tx.QueryRow(ctx, query, tokenHash).Scan(&userID)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return "", ErrInvalidResetToken
+		case err != nil:
+			return "", err
+		}
+
+		if _, err := changePassword(ctx, tx, userID, hash, ""); err != nil {
+			return "", err
+		}
+		return userID, nil
+	})
+}
@@ -6,83 +6,94 @@ import (
 	"errors"
 	"fmt"
 	"gophermart/internal/models"
-	"strings"
 	"time"
-)
 
-func (storage *Storage) GetUser(ctx context.Context, login string) DBOperation {
+	"github.com/jackc/pgx/v5"
+)
 
-	return func(ctx context.Context, tx *sql.Tx) (interface{}, error) {
+func (storage *Storage) GetUser(ctx context.Context, login string) (models.User, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (models.User, error) {
 
-		getUserQuery := `SELECT user_id, hash from users WHERE user_id=$1;`
+		getUserQuery := `SELECT user_id, hash, role, blocked_at IS NOT NULL, COALESCE(blocked_reason, '')
+						 FROM users WHERE user_id=$1;`
 		var user models.User
-		err := tx.QueryRowContext(ctx, getUserQuery, login).Scan(&user.Login, &user.Hash)
+		err := tx.QueryRow(ctx, getUserQuery, login).Scan(&user.Login, &user.Hash, &user.Role, &user.Blocked, &user.BlockedReason)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// вызывающий код, как и раньше, проверяет sql.ErrNoRows
+			return user, sql.ErrNoRows
+		}
 
 		return user, err
-	}
-
+	})
 }
 
-func (storage *Storage) AddUser(ctx context.Context, UserID, hash string) DBOperation {
-	return func(ctx context.Context, tx *sql.Tx) (interface{}, error) {
+func (storage *Storage) AddUser(ctx context.Context, UserID, hash string) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
 
 		addUserQuery := `INSERT INTO users(user_id, hash) VALUES ($1, $2)`
 
-		_, err := tx.ExecContext(ctx, addUserQuery, UserID, hash)
-		return nil, err
-	}
+		_, err := tx.Exec(ctx, addUserQuery, UserID, hash)
+		return err
+	})
 }
 
-func (storage *Storage) AddOrder(ctx context.Context, orderNumber string, userID string) DBOperation {
+func (storage *Storage) UpdateUserHash(ctx context.Context, UserID, hash string) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
+
+		updateHashQuery := `UPDATE users SET hash = $2 WHERE user_id = $1`
+
+		_, err := tx.Exec(ctx, updateHashQuery, UserID, hash)
+		return err
+	})
+}
 
-	return func(ctx context.Context, tx *sql.Tx) (interface{}, error) {
+func (storage *Storage) AddOrder(ctx context.Context, orderNumber string, userID string) (models.OrderUserID, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (models.OrderUserID, error) {
 
 		getOrderQuery := `SELECT number, user_id FROM orders
 						  WHERE orders.number = $1`
 
 		var orderUserID models.OrderUserID
-		err := tx.QueryRowContext(ctx, getOrderQuery, orderNumber).Scan(&orderUserID.OrderNumber, &orderUserID.UserID)
+		err := tx.QueryRow(ctx, getOrderQuery, orderNumber).Scan(&orderUserID.OrderNumber, &orderUserID.UserID)
 
 		switch {
-		case errors.Is(err, sql.ErrNoRows):
+		case errors.Is(err, pgx.ErrNoRows):
 			t := time.Now()
 			addOrderQuery := `INSERT INTO orders(number, user_id, uploaded_at) VALUES ($1, $2, $3)`
-			_, err = tx.ExecContext(ctx, addOrderQuery, orderNumber, userID, t)
+			_, err = tx.Exec(ctx, addOrderQuery, orderNumber, userID, t)
 			if err != nil {
 				return models.OrderUserID{}, err
 			}
 
 			addBillingQuery := `INSERT INTO billing (order_number, status, accrual, uploaded_at, time)
 								VALUES ($1, 'NEW', 0, $2, CURRENT_TIMESTAMP)`
-			_, err = tx.ExecContext(ctx, addBillingQuery, orderNumber, t)
+			_, err = tx.Exec(ctx, addBillingQuery, orderNumber, t)
 
 			if err != nil {
 				return models.OrderUserID{}, err
 			}
 
+			event, err := newOutboxEvent(userID, models.OutboxOrderUploaded, models.OutboxPayload{Order: orderNumber})
+			if err != nil {
+				return models.OrderUserID{}, err
+			}
+			if err := putOutbox(ctx, tx, []models.OutboxEvent{event}); err != nil {
+				return models.OrderUserID{}, err
+			}
+			return models.OrderUserID{}, nil
+
 		case err != nil:
 			return models.OrderUserID{}, err
 		}
 
 		return orderUserID, err
-	}
+	})
 }
 
-func (storage *Storage) GetOrders(ctx context.Context, userID string) DBOperation {
-
-	return func(ctx context.Context, tx *sql.Tx) (interface{}, error) {
-
-		query := `SELECT orders.number, billing.status, billing.accrual as accrual, billing.uploaded_at
-				 FROM orders 
-				 JOIN billing ON orders.number = billing.order_number
-				 WHERE orders.user_id = $1
-				 AND billing.time = (
-				 SELECT MAX(time)
-				 FROM billing
-				 WHERE billing.order_number = orders.number)			
-				 ORDER BY billing.time ASC,orders.uploaded_at ASC`
-		// 	 AND billing.status != 'WITHDRAWN') убраьть ) после  orders.number)	если буду вставлять
-		rows, err := tx.QueryContext(ctx, query, userID)
+func (storage *Storage) GetOrders(ctx context.Context, userID string) ([]models.OrderStatus, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) ([]models.OrderStatus, error) {
+
+		rows, err := tx.Query(ctx, stmtGetOrders, userID)
 		if err != nil {
 			return nil, err
 		}
@@ -95,7 +106,6 @@ func (storage *Storage) GetOrders(ctx context.Context, userID string) DBOperatio
 			if err != nil {
 				return nil, err
 			}
-			ordS.Accrual = ordS.Accrual / 1000
 			orderStatusList = append(orderStatusList, ordS)
 		}
 
@@ -104,104 +114,151 @@ func (storage *Storage) GetOrders(ctx context.Context, userID string) DBOperatio
 		}
 
 		return orderStatusList, nil
-	}
+	})
 }
 
-func (storage *Storage) GetBalance(ctx context.Context, userID string) DBOperation {
-	return func(ctx context.Context, tx *sql.Tx) (interface{}, error) {
+// GetOrder возвращает заказ с историей статусов, ErrOrderNotFound - заказа нет.
+// Владельца заказа проверяет вызывающий код по OrderDetails.UserID.
+func (storage *Storage) GetOrder(ctx context.Context, number string) (models.OrderDetails, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (models.OrderDetails, error) {
 
-		getBalanceQuery := `	
-		SELECT
-		COALESCE(SUM(CASE WHEN billing.status = 'PROCESSED' THEN billing.accrual ELSE 0 END),0) AS PROCESSED,
-		COALESCE(SUM(CASE WHEN billing.status = 'WITHDRAWN' THEN billing.accrual ELSE 0 END),0) AS WITHDRAWN
-		FROM orders 
-		JOIN billing ON orders.number = billing.order_number
-		WHERE orders.user_id =$1  AND billing.status IN ('PROCESSED', 'WITHDRAWN');`
+		getOrderQuery := `SELECT number, user_id, uploaded_at FROM orders WHERE number = $1`
 
-		var balance models.Balance
+		var details models.OrderDetails
+		err := tx.QueryRow(ctx, getOrderQuery, number).Scan(&details.Number, &details.UserID, &details.UploadedAt)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return models.OrderDetails{}, ErrOrderNotFound
+		case err != nil:
+			return models.OrderDetails{}, err
+		}
 
-		err := tx.QueryRowContext(ctx, getBalanceQuery, userID).Scan(&balance.Current, &balance.Withdraw)
+		historyQuery := `SELECT status, accrual, time FROM billing
+						 WHERE order_number = $1
+						 ORDER BY time ASC`
 
+		rows, err := tx.Query(ctx, historyQuery, number)
 		if err != nil {
-			return models.Balance{}, err
+			return models.OrderDetails{}, err
 		}
+		defer rows.Close()
 
-		balance.Current = (balance.Current - balance.Withdraw) / 1000
-		balance.Withdraw = balance.Withdraw / 1000
+		for rows.Next() {
+			var t models.StatusTransition
+			if err := rows.Scan(&t.Status, &t.Accrual, &t.Time); err != nil {
+				return models.OrderDetails{}, err
+			}
+			details.History = append(details.History, t)
+		}
+		if err := rows.Err(); err != nil {
+			return models.OrderDetails{}, err
+		}
 
-		return balance, err
-	}
+		if n := len(details.History); n > 0 {
+			details.Status = details.History[n-1].Status
+			details.Accrual = details.History[n-1].Accrual
+		}
+		return details, nil
+	})
 }
 
-func (storage *Storage) WithdrawBalance(ctx context.Context, userID string, orderSum models.OrderSum) DBOperation {
+// GetBalance читает кеш баланса, который ведется вместе с журналом проводок.
+func (storage *Storage) GetBalance(ctx context.Context, userID string) (models.Balance, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (models.Balance, error) {
 
-	return func(ctx context.Context, tx *sql.Tx) (interface{}, error) {
+		var balance models.Balance
 
-		getBalanceQuery := `
-		SELECT
-		COALESCE(SUM(CASE WHEN billing.status = 'PROCESSED' THEN billing.accrual ELSE 0 END),0) AS PROCESSED,
-		COALESCE(SUM(CASE WHEN billing.status = 'WITHDRAWN' THEN billing.accrual ELSE 0 END),0) AS WITHDRAWN
-		FROM orders 
-		JOIN billing ON orders.number = billing.order_number
-		WHERE orders.user_id =$1  AND billing.status IN ('PROCESSED', 'WITHDRAWN');`
+		err := tx.QueryRow(ctx, stmtGetBalance, userID).Scan(&balance.Current, &balance.Withdraw)
 
-		var balance models.Balance
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			// проводок по пользователю еще не было
+			return models.Balance{}, nil
+		case err != nil:
+			return models.Balance{}, err
+		}
 
-		err := tx.QueryRowContext(ctx, getBalanceQuery, userID).Scan(&balance.Current, &balance.Withdraw)
+		return balance, nil
+	})
+}
 
-		if err != nil {
-			return nil, err
+func (storage *Storage) WithdrawBalance(ctx context.Context, userID string, orderSum models.OrderSum) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
+
+		if orderSum.Sum <= 0 {
+			return ErrNonPositiveSum
 		}
-		balance.Current = (balance.Current - balance.Withdraw) / 1000
-		balance.Withdraw = balance.Withdraw / 1000
 
-		if balance.Current-balance.Withdraw < orderSum.Sum {
-			return nil, ErrNotEnoughFunds
+		// блокируем строку баланса до конца транзакции: параллельное списание
+		// дождется commit и увидит уже уменьшенный баланс
+		lockBalanceQuery := `SELECT current FROM user_balances WHERE user_id = $1 FOR UPDATE`
+
+		var current models.Amount
+		err := tx.QueryRow(ctx, lockBalanceQuery, userID).Scan(&current)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrNotEnoughFunds
+		case err != nil:
+			return err
+		}
+
+		if current < orderSum.Sum {
+			return ErrNotEnoughFunds
 		}
 
 		getOrderQuery := `SELECT number, user_id FROM orders
 						  WHERE orders.number = $1`
 
 		var orderUserID models.OrderUserID
-		err = tx.QueryRowContext(ctx, getOrderQuery, orderSum.OrderNumber).Scan(&orderUserID.OrderNumber, &orderUserID.UserID)
+		err = tx.QueryRow(ctx, getOrderQuery, orderSum.OrderNumber).Scan(&orderUserID.OrderNumber, &orderUserID.UserID)
 
 		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			t := time.Now()
-			addOrderQuery := `INSERT INTO orders(number, user_id, uploaded_at) VALUES ($1, $2, $3)`
-			_, err = tx.ExecContext(ctx, addOrderQuery, orderSum.OrderNumber, userID, t)
-			if err != nil {
-				return models.OrderUserID{}, err
-			}
+		case errors.Is(err, pgx.ErrNoRows):
 		case err != nil:
-			return models.OrderUserID{}, fmt.Errorf("ошибка при получении ордера: %w", err)
+			return fmt.Errorf("ошибка при получении ордера: %w", err)
 		default:
 			if orderUserID.UserID != userID {
 
-				return models.OrderUserID{}, fmt.Errorf("нельзя вывести деньги другому пользователю %s", orderUserID.UserID)
+				return fmt.Errorf("нельзя вывести деньги другому пользователю %s", orderUserID.UserID)
 			}
 		}
 
-		addOrderQuery := `INSERT INTO billing (order_number, status, accrual, uploaded_at, time)
-		VALUES ($1, 'WITHDRAWN', $2, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP);
-	`
-		_, err = tx.ExecContext(ctx, addOrderQuery, orderSum.OrderNumber, orderSum.Sum*1000)
+		t := time.Now()
+		_, err = postEntry(ctx, tx, withdrawalEntry(userID, orderSum.OrderNumber, orderSum.Sum, t))
+		if err != nil {
+			return err
+		}
 
-		return nil, err
-	}
+		event, err := newOutboxEvent(userID, models.OutboxWithdrawal, models.OutboxPayload{Order: orderSum.OrderNumber, Sum: orderSum.Sum})
+		if err != nil {
+			return err
+		}
+		if err := putOutbox(ctx, tx, []models.OutboxEvent{event}); err != nil {
+			return err
+		}
+
+		return enqueueWebhooks(ctx, tx, userID, models.WebhookEvent{
+			Type:  models.WebhookEventWithdrawal,
+			Order: orderSum.OrderNumber,
+			Sum:   orderSum.Sum,
+			Time:  t,
+		})
+	})
 }
 
-func (storage *Storage) GetWithdrawals(ctx context.Context, userID string) DBOperation {
-	return func(ctx context.Context, tx *sql.Tx) (interface{}, error) {
+func (storage *Storage) GetWithdrawals(ctx context.Context, userID string) ([]models.Withdrawal, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) ([]models.Withdrawal, error) {
 
-		queryWithdrawals := `SELECT orders.number, billing.accrual AS sum, billing.uploaded_at AS processed_at
-			FROM orders
-			JOIN billing ON orders.number = billing.order_number
-			WHERE orders.user_id = $1
-			AND billing.status = 'WITHDRAWN'
-			ORDER BY billing.uploaded_at asc;`
+		queryWithdrawals := `SELECT e.order_number, p.amount AS sum, e.created_at AS processed_at
+			FROM ledger_entries e
+			JOIN ledger_postings p ON p.entry_id = e.id
+			JOIN ledger_accounts a ON a.id = p.account_id
+			WHERE e.user_id = $1
+			AND e.kind = 'withdrawal'
+			AND a.user_id IS NULL AND a.kind = 'withdrawal'
+			ORDER BY e.created_at asc;`
 
-		rows, err := tx.QueryContext(ctx, queryWithdrawals, userID)
+		rows, err := tx.Query(ctx, queryWithdrawals, userID)
 		if err != nil {
 			return nil, err
 		}
@@ -214,45 +271,259 @@ func (storage *Storage) GetWithdrawals(ctx context.Context, userID string) DBOpe
 			if err != nil {
 				return nil, err
 			}
-			w.Sum = w.Sum / 1000
 			withdrawalsList = append(withdrawalsList, w)
 		}
 		if err := rows.Err(); err != nil {
 			return withdrawalsList, err
 		}
 		return withdrawalsList, nil
+	})
+}
+
+// PutStatuses сохраняет статусы из системы расчета. Переходы, недопустимые
+// по models.CanTransition, отбрасываются. Возвращает реально примененные изменения.
+func (storage *Storage) PutStatuses(ctx context.Context, orderStatus *[]models.OrderStatusNew) ([]models.OrderStatusNew, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) ([]models.OrderStatusNew, error) {
+
+		numbers := make([]string, 0, len(*orderStatus))
+		for _, v := range *orderStatus {
+			numbers = append(numbers, v.Number)
+		}
+
+		current, err := currentStatuses(ctx, tx, numbers)
+		if err != nil {
+			return nil, err
+		}
+
+		// несколько статусов одного заказа в пачке схлопываем в последний допустимый,
+		// иначе в billing появятся строки с одинаковым time
+		var applied []models.OrderStatusNew
+		index := make(map[string]int)
+		for _, v := range *orderStatus {
+			if !models.CanTransition(current[v.Number], v.Status) {
+				continue
+			}
+			current[v.Number] = v.Status
+			if i, ok := index[v.Number]; ok {
+				applied[i] = v
+				continue
+			}
+			index[v.Number] = len(applied)
+			applied = append(applied, v)
+		}
+
+		if len(applied) == 0 {
+			return applied, nil
+		}
+
+		t := time.Now()
+		if err := putBillingRows(ctx, tx, applied, t, storage.statusBatchSize); err != nil {
+			return nil, err
+		}
+
+		if err := postAccruals(ctx, tx, applied, t); err != nil {
+			return nil, err
+		}
+
+		if err := putOrderEvents(ctx, tx, applied); err != nil {
+			return nil, err
+		}
+
+		if err := putStatusOutbox(ctx, tx, applied); err != nil {
+			return nil, err
+		}
+
+		if err := enqueueStatusWebhooks(ctx, tx, applied, t); err != nil {
+			return nil, err
+		}
+
+		// заказ нашелся в системе расчета - сбрасываем отсчет для MarkUnregistered
+		resetQuery := `UPDATE orders SET unregistered_since = NULL
+					   WHERE number = ANY($1) AND unregistered_since IS NOT NULL`
+		_, err = tx.Exec(ctx, resetQuery, numbers)
+		if err != nil {
+			return nil, err
+		}
+
+		return applied, nil
+	})
+}
+
+// putBillingRows записывает статусы в billing пачками по batchSize запросов.
+// Номера заказов и статусы приходят из ответа accrual, поэтому передаются только параметрами.
+func putBillingRows(ctx context.Context, tx pgx.Tx, statuses []models.OrderStatusNew, t time.Time, batchSize int) error {
+
+	query := `INSERT INTO billing (order_number, status, accrual, uploaded_at, time)
+			  VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+			  ON CONFLICT (order_number, status)
+			  DO UPDATE SET accrual = EXCLUDED.accrual, uploaded_at = EXCLUDED.uploaded_at`
+
+	if batchSize <= 0 {
+		batchSize = defaultStatusBatchSize
 	}
+
+	for start := 0; start < len(statuses); start += batchSize {
+
+		batch := &pgx.Batch{}
+		for _, v := range statuses[start:min(start+batchSize, len(statuses))] {
+			batch.Queue(query, v.Number, v.Status, v.Accrual, t)
+		}
+		if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+			return fmt.Errorf("ошибка записи статусов %w", err)
+		}
+	}
+	return nil
 }
 
-func (storage *Storage) GetNewProcessedOrders(ctx context.Context) DBOperation {
-	return func(ctx context.Context, tx *sql.Tx) (interface{}, error) {
-		query := `
-		SELECT b.order_number
-		FROM billing b
-		WHERE (b.order_number, b.time) IN (
-			SELECT mt.order_number, MAX(mt.max_time) AS max_time
-			FROM (
-				SELECT order_number, MAX(time) AS max_time
-				FROM billing
-				GROUP BY order_number
-			) AS mt
-			WHERE b.order_number = mt.order_number
-			GROUP BY mt.order_number
-		) AND b.status IN ('PROCESSING', 'NEW') ;
-		`
-		var ordersList []string
-		rows, err := tx.QueryContext(ctx, query)
+// postAccruals проводит по журналу начисления для заказов, перешедших в PROCESSED.
+func postAccruals(ctx context.Context, tx pgx.Tx, applied []models.OrderStatusNew, t time.Time) error {
+
+	var processed []string
+	for _, v := range applied {
+		if v.Status == models.StatusProcessed && v.Accrual > 0 {
+			processed = append(processed, v.Number)
+		}
+	}
+	if len(processed) == 0 {
+		return nil
+	}
+
+	owners, err := orderOwners(ctx, tx, processed)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range applied {
+		if v.Status != models.StatusProcessed || v.Accrual <= 0 {
+			continue
+		}
+		if _, err := postEntry(ctx, tx, accrualEntry(owners[v.Number], v.Number, v.Accrual, t)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// orderOwners возвращает владельцев заказов по номерам.
+func orderOwners(ctx context.Context, tx pgx.Tx, numbers []string) (map[string]string, error) {
+
+	owners := make(map[string]string)
+	rows, err := tx.Query(ctx, `SELECT number, user_id FROM orders WHERE number = ANY($1)`, numbers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var number, userID string
+		if err := rows.Scan(&number, &userID); err != nil {
+			return nil, err
+		}
+		owners[number] = userID
+	}
+	return owners, rows.Err()
+}
+
+// MarkUnregistered отмечает заказы, о которых система расчета ответила 204.
+// Заказы, не зарегистрированные дольше giveUpAfter, переводятся в INVALID
+// (giveUpAfter == 0 - ждать бесконечно). Возвращает заказы, переведенные в INVALID.
+func (storage *Storage) MarkUnregistered(ctx context.Context, numbers []string, giveUpAfter time.Duration) ([]models.OrderStatusNew, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) ([]models.OrderStatusNew, error) {
+
+		markQuery := `UPDATE orders SET unregistered_since = CURRENT_TIMESTAMP
+					  WHERE number = ANY($1) AND unregistered_since IS NULL`
+		_, err := tx.Exec(ctx, markQuery, numbers)
 		if err != nil {
 			return nil, err
 		}
-		defer rows.Close()
 
-		var orderNumber string
+		var invalid []models.OrderStatusNew
+		if giveUpAfter <= 0 {
+			return invalid, nil
+		}
+
+		giveUpQuery := `
+		INSERT INTO billing (order_number, status, accrual, uploaded_at, time)
+		SELECT o.number, 'INVALID', 0, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+		FROM orders o
+		WHERE o.number = ANY($1)
+		AND o.unregistered_since < CURRENT_TIMESTAMP - $2::float8 * INTERVAL '1 second'
+		AND NOT EXISTS (
+			SELECT 1 FROM billing b
+			WHERE b.order_number = o.number AND b.status IN ('PROCESSED', 'INVALID', 'WITHDRAWN'))
+		RETURNING order_number, uploaded_at;`
+
+		rows, err := tx.Query(ctx, giveUpQuery, numbers, giveUpAfter.Seconds())
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
 
 		for rows.Next() {
+			order := models.OrderStatusNew{Status: models.StatusInvalid}
+			if err := rows.Scan(&order.Number, &order.UploadedAt); err != nil {
+				return nil, err
+			}
+			invalid = append(invalid, order)
+		}
+		if err := rows.Err(); err != nil {
+			return invalid, err
+		}
 
-			err := rows.Scan(&orderNumber)
-			if err != nil {
+		if len(invalid) > 0 {
+			if err := putOrderEvents(ctx, tx, invalid); err != nil {
+				return nil, err
+			}
+			if err := putStatusOutbox(ctx, tx, invalid); err != nil {
+				return nil, err
+			}
+			if err := enqueueStatusWebhooks(ctx, tx, invalid, time.Now()); err != nil {
+				return nil, err
+			}
+		}
+		return invalid, nil
+	})
+}
+
+// currentStatuses возвращает текущий статус каждого заказа - статус с наибольшим models.StatusRank.
+func currentStatuses(ctx context.Context, tx pgx.Tx, numbers []string) (map[string]string, error) {
+
+	query := `SELECT order_number, status FROM billing WHERE order_number = ANY($1)`
+	rows, err := tx.Query(ctx, query, numbers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	current := make(map[string]string)
+	for rows.Next() {
+		var number, status string
+		if err := rows.Scan(&number, &status); err != nil {
+			return nil, err
+		}
+		if models.StatusRank(status) > models.StatusRank(current[number]) {
+			current[number] = status
+		}
+	}
+	return current, rows.Err()
+}
+
+// LeaseOrders захватывает до limit заказов, ожидающих расчета, на время ttl.
+// Заказы, захваченные другим экземпляром (lease не истек или строка заблокирована
+// параллельной транзакцией), пропускаются, поэтому несколько экземпляров
+// gophermart не опрашивают accrual по одному заказу.
+func (storage *Storage) LeaseOrders(ctx context.Context, instanceID string, limit int, ttl time.Duration) ([]string, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) ([]string, error) {
+
+		rows, err := tx.Query(ctx, stmtLeaseOrders, instanceID, ttl.Seconds(), limit)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var ordersList []string
+		for rows.Next() {
+			var orderNumber string
+			if err := rows.Scan(&orderNumber); err != nil {
 				return nil, err
 			}
 			ordersList = append(ordersList, orderNumber)
@@ -261,42 +532,56 @@ func (storage *Storage) GetNewProcessedOrders(ctx context.Context) DBOperation {
 			return ordersList, err
 		}
 		return ordersList, nil
-	}
+	})
 }
 
-func (storage *Storage) PutStatuses(ctx context.Context, orderStatus *[]models.OrderStatusNew) DBOperation {
-	return func(ctx context.Context, tx *sql.Tx) (interface{}, error) {
+// RenewLeases продлевает lease на заказы, которые экземпляр еще обрабатывает.
+// Возвращает заказы, lease на которые удалось продлить.
+func (storage *Storage) RenewLeases(ctx context.Context, instanceID string, numbers []string, ttl time.Duration) ([]string, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) ([]string, error) {
 
-		t := time.Now()
-		builder := strings.Builder{}
-		builder.WriteString("INSERT INTO billing (order_number, status, accrual, uploaded_at, time)\n")
-		builder.WriteString("VALUES\n")
-		for m, v := range *orderStatus {
+		query := `UPDATE orders SET locked_until = CURRENT_TIMESTAMP + $3::float8 * INTERVAL '1 second'
+				  WHERE number = ANY($2) AND locked_by = $1
+				  RETURNING number;`
 
-			builder.WriteString(fmt.Sprintf("(%s,'%s',%v,%v,%s)", v.Number, v.Status, v.Accrual*1000, "$1", "CURRENT_TIMESTAMP"))
+		rows, err := tx.Query(ctx, query, instanceID, numbers, ttl.Seconds())
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
 
-			if m == len(*orderStatus)-1 {
-				builder.WriteString("\n")
-			} else {
-				builder.WriteString(",\n")
+		var renewed []string
+		for rows.Next() {
+			var orderNumber string
+			if err := rows.Scan(&orderNumber); err != nil {
+				return nil, err
 			}
-
+			renewed = append(renewed, orderNumber)
 		}
-		builder.WriteString("ON CONFLICT (order_number, status)\n")
-		builder.WriteString("DO UPDATE SET order_number = EXCLUDED.order_number, status = EXCLUDED.status, accrual = EXCLUDED.accrual,  uploaded_at = EXCLUDED.uploaded_at;")
-		query := builder.String()
+		if err := rows.Err(); err != nil {
+			return renewed, err
+		}
+		return renewed, nil
+	})
+}
 
-		_, err := tx.ExecContext(ctx, query, t)
+// ReleaseLeases освобождает заказы, чтобы их можно было снова опросить.
+func (storage *Storage) ReleaseLeases(ctx context.Context, instanceID string, numbers []string) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
 
-		return models.OrderUserID{}, err
-	}
+		query := `UPDATE orders SET locked_by = NULL, locked_until = NULL
+				  WHERE number = ANY($2) AND locked_by = $1`
+
+		_, err := tx.Exec(ctx, query, instanceID, numbers)
+		return err
+	})
 }
 
 // этот метод написан для тестирования
 // func (storage *Storage) GetBilling(ctx context.Context) DBOperation {
-// 	return func(ctx context.Context, tx *sql.Tx) (interface{}, error) {
+// 	return func(ctx context.Context, tx pgx.Tx) (interface{}, error) {
 // 		query := `SELECT order_number, status, accrual AS accrual, uploaded_at, time FROM billing;`
-// 		rows, err := tx.QueryContext(ctx, query)
+// 		rows, err := tx.Query(ctx, query)
 // 		if err != nil {
 // 			return nil, err
 // 		}
@@ -308,7 +593,6 @@ func (storage *Storage) PutStatuses(ctx context.Context, orderStatus *[]models.O
 // 			if err != nil {
 // 				return nil, err
 // 			}
-// 			b.Accrual = b.Accrual / 1000
 // 			billingList = append(billingList, b)
 // 		}
 // 		if err := rows.Err(); err != nil {
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"database/sql/driver"
+	"errors"
+	"gophermart/utils"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// SQLSTATE ошибок, после которых транзакцию можно повторить целиком.
+const (
+	sqlStateSerializationFailure = "40001"
+	sqlStateDeadlockDetected     = "40P01"
+	sqlStateAdminShutdown        = "57P01"
+	sqlStateCrashShutdown        = "57P02"
+	sqlStateCannotConnectNow     = "57P03"
+	// класс 08 - connection exception
+	sqlStateClassConnection = "08"
+)
+
+// RetryPolicy решает, повторять ли транзакцию и сколько ждать перед повтором.
+// Паузы растут экспоненциально от BaseDelay до MaxDelay со случайным разбросом (full jitter),
+// все попытки вместе с паузами укладываются в Budget.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Budget      time.Duration
+	// rand возвращает число в [0, 1), подменяется в тестах
+	rand func() float64
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   100 * time.Millisecond,
+		MaxDelay:    5 * time.Second,
+		Budget:      10 * time.Second,
+	}
+}
+
+// commitError - ошибка COMMIT. Если соединение оборвалось на COMMIT, неизвестно,
+// применилась ли транзакция, поэтому повторяем только явный откат сервером.
+type commitError struct {
+	err error
+}
+
+func (e *commitError) Error() string {
+	return "ошибка при выполнении commit " + e.err.Error()
+}
+
+func (e *commitError) Unwrap() error {
+	return e.err
+}
+
+// Retriable - ошибку вызвал конфликт транзакций или потеря соединения, повтор может помочь.
+func (p RetryPolicy) Retriable(err error) bool {
+
+	code := sqlState(err)
+
+	var ce *commitError
+	if errors.As(err, &ce) {
+		return code == sqlStateSerializationFailure || code == sqlStateDeadlockDetected
+	}
+
+	switch {
+	case code == sqlStateSerializationFailure,
+		code == sqlStateDeadlockDetected,
+		code == sqlStateAdminShutdown,
+		code == sqlStateCrashShutdown,
+		code == sqlStateCannotConnectNow,
+		strings.HasPrefix(code, sqlStateClassConnection):
+		return true
+	case code != "":
+		return false
+	}
+
+	return errors.Is(err, driver.ErrBadConn) || pgconn.SafeToRetry(err) || utils.OnDialErr(err)
+}
+
+// Backoff возвращает паузу перед попыткой attempt (с нуля) и false, если повторять больше нельзя:
+// попытки кончились или пауза не укладывается в остаток бюджета после elapsed.
+func (p RetryPolicy) Backoff(attempt int, elapsed time.Duration) (time.Duration, bool) {
+
+	if attempt+1 >= p.MaxAttempts {
+		return 0, false
+	}
+
+	ceiling := p.MaxDelay
+	if attempt < 32 {
+		if d := p.BaseDelay << attempt; d > 0 && d < ceiling {
+			ceiling = d
+		}
+	}
+
+	random := rand.Float64
+	if p.rand != nil {
+		random = p.rand
+	}
+	pause := time.Duration(random() * float64(ceiling))
+
+	if p.Budget > 0 && elapsed+pause >= p.Budget {
+		return 0, false
+	}
+	return pause, true
+}
+
+func sqlState(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyRetriable(t *testing.T) {
+
+	policy := DefaultRetryPolicy()
+
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "serialization_failure", err: &pgconn.PgError{Code: "40001"}, expected: true},
+		{name: "deadlock_detected", err: &pgconn.PgError{Code: "40P01"}, expected: true},
+		{name: "admin_shutdown", err: &pgconn.PgError{Code: "57P01"}, expected: true},
+		{name: "connection_failure", err: &pgconn.PgError{Code: "08006"}, expected: true},
+		{name: "обернутая ошибка", err: fmt.Errorf("запрос: %w", &pgconn.PgError{Code: "40001"}), expected: true},
+		{name: "unique_violation", err: &pgconn.PgError{Code: "23505"}, expected: false},
+		{name: "check_violation", err: &pgconn.PgError{Code: "23514"}, expected: false},
+		{name: "конфликт на commit", err: &commitError{err: &pgconn.PgError{Code: "40001"}}, expected: true},
+		{name: "обрыв соединения на commit", err: &commitError{err: &pgconn.PgError{Code: "08006"}}, expected: false},
+		{name: "driver.ErrBadConn", err: driver.ErrBadConn, expected: true},
+		{name: "ошибка dial", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, expected: true},
+		{name: "нет строк", err: sql.ErrNoRows, expected: false},
+		{name: "прочие ошибки", err: errors.New("ошибка"), expected: false},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.expected, policy.Retriable(tc.err), tc.name)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+
+	policy := RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   100 * time.Millisecond,
+		MaxDelay:    250 * time.Millisecond,
+		Budget:      time.Second,
+		rand:        func() float64 { return 0.5 },
+	}
+
+	// пауза растет экспоненциально и ограничена MaxDelay
+	pause, ok := policy.Backoff(0, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 50*time.Millisecond, pause)
+
+	pause, ok = policy.Backoff(1, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 100*time.Millisecond, pause)
+
+	pause, ok = policy.Backoff(2, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 125*time.Millisecond, pause)
+
+	// попытки кончились
+	_, ok = policy.Backoff(3, 0)
+	assert.False(t, ok)
+
+	// пауза не укладывается в бюджет
+	_, ok = policy.Backoff(1, 950*time.Millisecond)
+	assert.False(t, ok)
+
+	// случайная пауза не превышает потолок
+	policy.rand = nil
+	for i := 0; i < 100; i++ {
+		pause, ok = policy.Backoff(2, 0)
+		assert.True(t, ok)
+		assert.Less(t, pause, 250*time.Millisecond)
+	}
+}
+
+func TestRunTxCanceled(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var called bool
+	_, err := RunTx(ctx, &Storage{retry: DefaultRetryPolicy()}, func(ctx context.Context, tx pgx.Tx) (int, error) {
+		called = true
+		return 1, nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, called)
+
+	_, err = NewMemory(nil).GetBalance(ctx, "Jhon")
+	assert.ErrorIs(t, err, context.Canceled)
+}
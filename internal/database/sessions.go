@@ -0,0 +1,185 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"gophermart/internal/models"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused - предъявлен уже использованный refresh-токен: его, вероятно, украли,
+	// поэтому сессия отозвана целиком
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// AddSession создает сессию пользователя с первым refresh-токеном, срок которого ttl.
+func (storage *Storage) AddSession(ctx context.Context, userID, sessionID, refreshHash string, ttl time.Duration) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
+
+		sessionQuery := `INSERT INTO sessions (id, user_id, created_at) VALUES ($1, $2, CURRENT_TIMESTAMP)`
+		if _, err := tx.Exec(ctx, sessionQuery, sessionID, userID); err != nil {
+			return err
+		}
+
+		tokenQuery := `INSERT INTO refresh_tokens (token_hash, session_id, created_at, expires_at)
+					   VALUES ($1, $2, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP + $3::float8 * INTERVAL '1 second')`
+		_, err := tx.Exec(ctx, tokenQuery, refreshHash, sessionID, ttl.Seconds())
+		return err
+	})
+}
+
+type rotation struct {
+	session models.Session
+	reused  bool
+}
+
+// RotateRefreshToken заменяет refresh-токен oldHash на newHash в той же сессии и возвращает сессию.
+// Повторное предъявление использованного токена отзывает сессию и возвращает ErrRefreshTokenReused.
+func (storage *Storage) RotateRefreshToken(ctx context.Context, oldHash, newHash string, ttl time.Duration) (models.Session, error) {
+
+	result, err := RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (rotation, error) {
+
+		// у заблокированного пользователя токены не обновляются
+		query := `SELECT r.session_id, s.user_id, u.role, r.used_at IS NOT NULL,
+					  r.expires_at < CURRENT_TIMESTAMP OR s.revoked_at IS NOT NULL OR u.blocked_at IS NOT NULL
+				  FROM refresh_tokens r
+				  JOIN sessions s ON s.id = r.session_id
+				  JOIN users u ON u.user_id = s.user_id
+				  WHERE r.token_hash = $1
+				  FOR UPDATE OF r, s`
+
+		var session models.Session
+		var used, expired bool
+		err := tx.QueryRow(ctx, query, oldHash).Scan(&session.ID, &session.UserID, &session.Role, &used, &expired)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return rotation{}, ErrInvalidRefreshToken
+		case err != nil:
+			return rotation{}, err
+		case used:
+			revokeQuery := `UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL`
+			if _, err := tx.Exec(ctx, revokeQuery, session.ID); err != nil {
+				return rotation{}, err
+			}
+			return rotation{session: session, reused: true}, nil
+		case expired:
+			return rotation{}, ErrInvalidRefreshToken
+		}
+
+		useQuery := `UPDATE refresh_tokens SET used_at = CURRENT_TIMESTAMP WHERE token_hash = $1`
+		if _, err := tx.Exec(ctx, useQuery, oldHash); err != nil {
+			return rotation{}, err
+		}
+
+		tokenQuery := `INSERT INTO refresh_tokens (token_hash, session_id, created_at, expires_at)
+					   VALUES ($1, $2, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP + $3::float8 * INTERVAL '1 second')`
+		if _, err := tx.Exec(ctx, tokenQuery, newHash, session.ID, ttl.Seconds()); err != nil {
+			return rotation{}, err
+		}
+		return rotation{session: session}, nil
+	})
+
+	switch {
+	case err != nil:
+		return models.Session{}, err
+	case result.reused:
+		if storage.logger != nil {
+			storage.logger.Infof("повторно использован refresh-токен, сессия %s пользователя %s отозвана", result.session.ID, result.session.UserID)
+		}
+		return models.Session{}, ErrRefreshTokenReused
+	}
+	return result.session, nil
+}
+
+// RevokeSession отзывает сессию пользователя: ее access- и refresh-токены больше не принимаются.
+func (storage *Storage) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
+
+		query := `UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+				  WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+
+		_, err := tx.Exec(ctx, query, sessionID, userID)
+		return err
+	})
+}
+
+// RevokeAllSessions отзывает все сессии пользователя и возвращает их число.
+func (storage *Storage) RevokeAllSessions(ctx context.Context, userID string) (int64, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (int64, error) {
+
+		query := `UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+				  WHERE user_id = $1 AND revoked_at IS NULL`
+
+		tag, err := tx.Exec(ctx, query, userID)
+		if err != nil {
+			return 0, err
+		}
+		return tag.RowsAffected(), nil
+	})
+}
+
+// RevokeToken добавляет access-токен в список отозванных на оставшийся срок его действия ttl.
+func (storage *Storage) RevokeToken(ctx context.Context, jti string, ttl time.Duration) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
+
+		query := `INSERT INTO revoked_tokens (jti, expires_at)
+				  VALUES ($1, CURRENT_TIMESTAMP + $2::float8 * INTERVAL '1 second')
+				  ON CONFLICT (jti) DO NOTHING`
+
+		_, err := tx.Exec(ctx, query, jti, ttl.Seconds())
+		return err
+	})
+}
+
+// IsTokenRevoked - отозван ли access-токен: сам по jti или вместе с сессией.
+// Сессия, которой нет (удалена после истечения), считается отозванной.
+func (storage *Storage) IsTokenRevoked(ctx context.Context, jti, sessionID string) (bool, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (bool, error) {
+
+		var revoked bool
+		err := tx.QueryRow(ctx, stmtIsTokenRevoked, jti, sessionID).Scan(&revoked)
+		return revoked, err
+	})
+}
+
+// DeleteExpiredTokens удаляет истекшие записи об отозванных токенах, отозванные сессии,
+// сессии, у которых не осталось действующих refresh-токенов, погашенные или истекшие
+// токены сброса пароля и истекшие токены второго шага входа. Возвращает число удаленных строк.
+func (storage *Storage) DeleteExpiredTokens(ctx context.Context) (int64, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (int64, error) {
+
+		revokedQuery := `DELETE FROM revoked_tokens WHERE expires_at < CURRENT_TIMESTAMP`
+		revoked, err := tx.Exec(ctx, revokedQuery)
+		if err != nil {
+			return 0, err
+		}
+
+		sessionsQuery := `DELETE FROM sessions s
+						  WHERE s.revoked_at IS NOT NULL
+						  OR NOT EXISTS (
+							  SELECT 1 FROM refresh_tokens r
+							  WHERE r.session_id = s.id AND r.used_at IS NULL
+							  AND r.expires_at > CURRENT_TIMESTAMP)`
+		sessions, err := tx.Exec(ctx, sessionsQuery)
+		if err != nil {
+			return 0, err
+		}
+
+		resetQuery := `DELETE FROM password_reset_tokens
+					   WHERE used_at IS NOT NULL OR expires_at < CURRENT_TIMESTAMP`
+		resets, err := tx.Exec(ctx, resetQuery)
+		if err != nil {
+			return 0, err
+		}
+
+		challenges, err := tx.Exec(ctx, `DELETE FROM mfa_challenges WHERE expires_at < CURRENT_TIMESTAMP`)
+		if err != nil {
+			return 0, err
+		}
+		return revoked.RowsAffected() + sessions.RowsAffected() + resets.RowsAffected() + challenges.RowsAffected(), nil
+	})
+}
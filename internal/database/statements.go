@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Имена подготовленных запросов. Запросы готовятся на каждом новом соединении пула,
+// pgx выполняет подготовленный запрос, если вместо текста передано его имя.
+const (
+	stmtGetBalance     = "get_balance"
+	stmtGetOrders      = "get_orders"
+	stmtLeaseOrders    = "lease_orders"
+	stmtIsTokenRevoked = "is_token_revoked"
+	stmtLoginLockedFor = "login_locked_for"
+)
+
+var preparedStatements = map[string]string{
+
+	stmtGetBalance: `SELECT current, withdrawn FROM user_balances WHERE user_id = $1`,
+
+	stmtGetOrders: `
+	SELECT orders.number, billing.status, billing.accrual as accrual, billing.uploaded_at
+	FROM orders
+	JOIN billing ON orders.number = billing.order_number
+	WHERE orders.user_id = $1
+	AND billing.time = (
+		SELECT MAX(time)
+		FROM billing
+		WHERE billing.order_number = orders.number)
+	ORDER BY billing.time ASC, orders.uploaded_at ASC`,
+
+	// выполняется на каждом тике опроса системы расчета
+	stmtLeaseOrders: `
+	WITH candidates AS (
+		SELECT o.number
+		FROM orders o
+		WHERE (o.locked_until IS NULL OR o.locked_until < CURRENT_TIMESTAMP)
+		AND EXISTS (
+			SELECT 1 FROM billing b
+			WHERE b.order_number = o.number AND b.status = 'NEW')
+		AND NOT EXISTS (
+			SELECT 1 FROM billing f
+			WHERE f.order_number = o.number AND f.status IN ('PROCESSED', 'INVALID'))
+		ORDER BY o.uploaded_at
+		LIMIT $3
+		FOR UPDATE OF o SKIP LOCKED
+	)
+	UPDATE orders SET locked_by = $1, locked_until = CURRENT_TIMESTAMP + $2::float8 * INTERVAL '1 second'
+	FROM candidates
+	WHERE orders.number = candidates.number
+	RETURNING orders.number`,
+
+	stmtIsTokenRevoked: `
+	SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)
+	OR ($2 <> '' AND NOT EXISTS (SELECT 1 FROM sessions WHERE id = $2 AND revoked_at IS NULL))`,
+
+	stmtLoginLockedFor: `
+	SELECT COALESCE(MAX(EXTRACT(EPOCH FROM locked_until - CURRENT_TIMESTAMP)), 0)::float8
+	FROM login_attempts
+	WHERE key = ANY($1::varchar[]) AND locked_until > CURRENT_TIMESTAMP`,
+}
+
+// prepareStatements - AfterConnect пула.
+func prepareStatements(ctx context.Context, conn *pgx.Conn) error {
+	for name, query := range preparedStatements {
+		if _, err := conn.Prepare(ctx, name, query); err != nil {
+			return fmt.Errorf("ошибка подготовки запроса %s %w", name, err)
+		}
+	}
+	return nil
+}
+
+// PoolStats - состояние пула соединений для мониторинга.
+type PoolStats struct {
+	MaxConns                int32 `json:"max_conns"`
+	TotalConns              int32 `json:"total_conns"`
+	IdleConns               int32 `json:"idle_conns"`
+	AcquiredConns           int32 `json:"acquired_conns"`
+	ConstructingConns       int32 `json:"constructing_conns"`
+	AcquireCount            int64 `json:"acquire_count"`
+	AcquireDurationMs       int64 `json:"acquire_duration_ms"`
+	EmptyAcquireCount       int64 `json:"empty_acquire_count"`
+	CanceledAcquireCount    int64 `json:"canceled_acquire_count"`
+	NewConnsCount           int64 `json:"new_conns_count"`
+	MaxLifetimeDestroyCount int64 `json:"max_lifetime_destroy_count"`
+	MaxIdleDestroyCount     int64 `json:"max_idle_destroy_count"`
+}
+
+func (storage *Storage) Stats() PoolStats {
+
+	stat := storage.Pool.Stat()
+	return PoolStats{
+		MaxConns:                stat.MaxConns(),
+		TotalConns:              stat.TotalConns(),
+		IdleConns:               stat.IdleConns(),
+		AcquiredConns:           stat.AcquiredConns(),
+		ConstructingConns:       stat.ConstructingConns(),
+		AcquireCount:            stat.AcquireCount(),
+		AcquireDurationMs:       stat.AcquireDuration().Milliseconds(),
+		EmptyAcquireCount:       stat.EmptyAcquireCount(),
+		CanceledAcquireCount:    stat.CanceledAcquireCount(),
+		NewConnsCount:           stat.NewConnsCount(),
+		MaxLifetimeDestroyCount: stat.MaxLifetimeDestroyCount(),
+		MaxIdleDestroyCount:     stat.MaxIdleDestroyCount(),
+	}
+}
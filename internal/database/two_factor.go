@@ -0,0 +1,196 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gophermart/internal/models"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrTwoFactorEnabled    = errors.New("two-factor authentication already enabled")
+	ErrTwoFactorNotEnabled = errors.New("two-factor authentication not enabled")
+	ErrInvalidMFAToken     = errors.New("invalid mfa token")
+)
+
+// GetTwoFactor - настройки TOTP пользователя, sql.ErrNoRows - TOTP не подключался.
+func (storage *Storage) GetTwoFactor(ctx context.Context, userID string) (models.TwoFactor, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (models.TwoFactor, error) {
+
+		query := `SELECT secret, confirmed_at IS NOT NULL, last_step, require_for_withdrawals
+				  FROM user_totp WHERE user_id = $1`
+
+		tf := models.TwoFactor{UserID: userID}
+		err := tx.QueryRow(ctx, query, userID).Scan(&tf.Secret, &tf.Enabled, &tf.LastStep, &tf.RequireForWithdrawals)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.TwoFactor{}, sql.ErrNoRows
+		}
+		return tf, err
+	})
+}
+
+// StartTOTPEnrollment сохраняет новый неподтвержденный секрет, заменяя прежний неподтвержденный.
+// Если TOTP уже подтвержден - ErrTwoFactorEnabled.
+func (storage *Storage) StartTOTPEnrollment(ctx context.Context, userID string, secret []byte) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
+
+		query := `INSERT INTO user_totp (user_id, secret, created_at) VALUES ($1, $2, CURRENT_TIMESTAMP)
+				  ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, created_at = EXCLUDED.created_at
+				  WHERE user_totp.confirmed_at IS NULL`
+
+		tag, err := tx.Exec(ctx, query, userID, secret)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrTwoFactorEnabled
+		}
+		return nil
+	})
+}
+
+// ConfirmTOTP включает TOTP после первого верного кода интервала step и сохраняет коды восстановления.
+func (storage *Storage) ConfirmTOTP(ctx context.Context, userID string, step int64, codeHashes []string) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
+
+		query := `UPDATE user_totp SET confirmed_at = CURRENT_TIMESTAMP, last_step = $2
+				  WHERE user_id = $1 AND confirmed_at IS NULL`
+
+		tag, err := tx.Exec(ctx, query, userID, step)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrTwoFactorEnabled
+		}
+		return replaceRecoveryCodes(ctx, tx, userID, codeHashes)
+	})
+}
+
+// UseTOTPStep отмечает интервал step использованным. false - код этого или более позднего
+// интервала уже принимался, повтор кода отклоняется.
+func (storage *Storage) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (bool, error) {
+
+		query := `UPDATE user_totp SET last_step = $2
+				  WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_step < $2`
+
+		tag, err := tx.Exec(ctx, query, userID, step)
+		if err != nil {
+			return false, err
+		}
+		return tag.RowsAffected() == 1, nil
+	})
+}
+
+// UseRecoveryCode гасит код восстановления. false - кода нет или он уже использован.
+func (storage *Storage) UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (bool, error) {
+
+		query := `UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP
+				  WHERE code_hash = $1 AND user_id = $2 AND used_at IS NULL`
+
+		tag, err := tx.Exec(ctx, query, codeHash, userID)
+		if err != nil {
+			return false, err
+		}
+		return tag.RowsAffected() == 1, nil
+	})
+}
+
+// ReplaceRecoveryCodes заменяет все коды восстановления пользователя новыми.
+func (storage *Storage) ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
+		return replaceRecoveryCodes(ctx, tx, userID, codeHashes)
+	})
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID string, codeHashes []string) error {
+
+	if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO recovery_codes (code_hash, user_id)
+			  SELECT unnest($2::varchar[]), $1`
+	_, err := tx.Exec(ctx, query, userID, codeHashes)
+	return err
+}
+
+// DisableTwoFactor отключает TOTP и удаляет коды восстановления и незавершенные входы.
+func (storage *Storage) DisableTwoFactor(ctx context.Context, userID string) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
+
+		for _, query := range []string{
+			`DELETE FROM mfa_challenges WHERE user_id = $1`,
+			`DELETE FROM recovery_codes WHERE user_id = $1`,
+			`DELETE FROM user_totp WHERE user_id = $1`,
+		} {
+			if _, err := tx.Exec(ctx, query, userID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetWithdrawalTwoFactor включает или выключает требование кода для списаний.
+// Без подтвержденного TOTP - ErrTwoFactorNotEnabled.
+func (storage *Storage) SetWithdrawalTwoFactor(ctx context.Context, userID string, require bool) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
+
+		query := `UPDATE user_totp SET require_for_withdrawals = $2
+				  WHERE user_id = $1 AND confirmed_at IS NOT NULL`
+
+		tag, err := tx.Exec(ctx, query, userID, require)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrTwoFactorNotEnabled
+		}
+		return nil
+	})
+}
+
+// AddMFAChallenge сохраняет токен второго шага входа со сроком ttl.
+func (storage *Storage) AddMFAChallenge(ctx context.Context, userID, tokenHash string, ttl time.Duration) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
+
+		query := `INSERT INTO mfa_challenges (token_hash, user_id, expires_at)
+				  VALUES ($1, $2, CURRENT_TIMESTAMP + $3::float8 * INTERVAL '1 second')`
+
+		_, err := tx.Exec(ctx, query, tokenHash, userID, ttl.Seconds())
+		return err
+	})
+}
+
+// UseMFAChallenge учитывает попытку ввода кода по токену второго шага и возвращает пользователя.
+// Истекший токен или токен, по которому было больше maxAttempts попыток, - ErrInvalidMFAToken.
+func (storage *Storage) UseMFAChallenge(ctx context.Context, tokenHash string, maxAttempts int) (string, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (string, error) {
+
+		query := `UPDATE mfa_challenges SET attempts = attempts + 1
+				  WHERE token_hash = $1 AND expires_at > CURRENT_TIMESTAMP AND attempts < $2
+				  RETURNING user_id`
+
+		var userID string
+		err := tx.QueryRow(ctx, query, tokenHash, maxAttempts).Scan(&userID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", ErrInvalidMFAToken
+		}
+		return userID, err
+	})
+}
+
+// DeleteMFAChallenge удаляет токен второго шага после успешного входа.
+func (storage *Storage) DeleteMFAChallenge(ctx context.Context, tokenHash string) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
+
+		_, err := tx.Exec(ctx, `DELETE FROM mfa_challenges WHERE token_hash = $1`, tokenHash)
+		return err
+	})
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TxFunc - операция внутри транзакции с типизированным результатом.
+type TxFunc[T any] func(context.Context, pgx.Tx) (T, error)
+
+// RunTx выполняет op в отдельной транзакции и повторяет ее целиком,
+// если RetryPolicy хранилища считает ошибку восстановимой. При ошибке возвращается
+// нулевое значение T; при отмене ctx - ctx.Err(), чтобы ее нельзя было принять за успех.
+func RunTx[T any](ctx context.Context, storage *Storage, op TxFunc[T]) (T, error) {
+
+	var zero T
+	start := time.Now()
+
+	for attempt := 0; ; attempt++ {
+
+		if err := ctx.Err(); err != nil {
+			return zero, err
+		}
+
+		result, err := runTxOnce(ctx, storage.Pool, op)
+		if err == nil {
+			return result, nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return zero, ctxErr
+		}
+		if !storage.retry.Retriable(err) {
+			return zero, fmt.Errorf("НЕвостановимая ошибка %w", err)
+		}
+
+		pause, ok := storage.retry.Backoff(attempt, time.Since(start))
+		if !ok {
+			return zero, fmt.Errorf("попытки исчерпаны (%d) %w", attempt+1, err)
+		}
+		if storage.logger != nil {
+			storage.logger.Infof("восстановимая ошибка, попытка %d через %s: %v", attempt+2, pause, err)
+		}
+
+		timer := time.NewTimer(pause)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return zero, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// ExecTx - RunTx для операций без результата.
+func ExecTx(ctx context.Context, storage *Storage, op func(context.Context, pgx.Tx) error) error {
+	_, err := RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (struct{}, error) {
+		return struct{}{}, op(ctx, tx)
+	})
+	return err
+}
+
+func runTxOnce[T any](ctx context.Context, pool *pgxpool.Pool, op TxFunc[T]) (T, error) {
+
+	var zero T
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return zero, fmt.Errorf("ошибка при создании транзакции %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := op(ctx, tx)
+	if err != nil {
+		return zero, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return zero, &commitError{err: err}
+	}
+	return result, nil
+}
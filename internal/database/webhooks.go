@@ -0,0 +1,264 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gophermart/internal/models"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrWebhookLeaseLost = errors.New("webhook delivery lease lost")
+)
+
+func (storage *Storage) AddWebhook(ctx context.Context, userID, url, secret string) (models.Webhook, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (models.Webhook, error) {
+
+		query := `INSERT INTO webhooks (user_id, url, secret, created_at)
+				  VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+				  RETURNING id, url, secret, created_at`
+
+		var webhook models.Webhook
+		err := tx.QueryRow(ctx, query, userID, url, secret).Scan(&webhook.ID, &webhook.URL, &webhook.Secret, &webhook.CreatedAt)
+		return webhook, err
+	})
+}
+
+// GetWebhooks возвращает webhook'и пользователя без секретов.
+func (storage *Storage) GetWebhooks(ctx context.Context, userID string) ([]models.Webhook, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) ([]models.Webhook, error) {
+
+		query := `SELECT id, url, created_at FROM webhooks WHERE user_id = $1 ORDER BY id`
+
+		rows, err := tx.Query(ctx, query, userID)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var webhooks []models.Webhook
+		for rows.Next() {
+			var webhook models.Webhook
+			if err := rows.Scan(&webhook.ID, &webhook.URL, &webhook.CreatedAt); err != nil {
+				return nil, err
+			}
+			webhooks = append(webhooks, webhook)
+		}
+		if err := rows.Err(); err != nil {
+			return webhooks, err
+		}
+		return webhooks, nil
+	})
+}
+
+// DeleteWebhook удаляет webhook вместе с журналом доставок. Чужой webhook - ErrWebhookNotFound.
+func (storage *Storage) DeleteWebhook(ctx context.Context, userID string, webhookID int64) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
+
+		query := `DELETE FROM webhooks WHERE id = $1 AND user_id = $2`
+
+		tag, err := tx.Exec(ctx, query, webhookID, userID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrWebhookNotFound
+		}
+		return nil
+	})
+}
+
+// GetWebhookDeliveries возвращает до limit последних доставок webhook'а пользователя, новые первыми.
+func (storage *Storage) GetWebhookDeliveries(ctx context.Context, userID string, webhookID int64, limit int) ([]models.WebhookDelivery, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) ([]models.WebhookDelivery, error) {
+
+		var owner string
+		err := tx.QueryRow(ctx, `SELECT user_id FROM webhooks WHERE id = $1`, webhookID).Scan(&owner)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows) || err == nil && owner != userID:
+			return nil, ErrWebhookNotFound
+		case err != nil:
+			return nil, err
+		}
+
+		query := `
+		SELECT id, webhook_id, event, payload, status, attempts, next_attempt_at,
+			COALESCE(last_status_code, 0), COALESCE(last_error, ''), created_at, delivered_at
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		ORDER BY id DESC
+		LIMIT $2`
+
+		rows, err := tx.Query(ctx, query, webhookID, limit)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var deliveries []models.WebhookDelivery
+		for rows.Next() {
+			var d models.WebhookDelivery
+			var payload []byte
+			err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &payload, &d.Status, &d.Attempts, &d.NextAttemptAt,
+				&d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+			if err != nil {
+				return nil, err
+			}
+			d.Payload = payload
+			deliveries = append(deliveries, d)
+		}
+		if err := rows.Err(); err != nil {
+			return deliveries, err
+		}
+		return deliveries, nil
+	})
+}
+
+// LeaseWebhookDeliveries захватывает до limit доставок, время попытки которых наступило, на время ttl.
+// Как и в LeaseOrders, доставки, захваченные другим экземпляром, пропускаются.
+func (storage *Storage) LeaseWebhookDeliveries(ctx context.Context, instanceID string, limit int, ttl time.Duration) ([]models.WebhookDelivery, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) ([]models.WebhookDelivery, error) {
+
+		query := `
+		WITH due AS (
+			SELECT d.id
+			FROM webhook_deliveries d
+			WHERE d.status = 'pending'
+			AND d.next_attempt_at <= CURRENT_TIMESTAMP
+			AND (d.locked_until IS NULL OR d.locked_until < CURRENT_TIMESTAMP)
+			ORDER BY d.next_attempt_at, d.id
+			LIMIT $3
+			FOR UPDATE OF d SKIP LOCKED
+		)
+		UPDATE webhook_deliveries d
+		SET locked_by = $1, locked_until = CURRENT_TIMESTAMP + $2::float8 * INTERVAL '1 second'
+		FROM due, webhooks w
+		WHERE d.id = due.id AND w.id = d.webhook_id
+		RETURNING d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, d.next_attempt_at, d.created_at, w.url, w.secret`
+
+		rows, err := tx.Query(ctx, query, instanceID, ttl.Seconds(), limit)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var deliveries []models.WebhookDelivery
+		for rows.Next() {
+			var d models.WebhookDelivery
+			var payload []byte
+			err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &payload, &d.Status, &d.Attempts, &d.NextAttemptAt,
+				&d.CreatedAt, &d.URL, &d.Secret)
+			if err != nil {
+				return nil, err
+			}
+			d.Payload = payload
+			deliveries = append(deliveries, d)
+		}
+		if err := rows.Err(); err != nil {
+			return deliveries, err
+		}
+		return deliveries, nil
+	})
+}
+
+// SaveWebhookAttempt записывает результат попытки доставки и снимает захват. Если доставку
+// уже захватил другой экземпляр, результат не записывается - ErrWebhookLeaseLost.
+func (storage *Storage) SaveWebhookAttempt(ctx context.Context, attempt models.WebhookAttempt) error {
+	return ExecTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) error {
+
+		query := `
+		UPDATE webhook_deliveries
+		SET status = $2,
+			attempts = attempts + 1,
+			last_status_code = NULLIF($3, 0),
+			last_error = NULLIF($4, ''),
+			next_attempt_at = CASE WHEN $2 = 'pending' THEN CURRENT_TIMESTAMP + $5::float8 * INTERVAL '1 second' ELSE next_attempt_at END,
+			delivered_at = CASE WHEN $2 = 'delivered' THEN CURRENT_TIMESTAMP END,
+			locked_by = NULL,
+			locked_until = NULL
+		WHERE id = $1 AND locked_by = $6`
+
+		tag, err := tx.Exec(ctx, query, attempt.DeliveryID, attempt.Status, attempt.StatusCode, attempt.Error,
+			attempt.RetryIn.Seconds(), attempt.InstanceID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrWebhookLeaseLost
+		}
+		return nil
+	})
+}
+
+// DeleteFinishedWebhookDeliveries удаляет из журнала доставленные и неудавшиеся доставки,
+// созданные раньше olderThan назад, возвращает количество удаленных.
+func (storage *Storage) DeleteFinishedWebhookDeliveries(ctx context.Context, olderThan time.Duration) (int64, error) {
+	return RunTx(ctx, storage, func(ctx context.Context, tx pgx.Tx) (int64, error) {
+
+		deleteQuery := `DELETE FROM webhook_deliveries
+						WHERE status <> 'pending'
+						AND created_at < CURRENT_TIMESTAMP - $1::float8 * INTERVAL '1 second'`
+
+		tag, err := tx.Exec(ctx, deleteQuery, olderThan.Seconds())
+		if err != nil {
+			return int64(0), err
+		}
+		return tag.RowsAffected(), nil
+	})
+}
+
+// enqueueWebhooks ставит событие в очередь доставки на все webhook'и пользователя
+// в транзакции, в которой произошло само событие.
+func enqueueWebhooks(ctx context.Context, tx pgx.Tx, userID string, event models.WebhookEvent) error {
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO webhook_deliveries (webhook_id, event, payload, status, attempts, next_attempt_at, created_at)
+	SELECT id, $2, $3, 'pending', 0, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+	FROM webhooks
+	WHERE user_id = $1`
+
+	if _, err := tx.Exec(ctx, query, userID, event.Type, payload); err != nil {
+		return fmt.Errorf("ошибка постановки события в очередь webhook %w", err)
+	}
+	return nil
+}
+
+// enqueueStatusWebhooks - enqueueWebhooks для заказов, перешедших в окончательный статус.
+func enqueueStatusWebhooks(ctx context.Context, tx pgx.Tx, statuses []models.OrderStatusNew, t time.Time) error {
+
+	var final []string
+	for _, v := range statuses {
+		if models.IsFinalStatus(v.Status) {
+			final = append(final, v.Number)
+		}
+	}
+	if len(final) == 0 {
+		return nil
+	}
+
+	owners, err := orderOwners(ctx, tx, final)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range statuses {
+		event, ok := models.WebhookEventForStatus(v, t)
+		if !ok {
+			continue
+		}
+		if err := enqueueWebhooks(ctx, tx, owners[v.Number], event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	db "gophermart/internal/database"
 	models "gophermart/internal/models"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 )
@@ -36,12 +37,27 @@ func (m *MockStoragerDB) EXPECT() *MockStoragerDBMockRecorder {
 	return m.recorder
 }
 
+// AddMFAChallenge mocks base method.
+func (m *MockStoragerDB) AddMFAChallenge(arg0 context.Context, arg1, arg2 string, arg3 time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddMFAChallenge", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddMFAChallenge indicates an expected call of AddMFAChallenge.
+func (mr *MockStoragerDBMockRecorder) AddMFAChallenge(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddMFAChallenge", reflect.TypeOf((*MockStoragerDB)(nil).AddMFAChallenge), arg0, arg1, arg2, arg3)
+}
+
 // AddOrder mocks base method.
-func (m *MockStoragerDB) AddOrder(arg0 context.Context, arg1, arg2 string) db.DBOperation {
+func (m *MockStoragerDB) AddOrder(arg0 context.Context, arg1, arg2 string) (models.OrderUserID, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "AddOrder", arg0, arg1, arg2)
-	ret0, _ := ret[0].(db.DBOperation)
-	return ret0
+	ret0, _ := ret[0].(models.OrderUserID)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // AddOrder indicates an expected call of AddOrder.
@@ -50,11 +66,39 @@ func (mr *MockStoragerDBMockRecorder) AddOrder(arg0, arg1, arg2 interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddOrder", reflect.TypeOf((*MockStoragerDB)(nil).AddOrder), arg0, arg1, arg2)
 }
 
+// AddPasswordResetToken mocks base method.
+func (m *MockStoragerDB) AddPasswordResetToken(arg0 context.Context, arg1, arg2 string, arg3 time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddPasswordResetToken", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddPasswordResetToken indicates an expected call of AddPasswordResetToken.
+func (mr *MockStoragerDBMockRecorder) AddPasswordResetToken(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddPasswordResetToken", reflect.TypeOf((*MockStoragerDB)(nil).AddPasswordResetToken), arg0, arg1, arg2, arg3)
+}
+
+// AddSession mocks base method.
+func (m *MockStoragerDB) AddSession(arg0 context.Context, arg1, arg2, arg3 string, arg4 time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddSession", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddSession indicates an expected call of AddSession.
+func (mr *MockStoragerDBMockRecorder) AddSession(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddSession", reflect.TypeOf((*MockStoragerDB)(nil).AddSession), arg0, arg1, arg2, arg3, arg4)
+}
+
 // AddUser mocks base method.
-func (m *MockStoragerDB) AddUser(arg0 context.Context, arg1, arg2 string) db.DBOperation {
+func (m *MockStoragerDB) AddUser(arg0 context.Context, arg1, arg2 string) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "AddUser", arg0, arg1, arg2)
-	ret0, _ := ret[0].(db.DBOperation)
+	ret0, _ := ret[0].(error)
 	return ret0
 }
 
@@ -64,6 +108,65 @@ func (mr *MockStoragerDBMockRecorder) AddUser(arg0, arg1, arg2 interface{}) *gom
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddUser", reflect.TypeOf((*MockStoragerDB)(nil).AddUser), arg0, arg1, arg2)
 }
 
+// AddWebhook mocks base method.
+func (m *MockStoragerDB) AddWebhook(arg0 context.Context, arg1, arg2, arg3 string) (models.Webhook, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddWebhook", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(models.Webhook)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddWebhook indicates an expected call of AddWebhook.
+func (mr *MockStoragerDBMockRecorder) AddWebhook(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddWebhook", reflect.TypeOf((*MockStoragerDB)(nil).AddWebhook), arg0, arg1, arg2, arg3)
+}
+
+// AdjustBalance mocks base method.
+func (m *MockStoragerDB) AdjustBalance(arg0 context.Context, arg1 models.BalanceAdjustment) (models.BalanceAdjustment, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AdjustBalance", arg0, arg1)
+	ret0, _ := ret[0].(models.BalanceAdjustment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AdjustBalance indicates an expected call of AdjustBalance.
+func (mr *MockStoragerDBMockRecorder) AdjustBalance(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AdjustBalance", reflect.TypeOf((*MockStoragerDB)(nil).AdjustBalance), arg0, arg1)
+}
+
+// BlockUser mocks base method.
+func (m *MockStoragerDB) BlockUser(arg0 context.Context, arg1, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BlockUser", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// BlockUser indicates an expected call of BlockUser.
+func (mr *MockStoragerDBMockRecorder) BlockUser(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BlockUser", reflect.TypeOf((*MockStoragerDB)(nil).BlockUser), arg0, arg1, arg2)
+}
+
+// ChangePassword mocks base method.
+func (m *MockStoragerDB) ChangePassword(arg0 context.Context, arg1, arg2, arg3 string) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ChangePassword", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ChangePassword indicates an expected call of ChangePassword.
+func (mr *MockStoragerDBMockRecorder) ChangePassword(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChangePassword", reflect.TypeOf((*MockStoragerDB)(nil).ChangePassword), arg0, arg1, arg2, arg3)
+}
+
 // Close mocks base method.
 func (m *MockStoragerDB) Close() error {
 	m.ctrl.T.Helper()
@@ -78,12 +181,158 @@ func (mr *MockStoragerDBMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockStoragerDB)(nil).Close))
 }
 
+// ConfirmTOTP mocks base method.
+func (m *MockStoragerDB) ConfirmTOTP(arg0 context.Context, arg1 string, arg2 int64, arg3 []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConfirmTOTP", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ConfirmTOTP indicates an expected call of ConfirmTOTP.
+func (mr *MockStoragerDBMockRecorder) ConfirmTOTP(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConfirmTOTP", reflect.TypeOf((*MockStoragerDB)(nil).ConfirmTOTP), arg0, arg1, arg2, arg3)
+}
+
+// DeleteExpiredIdempotencyKeys mocks base method.
+func (m *MockStoragerDB) DeleteExpiredIdempotencyKeys(arg0 context.Context, arg1 time.Duration) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteExpiredIdempotencyKeys", arg0, arg1)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteExpiredIdempotencyKeys indicates an expected call of DeleteExpiredIdempotencyKeys.
+func (mr *MockStoragerDBMockRecorder) DeleteExpiredIdempotencyKeys(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpiredIdempotencyKeys", reflect.TypeOf((*MockStoragerDB)(nil).DeleteExpiredIdempotencyKeys), arg0, arg1)
+}
+
+// DeleteExpiredTokens mocks base method.
+func (m *MockStoragerDB) DeleteExpiredTokens(arg0 context.Context) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteExpiredTokens", arg0)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteExpiredTokens indicates an expected call of DeleteExpiredTokens.
+func (mr *MockStoragerDBMockRecorder) DeleteExpiredTokens(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpiredTokens", reflect.TypeOf((*MockStoragerDB)(nil).DeleteExpiredTokens), arg0)
+}
+
+// DeleteFinishedWebhookDeliveries mocks base method.
+func (m *MockStoragerDB) DeleteFinishedWebhookDeliveries(arg0 context.Context, arg1 time.Duration) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteFinishedWebhookDeliveries", arg0, arg1)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteFinishedWebhookDeliveries indicates an expected call of DeleteFinishedWebhookDeliveries.
+func (mr *MockStoragerDBMockRecorder) DeleteFinishedWebhookDeliveries(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteFinishedWebhookDeliveries", reflect.TypeOf((*MockStoragerDB)(nil).DeleteFinishedWebhookDeliveries), arg0, arg1)
+}
+
+// DeleteIdempotencyKey mocks base method.
+func (m *MockStoragerDB) DeleteIdempotencyKey(arg0 context.Context, arg1, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteIdempotencyKey", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteIdempotencyKey indicates an expected call of DeleteIdempotencyKey.
+func (mr *MockStoragerDBMockRecorder) DeleteIdempotencyKey(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteIdempotencyKey", reflect.TypeOf((*MockStoragerDB)(nil).DeleteIdempotencyKey), arg0, arg1, arg2)
+}
+
+// DeleteMFAChallenge mocks base method.
+func (m *MockStoragerDB) DeleteMFAChallenge(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteMFAChallenge", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteMFAChallenge indicates an expected call of DeleteMFAChallenge.
+func (mr *MockStoragerDBMockRecorder) DeleteMFAChallenge(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMFAChallenge", reflect.TypeOf((*MockStoragerDB)(nil).DeleteMFAChallenge), arg0, arg1)
+}
+
+// DeleteOutboxPublished mocks base method.
+func (m *MockStoragerDB) DeleteOutboxPublished(arg0 context.Context, arg1 time.Duration) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteOutboxPublished", arg0, arg1)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteOutboxPublished indicates an expected call of DeleteOutboxPublished.
+func (mr *MockStoragerDBMockRecorder) DeleteOutboxPublished(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteOutboxPublished", reflect.TypeOf((*MockStoragerDB)(nil).DeleteOutboxPublished), arg0, arg1)
+}
+
+// DeleteStaleLoginAttempts mocks base method.
+func (m *MockStoragerDB) DeleteStaleLoginAttempts(arg0 context.Context, arg1 time.Duration) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteStaleLoginAttempts", arg0, arg1)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteStaleLoginAttempts indicates an expected call of DeleteStaleLoginAttempts.
+func (mr *MockStoragerDBMockRecorder) DeleteStaleLoginAttempts(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteStaleLoginAttempts", reflect.TypeOf((*MockStoragerDB)(nil).DeleteStaleLoginAttempts), arg0, arg1)
+}
+
+// DeleteWebhook mocks base method.
+func (m *MockStoragerDB) DeleteWebhook(arg0 context.Context, arg1 string, arg2 int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteWebhook", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteWebhook indicates an expected call of DeleteWebhook.
+func (mr *MockStoragerDBMockRecorder) DeleteWebhook(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteWebhook", reflect.TypeOf((*MockStoragerDB)(nil).DeleteWebhook), arg0, arg1, arg2)
+}
+
+// DisableTwoFactor mocks base method.
+func (m *MockStoragerDB) DisableTwoFactor(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DisableTwoFactor", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DisableTwoFactor indicates an expected call of DisableTwoFactor.
+func (mr *MockStoragerDBMockRecorder) DisableTwoFactor(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DisableTwoFactor", reflect.TypeOf((*MockStoragerDB)(nil).DisableTwoFactor), arg0, arg1)
+}
+
 // GetBalance mocks base method.
-func (m *MockStoragerDB) GetBalance(arg0 context.Context, arg1 string) db.DBOperation {
+func (m *MockStoragerDB) GetBalance(arg0 context.Context, arg1 string) (models.Balance, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetBalance", arg0, arg1)
-	ret0, _ := ret[0].(db.DBOperation)
-	return ret0
+	ret0, _ := ret[0].(models.Balance)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // GetBalance indicates an expected call of GetBalance.
@@ -92,26 +341,73 @@ func (mr *MockStoragerDBMockRecorder) GetBalance(arg0, arg1 interface{}) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBalance", reflect.TypeOf((*MockStoragerDB)(nil).GetBalance), arg0, arg1)
 }
 
-// GetNewProcessedOrders mocks base method.
-func (m *MockStoragerDB) GetNewProcessedOrders(arg0 context.Context) db.DBOperation {
+// GetBalanceAdjustments mocks base method.
+func (m *MockStoragerDB) GetBalanceAdjustments(arg0 context.Context, arg1 string) ([]models.BalanceAdjustment, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetNewProcessedOrders", arg0)
-	ret0, _ := ret[0].(db.DBOperation)
-	return ret0
+	ret := m.ctrl.Call(m, "GetBalanceAdjustments", arg0, arg1)
+	ret0, _ := ret[0].([]models.BalanceAdjustment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
-// GetNewProcessedOrders indicates an expected call of GetNewProcessedOrders.
-func (mr *MockStoragerDBMockRecorder) GetNewProcessedOrders(arg0 interface{}) *gomock.Call {
+// GetBalanceAdjustments indicates an expected call of GetBalanceAdjustments.
+func (mr *MockStoragerDBMockRecorder) GetBalanceAdjustments(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNewProcessedOrders", reflect.TypeOf((*MockStoragerDB)(nil).GetNewProcessedOrders), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBalanceAdjustments", reflect.TypeOf((*MockStoragerDB)(nil).GetBalanceAdjustments), arg0, arg1)
+}
+
+// GetLedger mocks base method.
+func (m *MockStoragerDB) GetLedger(arg0 context.Context, arg1 string) ([]models.LedgerEntry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLedger", arg0, arg1)
+	ret0, _ := ret[0].([]models.LedgerEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLedger indicates an expected call of GetLedger.
+func (mr *MockStoragerDBMockRecorder) GetLedger(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLedger", reflect.TypeOf((*MockStoragerDB)(nil).GetLedger), arg0, arg1)
+}
+
+// GetOrder mocks base method.
+func (m *MockStoragerDB) GetOrder(arg0 context.Context, arg1 string) (models.OrderDetails, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrder", arg0, arg1)
+	ret0, _ := ret[0].(models.OrderDetails)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrder indicates an expected call of GetOrder.
+func (mr *MockStoragerDBMockRecorder) GetOrder(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrder", reflect.TypeOf((*MockStoragerDB)(nil).GetOrder), arg0, arg1)
+}
+
+// GetOrderEvents mocks base method.
+func (m *MockStoragerDB) GetOrderEvents(arg0 context.Context, arg1 string, arg2 int64, arg3 int) ([]models.OrderEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrderEvents", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].([]models.OrderEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrderEvents indicates an expected call of GetOrderEvents.
+func (mr *MockStoragerDBMockRecorder) GetOrderEvents(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrderEvents", reflect.TypeOf((*MockStoragerDB)(nil).GetOrderEvents), arg0, arg1, arg2, arg3)
 }
 
 // GetOrders mocks base method.
-func (m *MockStoragerDB) GetOrders(arg0 context.Context, arg1 string) db.DBOperation {
+func (m *MockStoragerDB) GetOrders(arg0 context.Context, arg1 string) ([]models.OrderStatus, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetOrders", arg0, arg1)
-	ret0, _ := ret[0].(db.DBOperation)
-	return ret0
+	ret0, _ := ret[0].([]models.OrderStatus)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // GetOrders indicates an expected call of GetOrders.
@@ -120,12 +416,43 @@ func (mr *MockStoragerDBMockRecorder) GetOrders(arg0, arg1 interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrders", reflect.TypeOf((*MockStoragerDB)(nil).GetOrders), arg0, arg1)
 }
 
+// GetOrdersPage mocks base method.
+func (m *MockStoragerDB) GetOrdersPage(arg0 context.Context, arg1 string, arg2 models.PageQuery) (models.OrdersPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrdersPage", arg0, arg1, arg2)
+	ret0, _ := ret[0].(models.OrdersPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrdersPage indicates an expected call of GetOrdersPage.
+func (mr *MockStoragerDBMockRecorder) GetOrdersPage(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrdersPage", reflect.TypeOf((*MockStoragerDB)(nil).GetOrdersPage), arg0, arg1, arg2)
+}
+
+// GetTwoFactor mocks base method.
+func (m *MockStoragerDB) GetTwoFactor(arg0 context.Context, arg1 string) (models.TwoFactor, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTwoFactor", arg0, arg1)
+	ret0, _ := ret[0].(models.TwoFactor)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTwoFactor indicates an expected call of GetTwoFactor.
+func (mr *MockStoragerDBMockRecorder) GetTwoFactor(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTwoFactor", reflect.TypeOf((*MockStoragerDB)(nil).GetTwoFactor), arg0, arg1)
+}
+
 // GetUser mocks base method.
-func (m *MockStoragerDB) GetUser(arg0 context.Context, arg1 string) db.DBOperation {
+func (m *MockStoragerDB) GetUser(arg0 context.Context, arg1 string) (models.User, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetUser", arg0, arg1)
-	ret0, _ := ret[0].(db.DBOperation)
-	return ret0
+	ret0, _ := ret[0].(models.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // GetUser indicates an expected call of GetUser.
@@ -134,12 +461,43 @@ func (mr *MockStoragerDBMockRecorder) GetUser(arg0, arg1 interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUser", reflect.TypeOf((*MockStoragerDB)(nil).GetUser), arg0, arg1)
 }
 
+// GetWebhookDeliveries mocks base method.
+func (m *MockStoragerDB) GetWebhookDeliveries(arg0 context.Context, arg1 string, arg2 int64, arg3 int) ([]models.WebhookDelivery, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWebhookDeliveries", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].([]models.WebhookDelivery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWebhookDeliveries indicates an expected call of GetWebhookDeliveries.
+func (mr *MockStoragerDBMockRecorder) GetWebhookDeliveries(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWebhookDeliveries", reflect.TypeOf((*MockStoragerDB)(nil).GetWebhookDeliveries), arg0, arg1, arg2, arg3)
+}
+
+// GetWebhooks mocks base method.
+func (m *MockStoragerDB) GetWebhooks(arg0 context.Context, arg1 string) ([]models.Webhook, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWebhooks", arg0, arg1)
+	ret0, _ := ret[0].([]models.Webhook)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWebhooks indicates an expected call of GetWebhooks.
+func (mr *MockStoragerDBMockRecorder) GetWebhooks(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWebhooks", reflect.TypeOf((*MockStoragerDB)(nil).GetWebhooks), arg0, arg1)
+}
+
 // GetWithdrawals mocks base method.
-func (m *MockStoragerDB) GetWithdrawals(arg0 context.Context, arg1 string) db.DBOperation {
+func (m *MockStoragerDB) GetWithdrawals(arg0 context.Context, arg1 string) ([]models.Withdrawal, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetWithdrawals", arg0, arg1)
-	ret0, _ := ret[0].(db.DBOperation)
-	return ret0
+	ret0, _ := ret[0].([]models.Withdrawal)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // GetWithdrawals indicates an expected call of GetWithdrawals.
@@ -148,12 +506,166 @@ func (mr *MockStoragerDBMockRecorder) GetWithdrawals(arg0, arg1 interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWithdrawals", reflect.TypeOf((*MockStoragerDB)(nil).GetWithdrawals), arg0, arg1)
 }
 
+// GetWithdrawalsPage mocks base method.
+func (m *MockStoragerDB) GetWithdrawalsPage(arg0 context.Context, arg1 string, arg2 models.PageQuery) (models.WithdrawalsPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWithdrawalsPage", arg0, arg1, arg2)
+	ret0, _ := ret[0].(models.WithdrawalsPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWithdrawalsPage indicates an expected call of GetWithdrawalsPage.
+func (mr *MockStoragerDBMockRecorder) GetWithdrawalsPage(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWithdrawalsPage", reflect.TypeOf((*MockStoragerDB)(nil).GetWithdrawalsPage), arg0, arg1, arg2)
+}
+
+// IsTokenRevoked mocks base method.
+func (m *MockStoragerDB) IsTokenRevoked(arg0 context.Context, arg1, arg2 string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsTokenRevoked", arg0, arg1, arg2)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsTokenRevoked indicates an expected call of IsTokenRevoked.
+func (mr *MockStoragerDBMockRecorder) IsTokenRevoked(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsTokenRevoked", reflect.TypeOf((*MockStoragerDB)(nil).IsTokenRevoked), arg0, arg1, arg2)
+}
+
+// LastOrderEventID mocks base method.
+func (m *MockStoragerDB) LastOrderEventID(arg0 context.Context, arg1 string) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LastOrderEventID", arg0, arg1)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LastOrderEventID indicates an expected call of LastOrderEventID.
+func (mr *MockStoragerDBMockRecorder) LastOrderEventID(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LastOrderEventID", reflect.TypeOf((*MockStoragerDB)(nil).LastOrderEventID), arg0, arg1)
+}
+
+// LeaseOrders mocks base method.
+func (m *MockStoragerDB) LeaseOrders(arg0 context.Context, arg1 string, arg2 int, arg3 time.Duration) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LeaseOrders", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LeaseOrders indicates an expected call of LeaseOrders.
+func (mr *MockStoragerDBMockRecorder) LeaseOrders(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LeaseOrders", reflect.TypeOf((*MockStoragerDB)(nil).LeaseOrders), arg0, arg1, arg2, arg3)
+}
+
+// LeaseOutbox mocks base method.
+func (m *MockStoragerDB) LeaseOutbox(arg0 context.Context, arg1 string, arg2 int, arg3 time.Duration) ([]models.OutboxEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LeaseOutbox", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].([]models.OutboxEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LeaseOutbox indicates an expected call of LeaseOutbox.
+func (mr *MockStoragerDBMockRecorder) LeaseOutbox(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LeaseOutbox", reflect.TypeOf((*MockStoragerDB)(nil).LeaseOutbox), arg0, arg1, arg2, arg3)
+}
+
+// LeaseWebhookDeliveries mocks base method.
+func (m *MockStoragerDB) LeaseWebhookDeliveries(arg0 context.Context, arg1 string, arg2 int, arg3 time.Duration) ([]models.WebhookDelivery, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LeaseWebhookDeliveries", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].([]models.WebhookDelivery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LeaseWebhookDeliveries indicates an expected call of LeaseWebhookDeliveries.
+func (mr *MockStoragerDBMockRecorder) LeaseWebhookDeliveries(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LeaseWebhookDeliveries", reflect.TypeOf((*MockStoragerDB)(nil).LeaseWebhookDeliveries), arg0, arg1, arg2, arg3)
+}
+
+// ListenOrderEvents mocks base method.
+func (m *MockStoragerDB) ListenOrderEvents(arg0 context.Context, arg1 func(string)) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListenOrderEvents", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ListenOrderEvents indicates an expected call of ListenOrderEvents.
+func (mr *MockStoragerDBMockRecorder) ListenOrderEvents(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListenOrderEvents", reflect.TypeOf((*MockStoragerDB)(nil).ListenOrderEvents), arg0, arg1)
+}
+
+// LoginLockedFor mocks base method.
+func (m *MockStoragerDB) LoginLockedFor(arg0 context.Context, arg1 ...string) (time.Duration, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0}
+	for _, a := range arg1 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "LoginLockedFor", varargs...)
+	ret0, _ := ret[0].(time.Duration)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LoginLockedFor indicates an expected call of LoginLockedFor.
+func (mr *MockStoragerDBMockRecorder) LoginLockedFor(arg0 interface{}, arg1 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0}, arg1...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoginLockedFor", reflect.TypeOf((*MockStoragerDB)(nil).LoginLockedFor), varargs...)
+}
+
+// MarkOutboxPublished mocks base method.
+func (m *MockStoragerDB) MarkOutboxPublished(arg0 context.Context, arg1 []int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkOutboxPublished", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkOutboxPublished indicates an expected call of MarkOutboxPublished.
+func (mr *MockStoragerDBMockRecorder) MarkOutboxPublished(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkOutboxPublished", reflect.TypeOf((*MockStoragerDB)(nil).MarkOutboxPublished), arg0, arg1)
+}
+
+// MarkUnregistered mocks base method.
+func (m *MockStoragerDB) MarkUnregistered(arg0 context.Context, arg1 []string, arg2 time.Duration) ([]models.OrderStatusNew, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkUnregistered", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]models.OrderStatusNew)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MarkUnregistered indicates an expected call of MarkUnregistered.
+func (mr *MockStoragerDBMockRecorder) MarkUnregistered(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkUnregistered", reflect.TypeOf((*MockStoragerDB)(nil).MarkUnregistered), arg0, arg1, arg2)
+}
+
 // PutStatuses mocks base method.
-func (m *MockStoragerDB) PutStatuses(arg0 context.Context, arg1 *[]models.OrderStatusNew) db.DBOperation {
+func (m *MockStoragerDB) PutStatuses(arg0 context.Context, arg1 *[]models.OrderStatusNew) ([]models.OrderStatusNew, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "PutStatuses", arg0, arg1)
-	ret0, _ := ret[0].(db.DBOperation)
-	return ret0
+	ret0, _ := ret[0].([]models.OrderStatusNew)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // PutStatuses indicates an expected call of PutStatuses.
@@ -162,26 +674,342 @@ func (mr *MockStoragerDBMockRecorder) PutStatuses(arg0, arg1 interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutStatuses", reflect.TypeOf((*MockStoragerDB)(nil).PutStatuses), arg0, arg1)
 }
 
-// WithRetry mocks base method.
-func (m *MockStoragerDB) WithRetry(arg0 context.Context, arg1 db.DBOperation) (interface{}, error) {
+// RegisterLoginFailure mocks base method.
+func (m *MockStoragerDB) RegisterLoginFailure(arg0 context.Context, arg1 string, arg2 db.LockoutPolicy) (time.Duration, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "WithRetry", arg0, arg1)
-	ret0, _ := ret[0].(interface{})
+	ret := m.ctrl.Call(m, "RegisterLoginFailure", arg0, arg1, arg2)
+	ret0, _ := ret[0].(time.Duration)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// WithRetry indicates an expected call of WithRetry.
-func (mr *MockStoragerDBMockRecorder) WithRetry(arg0, arg1 interface{}) *gomock.Call {
+// RegisterLoginFailure indicates an expected call of RegisterLoginFailure.
+func (mr *MockStoragerDBMockRecorder) RegisterLoginFailure(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WithRetry", reflect.TypeOf((*MockStoragerDB)(nil).WithRetry), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RegisterLoginFailure", reflect.TypeOf((*MockStoragerDB)(nil).RegisterLoginFailure), arg0, arg1, arg2)
+}
+
+// ReleaseLeases mocks base method.
+func (m *MockStoragerDB) ReleaseLeases(arg0 context.Context, arg1 string, arg2 []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseLeases", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReleaseLeases indicates an expected call of ReleaseLeases.
+func (mr *MockStoragerDBMockRecorder) ReleaseLeases(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseLeases", reflect.TypeOf((*MockStoragerDB)(nil).ReleaseLeases), arg0, arg1, arg2)
+}
+
+// RenewLeases mocks base method.
+func (m *MockStoragerDB) RenewLeases(arg0 context.Context, arg1 string, arg2 []string, arg3 time.Duration) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RenewLeases", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RenewLeases indicates an expected call of RenewLeases.
+func (mr *MockStoragerDBMockRecorder) RenewLeases(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenewLeases", reflect.TypeOf((*MockStoragerDB)(nil).RenewLeases), arg0, arg1, arg2, arg3)
+}
+
+// ReplaceRecoveryCodes mocks base method.
+func (m *MockStoragerDB) ReplaceRecoveryCodes(arg0 context.Context, arg1 string, arg2 []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReplaceRecoveryCodes", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReplaceRecoveryCodes indicates an expected call of ReplaceRecoveryCodes.
+func (mr *MockStoragerDBMockRecorder) ReplaceRecoveryCodes(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReplaceRecoveryCodes", reflect.TypeOf((*MockStoragerDB)(nil).ReplaceRecoveryCodes), arg0, arg1, arg2)
+}
+
+// ReserveIdempotencyKey mocks base method.
+func (m *MockStoragerDB) ReserveIdempotencyKey(arg0 context.Context, arg1, arg2, arg3 string) (models.IdempotencyRecord, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReserveIdempotencyKey", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(models.IdempotencyRecord)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReserveIdempotencyKey indicates an expected call of ReserveIdempotencyKey.
+func (mr *MockStoragerDBMockRecorder) ReserveIdempotencyKey(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReserveIdempotencyKey", reflect.TypeOf((*MockStoragerDB)(nil).ReserveIdempotencyKey), arg0, arg1, arg2, arg3)
+}
+
+// ResetLoginFailures mocks base method.
+func (m *MockStoragerDB) ResetLoginFailures(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetLoginFailures", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResetLoginFailures indicates an expected call of ResetLoginFailures.
+func (mr *MockStoragerDBMockRecorder) ResetLoginFailures(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetLoginFailures", reflect.TypeOf((*MockStoragerDB)(nil).ResetLoginFailures), arg0, arg1)
+}
+
+// ResetPassword mocks base method.
+func (m *MockStoragerDB) ResetPassword(arg0 context.Context, arg1, arg2 string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetPassword", arg0, arg1, arg2)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResetPassword indicates an expected call of ResetPassword.
+func (mr *MockStoragerDBMockRecorder) ResetPassword(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetPassword", reflect.TypeOf((*MockStoragerDB)(nil).ResetPassword), arg0, arg1, arg2)
+}
+
+// RetryOutboxEvent mocks base method.
+func (m *MockStoragerDB) RetryOutboxEvent(arg0 context.Context, arg1 int64, arg2 time.Duration, arg3 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RetryOutboxEvent", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RetryOutboxEvent indicates an expected call of RetryOutboxEvent.
+func (mr *MockStoragerDBMockRecorder) RetryOutboxEvent(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RetryOutboxEvent", reflect.TypeOf((*MockStoragerDB)(nil).RetryOutboxEvent), arg0, arg1, arg2, arg3)
+}
+
+// RevokeAllSessions mocks base method.
+func (m *MockStoragerDB) RevokeAllSessions(arg0 context.Context, arg1 string) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeAllSessions", arg0, arg1)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RevokeAllSessions indicates an expected call of RevokeAllSessions.
+func (mr *MockStoragerDBMockRecorder) RevokeAllSessions(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeAllSessions", reflect.TypeOf((*MockStoragerDB)(nil).RevokeAllSessions), arg0, arg1)
+}
+
+// RevokeSession mocks base method.
+func (m *MockStoragerDB) RevokeSession(arg0 context.Context, arg1, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeSession", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeSession indicates an expected call of RevokeSession.
+func (mr *MockStoragerDBMockRecorder) RevokeSession(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeSession", reflect.TypeOf((*MockStoragerDB)(nil).RevokeSession), arg0, arg1, arg2)
+}
+
+// RevokeToken mocks base method.
+func (m *MockStoragerDB) RevokeToken(arg0 context.Context, arg1 string, arg2 time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeToken", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeToken indicates an expected call of RevokeToken.
+func (mr *MockStoragerDBMockRecorder) RevokeToken(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeToken", reflect.TypeOf((*MockStoragerDB)(nil).RevokeToken), arg0, arg1, arg2)
+}
+
+// RotateRefreshToken mocks base method.
+func (m *MockStoragerDB) RotateRefreshToken(arg0 context.Context, arg1, arg2 string, arg3 time.Duration) (models.Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RotateRefreshToken", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(models.Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RotateRefreshToken indicates an expected call of RotateRefreshToken.
+func (mr *MockStoragerDBMockRecorder) RotateRefreshToken(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RotateRefreshToken", reflect.TypeOf((*MockStoragerDB)(nil).RotateRefreshToken), arg0, arg1, arg2, arg3)
+}
+
+// SaveIdempotentResponse mocks base method.
+func (m *MockStoragerDB) SaveIdempotentResponse(arg0 context.Context, arg1 models.IdempotencyRecord) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveIdempotentResponse", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveIdempotentResponse indicates an expected call of SaveIdempotentResponse.
+func (mr *MockStoragerDBMockRecorder) SaveIdempotentResponse(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveIdempotentResponse", reflect.TypeOf((*MockStoragerDB)(nil).SaveIdempotentResponse), arg0, arg1)
+}
+
+// SaveWebhookAttempt mocks base method.
+func (m *MockStoragerDB) SaveWebhookAttempt(arg0 context.Context, arg1 models.WebhookAttempt) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveWebhookAttempt", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveWebhookAttempt indicates an expected call of SaveWebhookAttempt.
+func (mr *MockStoragerDBMockRecorder) SaveWebhookAttempt(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveWebhookAttempt", reflect.TypeOf((*MockStoragerDB)(nil).SaveWebhookAttempt), arg0, arg1)
+}
+
+// SetUserRole mocks base method.
+func (m *MockStoragerDB) SetUserRole(arg0 context.Context, arg1, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetUserRole", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetUserRole indicates an expected call of SetUserRole.
+func (mr *MockStoragerDBMockRecorder) SetUserRole(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetUserRole", reflect.TypeOf((*MockStoragerDB)(nil).SetUserRole), arg0, arg1, arg2)
+}
+
+// SetWithdrawalTwoFactor mocks base method.
+func (m *MockStoragerDB) SetWithdrawalTwoFactor(arg0 context.Context, arg1 string, arg2 bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetWithdrawalTwoFactor", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetWithdrawalTwoFactor indicates an expected call of SetWithdrawalTwoFactor.
+func (mr *MockStoragerDBMockRecorder) SetWithdrawalTwoFactor(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetWithdrawalTwoFactor", reflect.TypeOf((*MockStoragerDB)(nil).SetWithdrawalTwoFactor), arg0, arg1, arg2)
+}
+
+// StartTOTPEnrollment mocks base method.
+func (m *MockStoragerDB) StartTOTPEnrollment(arg0 context.Context, arg1 string, arg2 []byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StartTOTPEnrollment", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// StartTOTPEnrollment indicates an expected call of StartTOTPEnrollment.
+func (mr *MockStoragerDBMockRecorder) StartTOTPEnrollment(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StartTOTPEnrollment", reflect.TypeOf((*MockStoragerDB)(nil).StartTOTPEnrollment), arg0, arg1, arg2)
+}
+
+// Stats mocks base method.
+func (m *MockStoragerDB) Stats() db.PoolStats {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stats")
+	ret0, _ := ret[0].(db.PoolStats)
+	return ret0
+}
+
+// Stats indicates an expected call of Stats.
+func (mr *MockStoragerDBMockRecorder) Stats() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stats", reflect.TypeOf((*MockStoragerDB)(nil).Stats))
+}
+
+// UnblockUser mocks base method.
+func (m *MockStoragerDB) UnblockUser(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UnblockUser", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UnblockUser indicates an expected call of UnblockUser.
+func (mr *MockStoragerDBMockRecorder) UnblockUser(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnblockUser", reflect.TypeOf((*MockStoragerDB)(nil).UnblockUser), arg0, arg1)
+}
+
+// UpdateUserHash mocks base method.
+func (m *MockStoragerDB) UpdateUserHash(arg0 context.Context, arg1, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateUserHash", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateUserHash indicates an expected call of UpdateUserHash.
+func (mr *MockStoragerDBMockRecorder) UpdateUserHash(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUserHash", reflect.TypeOf((*MockStoragerDB)(nil).UpdateUserHash), arg0, arg1, arg2)
+}
+
+// UseMFAChallenge mocks base method.
+func (m *MockStoragerDB) UseMFAChallenge(arg0 context.Context, arg1 string, arg2 int) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseMFAChallenge", arg0, arg1, arg2)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UseMFAChallenge indicates an expected call of UseMFAChallenge.
+func (mr *MockStoragerDBMockRecorder) UseMFAChallenge(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseMFAChallenge", reflect.TypeOf((*MockStoragerDB)(nil).UseMFAChallenge), arg0, arg1, arg2)
+}
+
+// UseRecoveryCode mocks base method.
+func (m *MockStoragerDB) UseRecoveryCode(arg0 context.Context, arg1, arg2 string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseRecoveryCode", arg0, arg1, arg2)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UseRecoveryCode indicates an expected call of UseRecoveryCode.
+func (mr *MockStoragerDBMockRecorder) UseRecoveryCode(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseRecoveryCode", reflect.TypeOf((*MockStoragerDB)(nil).UseRecoveryCode), arg0, arg1, arg2)
+}
+
+// UseTOTPStep mocks base method.
+func (m *MockStoragerDB) UseTOTPStep(arg0 context.Context, arg1 string, arg2 int64) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseTOTPStep", arg0, arg1, arg2)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UseTOTPStep indicates an expected call of UseTOTPStep.
+func (mr *MockStoragerDBMockRecorder) UseTOTPStep(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseTOTPStep", reflect.TypeOf((*MockStoragerDB)(nil).UseTOTPStep), arg0, arg1, arg2)
 }
 
 // WithdrawBalance mocks base method.
-func (m *MockStoragerDB) WithdrawBalance(arg0 context.Context, arg1 string, arg2 models.OrderSum) db.DBOperation {
+func (m *MockStoragerDB) WithdrawBalance(arg0 context.Context, arg1 string, arg2 models.OrderSum) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "WithdrawBalance", arg0, arg1, arg2)
-	ret0, _ := ret[0].(db.DBOperation)
+	ret0, _ := ret[0].(error)
 	return ret0
 }
 
@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+// Роли пользователей. support видит данные пользователей и снимает блокировку входа,
+// admin вдобавок блокирует аккаунты, назначает роли и корректирует баланс.
+const (
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
+
+// ValidRole - известна ли роль.
+func ValidRole(role string) bool {
+	switch role {
+	case RoleUser, RoleSupport, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// UserProfile - пользователь в ответах /api/admin, без хеша пароля.
+type UserProfile struct {
+	Login         string  `json:"login"`
+	Role          string  `json:"role"`
+	Blocked       bool    `json:"blocked"`
+	BlockedReason string  `json:"blocked_reason,omitempty"`
+	Balance       Balance `json:"balance"`
+}
+
+// Коды причин ручной корректировки баланса.
+const (
+	// AdjustmentAccrualCorrection - исправление ошибочного начисления по заказу
+	AdjustmentAccrualCorrection = "accrual_correction"
+	// AdjustmentRefund - возврат баллов, списанных в счет заказа OrderNumber
+	AdjustmentRefund = "refund"
+	// AdjustmentCompensation - компенсация пользователю
+	AdjustmentCompensation = "compensation"
+	// AdjustmentFraud - изъятие баллов, полученных мошенничеством
+	AdjustmentFraud = "fraud"
+	AdjustmentOther = "other"
+)
+
+// ValidAdjustmentReason - известен ли код причины корректировки.
+func ValidAdjustmentReason(code string) bool {
+	switch code {
+	case AdjustmentAccrualCorrection, AdjustmentRefund, AdjustmentCompensation, AdjustmentFraud, AdjustmentOther:
+		return true
+	}
+	return false
+}
+
+// BalanceAdjustment - ручная корректировка баланса: Amount > 0 - начисление, < 0 - списание.
+// ReasonCode - одна из Adjustment*, Reason - пояснение, Ticket - обращение, по которому
+// сделана корректировка, Operator - логин сотрудника. Для возврата (AdjustmentRefund)
+// OrderNumber - заказ, за который списывались баллы.
+type BalanceAdjustment struct {
+	ID          int64     `json:"id"`
+	UserID      string    `json:"login"`
+	Amount      Amount    `json:"amount"`
+	ReasonCode  string    `json:"reason_code"`
+	Reason      string    `json:"reason"`
+	Ticket      string    `json:"ticket"`
+	OrderNumber string    `json:"order,omitempty"`
+	Operator    string    `json:"operator"`
+	CreatedAt   time.Time `json:"created_at"`
+}
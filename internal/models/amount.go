@@ -0,0 +1,105 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// AmountScale - сколько минорных единиц (копеек) в одном балле.
+const AmountScale = 100
+
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// Amount - количество баллов в копейках. Вся арифметика целочисленная,
+// в JSON сумма выглядит обычным числом (500, 729.98), как и раньше.
+type Amount int64
+
+// ParseAmount разбирает десятичную запись без потери точности.
+// Доли копейки округляются до ближайшей копейки (половина - от нуля).
+func ParseAmount(s string) (Amount, error) {
+
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	r.Mul(r, big.NewRat(AmountScale, 1))
+
+	num, den := r.Num(), r.Denom()
+	q, m := new(big.Int).QuoRem(num, den, new(big.Int))
+	// |2m| >= den - округляем от нуля
+	if m.Sign() != 0 && new(big.Int).Abs(new(big.Int).Mul(m, big.NewInt(2))).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(int64(num.Sign())))
+	}
+	if !q.IsInt64() {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	return Amount(q.Int64()), nil
+}
+
+func (a Amount) String() string {
+
+	sign := ""
+	v := int64(a)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	whole, frac := v/AmountScale, v%AmountScale
+	if frac == 0 {
+		return sign + strconv.FormatInt(whole, 10)
+	}
+	return strings.TrimRight(fmt.Sprintf("%s%d.%02d", sign, whole, frac), "0")
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Amount) UnmarshalJSON(data []byte) error {
+
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	// сумму в кавычках тоже принимаем
+	s = strings.Trim(s, `"`)
+	v, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+func (a Amount) Value() (driver.Value, error) {
+	return int64(a), nil
+}
+
+func (a *Amount) Scan(src interface{}) error {
+
+	switch v := src.(type) {
+	case nil:
+		*a = 0
+	case int64:
+		*a = Amount(v)
+	case []byte:
+		n, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %q", ErrInvalidAmount, v)
+		}
+		*a = Amount(n)
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %q", ErrInvalidAmount, v)
+		}
+		*a = Amount(n)
+	default:
+		return fmt.Errorf("%w: unsupported type %T", ErrInvalidAmount, src)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAmount(t *testing.T) {
+
+	tests := []struct {
+		in       string
+		expected Amount
+		err      bool
+	}{
+		{in: "500", expected: 50000},
+		{in: "729.98", expected: 72998},
+		{in: "0.1", expected: 10},
+		{in: "100.33", expected: 10033},
+		{in: "1e2", expected: 10000},
+		{in: "0.005", expected: 1},
+		{in: "0.0049", expected: 0},
+		{in: "-0.005", expected: -1},
+		{in: "-12.5", expected: -1250},
+		{in: "abc", err: true},
+		{in: "1e30", err: true},
+	}
+
+	for _, tc := range tests {
+		v, err := ParseAmount(tc.in)
+		if tc.err {
+			assert.ErrorIs(t, err, ErrInvalidAmount, tc.in)
+			continue
+		}
+		assert.NoError(t, err, tc.in)
+		assert.Equal(t, tc.expected, v, tc.in)
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+
+	balance := Balance{Current: 62965, Withdraw: 10000}
+	data, err := json.Marshal(balance)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"current":629.65,"withdrawn":100}`, string(data))
+
+	var order OrderStatusNew
+	require.NoError(t, json.Unmarshal([]byte(`{"order":"1","status":"PROCESSED","accrual":729.98}`), &order))
+	assert.Equal(t, Amount(72998), order.Accrual)
+
+	// сумма, которая во float64 дает 0.30000000000000004
+	var sum OrderSum
+	require.NoError(t, json.Unmarshal([]byte(`{"order":"1","sum":0.3}`), &sum))
+	assert.Equal(t, Amount(30), sum.Sum)
+
+	// без начисления поле accrual отсутствует
+	data, err = json.Marshal(OrderStatus{Number: "1", Status: StatusNew})
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "accrual")
+
+	assert.Equal(t, "-0.05", Amount(-5).String())
+	assert.Equal(t, "10.5", Amount(1050).String())
+}
@@ -2,33 +2,66 @@ package models
 
 import "time"
 
+// User - пользователь. Role - одна из Role*, Blocked - аккаунт заблокирован администратором.
 type User struct {
-	Login string
-	Hash  string
+	Login         string
+	Hash          string
+	Role          string
+	Blocked       bool
+	BlockedReason string
 }
 
 type OrderStatusNew struct {
 	Number     string    `json:"order"`
 	Status     string    `json:"status"`
-	Accrual    float64   `json:"accrual,omitempty"`
+	Accrual    Amount    `json:"accrual,omitempty"`
 	UploadedAt time.Time `json:"uploaded_at"`
 }
 
 type OrderStatus struct {
 	Number     string    `json:"number"`
 	Status     string    `json:"status"`
-	Accrual    float64   `json:"accrual,omitempty"`
+	Accrual    Amount    `json:"accrual,omitempty"`
 	UploadedAt time.Time `json:"uploaded_at"`
 }
 
+// StatusTransition - строка billing: переход заказа в статус Status в момент Time.
+type StatusTransition struct {
+	Status  string    `json:"status"`
+	Accrual Amount    `json:"accrual,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// OrderDetails - заказ с историей статусов в хронологическом порядке.
+// Status и Accrual - последний переход, как в списке заказов.
+type OrderDetails struct {
+	Number     string             `json:"number"`
+	UserID     string             `json:"-"`
+	Status     string             `json:"status"`
+	Accrual    Amount             `json:"accrual,omitempty"`
+	UploadedAt time.Time          `json:"uploaded_at"`
+	History    []StatusTransition `json:"history"`
+}
+
+// OrderEvent - изменение статуса заказа в потоке событий пользователя.
+// ID растет вместе с потоком и служит Last-Event-ID.
+type OrderEvent struct {
+	ID      int64     `json:"id"`
+	UserID  string    `json:"-"`
+	Number  string    `json:"number"`
+	Status  string    `json:"status"`
+	Accrual Amount    `json:"accrual,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
 type Balance struct {
-	Current  float64 `json:"current"`
-	Withdraw float64 `json:"withdrawn"`
+	Current  Amount `json:"current"`
+	Withdraw Amount `json:"withdrawn"`
 }
 
 type OrderSum struct {
-	OrderNumber string  `json:"order"`
-	Sum         float64 `json:"sum"`
+	OrderNumber string `json:"order"`
+	Sum         Amount `json:"sum"`
 }
 
 type OrderUserID struct {
@@ -38,14 +71,50 @@ type OrderUserID struct {
 
 type Withdrawal struct {
 	OrderNumber string    `json:"order"`
-	Sum         float64   `json:"sum"`
+	Sum         Amount    `json:"sum"`
 	ProcessedAt time.Time `json:"processed_at"`
 }
 
 type Billing struct {
 	OrderNumber string    `json:"order"`
 	Status      string    `json:"status"`
-	Accrual     float64   `json:"accrual"`
+	Accrual     Amount    `json:"accrual"`
 	UploadedAt  time.Time `json:"uploaded_at"`
 	Time        time.Time `json:"time"`
 }
+
+// Виды записей журнала баллов.
+const (
+	LedgerKindAccrual    = "accrual"
+	LedgerKindWithdrawal = "withdrawal"
+	LedgerKindAdjustment = "adjustment"
+	LedgerKindRefund     = "refund"
+)
+
+// LedgerEntry - движение баллов пользователя: Amount > 0 - поступление, < 0 - списание.
+// У ручной корректировки нет заказа; у корректировок и возвратов ReasonCode - код причины.
+type LedgerEntry struct {
+	ID          int64     `json:"id"`
+	Kind        string    `json:"kind"`
+	OrderNumber string    `json:"order,omitempty"`
+	Amount      Amount    `json:"amount"`
+	ReasonCode  string    `json:"reason_code,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// IdempotencyRecord - сохраненный ответ на запрос с заголовком Idempotency-Key.
+// Reserved=true - ключ только что занят текущим запросом, ответа еще нет.
+type IdempotencyRecord struct {
+	UserID      string
+	Key         string
+	RequestHash string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	Reserved    bool
+}
+
+// Completed - ответ уже сохранен и его можно вернуть повторно.
+func (r IdempotencyRecord) Completed() bool {
+	return r.StatusCode != 0
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Типы доменных событий outbox.
+const (
+	OutboxOrderUploaded = "order.uploaded"
+	OutboxOrderStatus   = "order.status_changed"
+	OutboxWithdrawal    = "balance.withdrawn"
+)
+
+// OutboxEvent - доменное событие, записанное в транзакции изменения состояния.
+// Доставка не реже одного раза: получатель отбрасывает повторы по ID.
+// События одного пользователя публикуются в порядке ID.
+type OutboxEvent struct {
+	ID        int64           `json:"id"`
+	UserID    string          `json:"user_id"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+	Attempts  int             `json:"-"`
+}
+
+// OutboxPayload - данные события: заказ и, в зависимости от типа, статус с начислением или сумма списания.
+type OutboxPayload struct {
+	Order   string `json:"order"`
+	Status  string `json:"status,omitempty"`
+	Accrual Amount `json:"accrual,omitempty"`
+	Sum     Amount `json:"sum,omitempty"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// Размер страницы по умолчанию и наибольший допустимый.
+const (
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+)
+
+// PageQuery - параметры постраничной выдачи списка пользователя.
+// Cursor - непрозрачная строка NextCursor предыдущей страницы, пустой Cursor - первая страница.
+// Нулевые From и To не ограничивают выборку, From включается в диапазон, To - нет.
+type PageQuery struct {
+	Limit    int
+	Cursor   string
+	Statuses []string
+	From     time.Time
+	To       time.Time
+	Desc     bool
+}
+
+// PageLimit - Limit, приведенный к [1, MaxPageLimit].
+func (q PageQuery) PageLimit() int {
+	switch {
+	case q.Limit <= 0:
+		return DefaultPageLimit
+	case q.Limit > MaxPageLimit:
+		return MaxPageLimit
+	default:
+		return q.Limit
+	}
+}
+
+// OrdersPage - страница заказов. Пустой NextCursor - страница последняя.
+type OrdersPage struct {
+	Orders     []OrderStatus
+	NextCursor string
+}
+
+// WithdrawalsPage - страница списаний. Пустой NextCursor - страница последняя.
+type WithdrawalsPage struct {
+	Withdrawals []Withdrawal
+	NextCursor  string
+}
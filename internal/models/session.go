@@ -0,0 +1,17 @@
+package models
+
+// Session - сессия входа пользователя, к ней привязаны access- и refresh-токены.
+// Role - текущая роль пользователя для нового access-токена.
+type Session struct {
+	ID     string
+	UserID string
+	Role   string
+}
+
+// TokenPair - ответ на вход и обновление токенов.
+// AccessToken дублирует заголовок Authorization, ExpiresIn - срок access-токена в секундах.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
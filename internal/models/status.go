@@ -0,0 +1,68 @@
+package models
+
+// Статусы заказа в billing. NEW → PROCESSING → PROCESSED/INVALID,
+// PROCESSED и INVALID окончательные. WITHDRAWN - отдельная ветка для списаний.
+const (
+	StatusNew        = "NEW"
+	StatusProcessing = "PROCESSING"
+	StatusProcessed  = "PROCESSED"
+	StatusInvalid    = "INVALID"
+	StatusWithdrawn  = "WITHDRAWN"
+)
+
+// Статусы, которые возвращает система расчета начислений.
+const (
+	AccrualStatusRegistered = "REGISTERED"
+	AccrualStatusProcessing = "PROCESSING"
+	AccrualStatusProcessed  = "PROCESSED"
+	AccrualStatusInvalid    = "INVALID"
+)
+
+var transitions = map[string][]string{
+	StatusNew:        {StatusProcessing, StatusProcessed, StatusInvalid},
+	StatusProcessing: {StatusProcessed, StatusInvalid},
+}
+
+// CanTransition проверяет, допустим ли переход заказа из статуса from в статус to.
+func CanTransition(from, to string) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFinalStatus - после PROCESSED и INVALID заказ больше не опрашивается.
+func IsFinalStatus(status string) bool {
+	return status == StatusProcessed || status == StatusInvalid
+}
+
+// StatusFromAccrual переводит статус системы расчета в статус billing.
+// REGISTERED для пользователя неотличим от PROCESSING.
+func StatusFromAccrual(status string) (string, bool) {
+	switch status {
+	case AccrualStatusRegistered, AccrualStatusProcessing:
+		return StatusProcessing, true
+	case AccrualStatusProcessed:
+		return StatusProcessed, true
+	case AccrualStatusInvalid:
+		return StatusInvalid, true
+	default:
+		return "", false
+	}
+}
+
+// StatusRank упорядочивает статусы по ходу обработки, текущий статус заказа - с наибольшим рангом.
+func StatusRank(status string) int {
+	switch status {
+	case StatusNew:
+		return 0
+	case StatusProcessing:
+		return 1
+	case StatusProcessed, StatusInvalid:
+		return 2
+	default:
+		return -1
+	}
+}
@@ -0,0 +1,30 @@
+package models
+
+// TwoFactor - настройки TOTP пользователя. Secret хранится зашифрованным,
+// Enabled - ввод кода подтвержден, до этого TOTP не требуется.
+// LastStep - последний принятый интервал TOTP: код нельзя использовать дважды.
+type TwoFactor struct {
+	UserID                string
+	Secret                []byte
+	Enabled               bool
+	LastStep              int64
+	RequireForWithdrawals bool
+}
+
+// TOTPEnrollment - секрет для приложения-аутентификатора и otpauth:// URI для QR-кода.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// RecoveryCodes - одноразовые коды на случай потери аутентификатора, показываются один раз.
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// MFAChallenge - ответ на вход с верным паролем, если включена 2FA:
+// токен второго шага обменивается на пару токенов вместе с кодом.
+type MFAChallenge struct {
+	MFAToken  string `json:"mfa_token"`
+	ExpiresIn int64  `json:"expires_in"`
+}
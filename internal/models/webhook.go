@@ -0,0 +1,84 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// События, о которых gophermart сообщает на webhook'и пользователя.
+const (
+	WebhookEventOrderProcessed = "order.processed"
+	WebhookEventOrderInvalid   = "order.invalid"
+	WebhookEventWithdrawal     = "withdrawal"
+)
+
+// Состояния доставки события на webhook.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook - адрес, на который отправляются события пользователя.
+// Secret возвращается только при регистрации.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookEvent - тело запроса на webhook.
+type WebhookEvent struct {
+	Type    string    `json:"type"`
+	Order   string    `json:"order"`
+	Status  string    `json:"status,omitempty"`
+	Accrual Amount    `json:"accrual,omitempty"`
+	Sum     Amount    `json:"sum,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// WebhookEventForStatus - событие для окончательного статуса заказа, false - о статусе не сообщаем.
+func WebhookEventForStatus(status OrderStatusNew, t time.Time) (WebhookEvent, bool) {
+
+	event := WebhookEvent{Order: status.Number, Status: status.Status, Accrual: status.Accrual, Time: t}
+	switch status.Status {
+	case StatusProcessed:
+		event.Type = WebhookEventOrderProcessed
+	case StatusInvalid:
+		event.Type = WebhookEventOrderInvalid
+	default:
+		return WebhookEvent{}, false
+	}
+	return event, true
+}
+
+// WebhookDelivery - доставка одного события на один webhook, она же запись журнала доставок.
+// URL и Secret заполняются только для доставки и в журнал не попадают.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int64           `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	URL            string          `json:"-"`
+	Secret         string          `json:"-"`
+}
+
+// WebhookAttempt - результат попытки доставки. Status - новое состояние доставки,
+// для DeliveryPending RetryIn - пауза до следующей попытки. InstanceID - экземпляр,
+// захвативший доставку.
+type WebhookAttempt struct {
+	DeliveryID int64
+	InstanceID string
+	Status     string
+	StatusCode int
+	Error      string
+	RetryIn    time.Duration
+}
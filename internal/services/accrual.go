@@ -6,6 +6,7 @@ import (
 	"fmt"
 	db "gophermart/internal/database"
 	"gophermart/internal/models"
+	"net/http"
 	"sync"
 	"time"
 
@@ -13,6 +14,23 @@ import (
 	"go.uber.org/zap"
 )
 
+// Внутренние отметки результата опроса, в billing не попадают.
+const (
+	// statusUnregistered - accrual ответил 204
+	statusUnregistered = "UNREGISTERED"
+	// statusRetry - опросить не удалось, заказ нужно отпустить и опросить снова
+	statusRetry = "RETRY"
+)
+
+// LeaseOptions - параметры захвата заказов. Каждый экземпляр gophermart
+// захватывает заказы под своим InstanceID на время TTL и продлевает захват,
+// пока заказ не опрошен.
+type LeaseOptions struct {
+	InstanceID string
+	TTL        time.Duration
+	BatchSize  int
+}
+
 type accrual struct {
 	accrualSysremAdress      string
 	accrualRequestInterval   int
@@ -20,16 +38,25 @@ type accrual struct {
 	storage                  db.StoragerDB
 	logger                   *zap.SugaredLogger
 	numberOfWorkers          int
+	limiter                  *rateLimiter
+	unregisteredTTL          time.Duration
+	lease                    LeaseOptions
+	leasedMu                 sync.Mutex
+	leased                   map[string]struct{}
 }
 
-func NewAccrual(accrualSysremAdress string, accrualRequestInterval int, accuralPuttingDBInterval int, storage db.StoragerDB, logger *zap.SugaredLogger, numberOfWorkers int) *accrual {
+func NewAccrual(accrualSysremAdress string, accrualRequestInterval int, accuralPuttingDBInterval int, storage db.StoragerDB, logger *zap.SugaredLogger, numberOfWorkers int, unregisteredTTL time.Duration, lease LeaseOptions) *accrual {
 	return &accrual{
 		accuralPuttingDBInterval: accuralPuttingDBInterval,
 		accrualSysremAdress:      accrualSysremAdress,
 		accrualRequestInterval:   accrualRequestInterval,
 		storage:                  storage,
 		logger:                   logger,
-		numberOfWorkers:          1,
+		numberOfWorkers:          max(numberOfWorkers, 1),
+		limiter:                  newRateLimiter(),
+		unregisteredTTL:          unregisteredTTL,
+		lease:                    lease,
+		leased:                   make(map[string]struct{}),
 	}
 
 }
@@ -65,21 +92,72 @@ func (a *accrual) collectOrders(ctx context.Context, orders chan<- string, wg *s
 			return
 		case <-ticker.C:
 
-			result, err := a.storage.WithRetry(ctx, a.storage.GetNewProcessedOrders(ctx))
+			a.renewLeases(ctx)
+
+			// захватываем не больше, чем поместится в канал, чтобы не держать lease в ожидании
+			limit := min(a.lease.BatchSize, cap(orders)-len(orders))
+			if limit <= 0 {
+				continue
+			}
+
+			res, err := a.storage.LeaseOrders(ctx, a.lease.InstanceID, limit, a.lease.TTL)
 			if err != nil {
 				a.logger.Errorf("ошибка при получении новых заказов %w", err)
+				continue
 			}
 
-			if res, ok := result.([]string); ok {
-				for _, v := range res {
-					orders <- v
-				}
+			a.leasedMu.Lock()
+			for _, v := range res {
+				a.leased[v] = struct{}{}
+			}
+			a.leasedMu.Unlock()
+
+			for _, v := range res {
+				orders <- v
 			}
 
 		}
 	}
 }
 
+// renewLeases продлевает захват заказов, которые еще ждут в канале или опрашиваются.
+func (a *accrual) renewLeases(ctx context.Context) {
+
+	a.leasedMu.Lock()
+	numbers := make([]string, 0, len(a.leased))
+	for v := range a.leased {
+		numbers = append(numbers, v)
+	}
+	a.leasedMu.Unlock()
+
+	if len(numbers) == 0 {
+		return
+	}
+
+	renewed, err := a.storage.RenewLeases(ctx, a.lease.InstanceID, numbers, a.lease.TTL)
+	if err != nil {
+		a.logger.Errorf("ошибка продления захвата заказов %w", err)
+		return
+	}
+	if len(renewed) != len(numbers) {
+		a.logger.Infof("захват продлен для %d из %d заказов, остальные перехвачены другим экземпляром", len(renewed), len(numbers))
+	}
+}
+
+// releaseLeases отпускает опрошенные заказы: следующий опрос - на общих основаниях.
+func (a *accrual) releaseLeases(ctx context.Context, numbers []string) {
+
+	a.leasedMu.Lock()
+	for _, v := range numbers {
+		delete(a.leased, v)
+	}
+	a.leasedMu.Unlock()
+
+	if err := a.storage.ReleaseLeases(ctx, a.lease.InstanceID, numbers); err != nil {
+		a.logger.Errorf("ошибка освобождения заказов %w", err)
+	}
+}
+
 func (a *accrual) worker(ctx context.Context, in chan string, out chan models.OrderStatusNew, wg *sync.WaitGroup) {
 	defer wg.Done()
 
@@ -93,6 +171,10 @@ func (a *accrual) worker(ctx context.Context, in chan string, out chan models.Or
 			if !ok {
 				return
 			}
+			if err := a.limiter.Wait(ctx); err != nil {
+				return
+			}
+
 			url := fmt.Sprint(a.accrualSysremAdress, "/api/orders/", orderNumber)
 
 			resp, err := client.R().
@@ -101,19 +183,43 @@ func (a *accrual) worker(ctx context.Context, in chan string, out chan models.Or
 
 			if err != nil {
 				a.logger.Errorf("ошибка при выполнении response: %w", err)
+				out <- models.OrderStatusNew{Number: orderNumber, Status: statusRetry}
 			} else {
 				var order models.OrderStatusNew
 
-				if resp.StatusCode() != 200 {
+				if resp.StatusCode() == http.StatusTooManyRequests {
+					until := a.limiter.OnTooManyRequests(resp.Header().Get("Retry-After"), resp.Body())
+					a.logger.Infof("accrual ограничил запросы (лимит %d в минуту), пауза до %s, заказ: %s",
+						a.limiter.Limit(), until.Format(time.TimeOnly), orderNumber)
+					out <- models.OrderStatusNew{Number: orderNumber, Status: statusRetry}
+					continue
+				}
+
+				switch resp.StatusCode() {
+				case http.StatusOK:
+				case http.StatusNoContent:
+					// заказ не зарегистрирован в системе расчета
+					out <- models.OrderStatusNew{Number: orderNumber, Status: statusUnregistered}
+					continue
+				default:
 					a.logger.Errorf("wrong status code: %d order: %s", resp.StatusCode(), orderNumber)
+					out <- models.OrderStatusNew{Number: orderNumber, Status: statusRetry}
 					continue
 				}
 
 				if err := json.Unmarshal(resp.Body(), &order); err != nil {
 					a.logger.Errorf("Ошибка при декодировании JSON: %w", err)
+					out <- models.OrderStatusNew{Number: orderNumber, Status: statusRetry}
 					continue
 				} else {
 
+					status, ok := models.StatusFromAccrual(order.Status)
+					if !ok {
+						a.logger.Errorf("неизвестный статус %s заказа %s", order.Status, orderNumber)
+						out <- models.OrderStatusNew{Number: orderNumber, Status: statusRetry}
+						continue
+					}
+					order.Status = status
 					out <- order
 				}
 
@@ -128,9 +234,12 @@ func (a *accrual) putOrdersInDB(ctx context.Context, ordersFromAccrual chan mode
 	defer close(ordersFromAccrual)
 	defer wg.Done()
 	var ordersList []models.OrderStatusNew
+	var mutex sync.Mutex
 	go func() {
 		for v := range ordersFromAccrual {
+			mutex.Lock()
 			ordersList = append(ordersList, v)
+			mutex.Unlock()
 		}
 	}()
 
@@ -141,22 +250,47 @@ func (a *accrual) putOrdersInDB(ctx context.Context, ordersFromAccrual chan mode
 			return
 		case <-ticker.C:
 
-			if len(ordersList) != 0 {
-
-				var mutex sync.Mutex
-				mutex.Lock()
-				ordersListCopy := make([]models.OrderStatusNew, len(ordersList))
-				copy(ordersListCopy, ordersList)
-				ordersList = nil
-				mutex.Unlock()
+			mutex.Lock()
+			ordersListCopy := ordersList
+			ordersList = nil
+			mutex.Unlock()
+
+			var statuses []models.OrderStatusNew
+			var unregistered, polled []string
+			for _, v := range ordersListCopy {
+				polled = append(polled, v.Number)
+				switch v.Status {
+				case statusUnregistered:
+					unregistered = append(unregistered, v.Number)
+				case statusRetry:
+				default:
+					statuses = append(statuses, v)
+				}
+			}
 
-				if _, err := a.storage.WithRetry(ctx, a.storage.PutStatuses(ctx, &ordersListCopy)); err != nil {
+			if len(statuses) != 0 {
+				if _, err := a.storage.PutStatuses(ctx, &statuses); err != nil {
 					a.logger.Error("ошибка при сохранении статусов", err)
 				} else {
 					a.logger.Info("статусы сохранены")
 				}
 			}
 
+			if len(unregistered) != 0 {
+				invalid, err := a.storage.MarkUnregistered(ctx, unregistered, a.unregisteredTTL)
+				if err != nil {
+					a.logger.Error("ошибка при сохранении незарегистрированных заказов", err)
+				} else {
+					for _, v := range invalid {
+						a.logger.Infof("заказ %s не зарегистрирован в системе расчета дольше %s, статус INVALID", v.Number, a.unregisteredTTL)
+					}
+				}
+			}
+
+			if len(polled) != 0 {
+				a.releaseLeases(ctx, polled)
+			}
+
 		}
 	}
 
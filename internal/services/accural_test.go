@@ -20,7 +20,7 @@ func accuralHandler(w http.ResponseWriter, r *http.Request) {
 		Number:     orderNumber,
 		Status:     "PROCESSED",
 		UploadedAt: time.Time{},
-		Accrual:    5,
+		Accrual:    500,
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -51,6 +51,7 @@ func TestGetOrder(t *testing.T) {
 	a := &accrual{
 		accrualSysremAdress: server.URL,
 		logger:              &zap.SugaredLogger{},
+		limiter:             newRateLimiter(),
 	}
 
 	// Start the worker goroutine
@@ -69,7 +70,7 @@ func TestGetOrder(t *testing.T) {
 		Number:     "123",
 		Status:     "PROCESSED",
 		UploadedAt: time.Time{},
-		Accrual:    5,
+		Accrual:    500,
 	}
 
 	assert.Equal(t, expResponse, order)
@@ -77,3 +78,60 @@ func TestGetOrder(t *testing.T) {
 	wg.Wait()
 
 }
+
+func TestWorkerAccrualStatuses(t *testing.T) {
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orderNumber := r.URL.Path[len("/api/orders/"):]
+		switch orderNumber {
+		case "204":
+			w.WriteHeader(http.StatusNoContent)
+		case "500":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "unknown":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"order":"unknown","status":"LOST"}`))
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"order":"` + orderNumber + `","status":"` + orderNumber + `"}`))
+		}
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	a := &accrual{
+		accrualSysremAdress: server.URL,
+		logger:              zap.NewNop().Sugar(),
+		limiter:             newRateLimiter(),
+	}
+
+	in := make(chan string, 10)
+	out := make(chan models.OrderStatusNew, 10)
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go a.worker(context.Background(), in, out, &wg)
+
+	for _, v := range []string{"REGISTERED", "500", "PROCESSING", "unknown", "INVALID", "204", "PROCESSED"} {
+		in <- v
+	}
+	close(in)
+	wg.Wait()
+	close(out)
+
+	var got []models.OrderStatusNew
+	for v := range out {
+		got = append(got, v)
+	}
+
+	expected := []models.OrderStatusNew{
+		{Number: "REGISTERED", Status: models.StatusProcessing},
+		{Number: "500", Status: statusRetry},
+		{Number: "PROCESSING", Status: models.StatusProcessing},
+		{Number: "unknown", Status: statusRetry},
+		{Number: "INVALID", Status: models.StatusInvalid},
+		{Number: "204", Status: statusUnregistered},
+		{Number: "PROCESSED", Status: models.StatusProcessed},
+	}
+	assert.Equal(t, expected, got)
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	db "gophermart/internal/database"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// orderEventsRelisten - пауза перед повторной подпиской после потери соединения.
+const orderEventsRelisten = time.Second
+
+// OrderEventBus будит подписчиков пользователя, когда у него появляются события заказов.
+// Сами события подписчик читает из хранилища после своего Last-Event-ID, поэтому
+// сигнал можно терять и дублировать: подряд идущие сигналы схлопываются в один.
+type OrderEventBus struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan struct{}]struct{}
+}
+
+func NewOrderEventBus() *OrderEventBus {
+	return &OrderEventBus{
+		subscribers: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+// Subscribe возвращает канал сигналов для userID и функцию отписки.
+func (b *OrderEventBus) Subscribe(userID string) (<-chan struct{}, func()) {
+
+	ch := make(chan struct{}, 1)
+
+	b.mu.Lock()
+	if b.subscribers[userID] == nil {
+		b.subscribers[userID] = make(map[chan struct{}]struct{})
+	}
+	b.subscribers[userID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subscribers[userID], ch)
+		if len(b.subscribers[userID]) == 0 {
+			delete(b.subscribers, userID)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// Publish будит подписчиков userID и не блокируется.
+func (b *OrderEventBus) Publish(userID string) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers[userID] {
+		wake(ch)
+	}
+}
+
+// publishAll будит всех подписчиков: пока слушатель переподключался, события могли быть пропущены.
+func (b *OrderEventBus) publishAll() {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, subscribers := range b.subscribers {
+		for ch := range subscribers {
+			wake(ch)
+		}
+	}
+}
+
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// RunOrderEventListener передает в шину уведомления хранилища (для Postgres - LISTEN/NOTIFY,
+// так что события, записанные другими экземплярами, тоже доходят до подписчиков).
+func (b *OrderEventBus) RunOrderEventListener(ctx context.Context, storage db.StoragerDB, logger *zap.SugaredLogger, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	for {
+		err := storage.ListenOrderEvents(ctx, b.Publish)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Errorf("ошибка подписки на события заказов, повтор через %s: %w", orderEventsRelisten, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(orderEventsRelisten):
+		}
+		b.publishAll()
+	}
+}
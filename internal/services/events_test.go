@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	db "gophermart/internal/database"
+	"gophermart/internal/models"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestOrderEventBus(t *testing.T) {
+
+	bus := NewOrderEventBus()
+
+	jhon, unsubscribe := bus.Subscribe("Jhon")
+	bob, unsubscribeBob := bus.Subscribe("Bob")
+	defer unsubscribeBob()
+
+	// подряд идущие сигналы схлопываются, Publish не блокируется
+	bus.Publish("Jhon")
+	bus.Publish("Jhon")
+	assert.Len(t, jhon, 1)
+	assert.Len(t, bob, 0)
+	<-jhon
+
+	bus.publishAll()
+	assert.Len(t, jhon, 1)
+	assert.Len(t, bob, 1)
+	<-jhon
+	<-bob
+
+	unsubscribe()
+	bus.Publish("Jhon")
+	assert.Len(t, jhon, 0)
+	assert.NotContains(t, bus.subscribers, "Jhon")
+}
+
+func TestRunOrderEventListener(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	storage := db.NewMemory(nil)
+	bus := NewOrderEventBus()
+
+	require.NoError(t, storage.AddUser(ctx, "Jhon", "123"))
+
+	wakeup, unsubscribe := bus.Subscribe("Jhon")
+	defer unsubscribe()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go bus.RunOrderEventListener(ctx, storage, zap.NewNop().Sugar(), &wg)
+
+	// слушатель подписывается асинхронно - меняем статусы новых заказов, пока не придет сигнал
+	woken := false
+	for i := 0; i < 50 && !woken; i++ {
+		number := fmt.Sprint(1000 + i)
+		_, err := storage.AddOrder(ctx, number, "Jhon")
+		require.NoError(t, err)
+		statuses := []models.OrderStatusNew{{Number: number, Status: models.StatusProcessing}}
+		_, err = storage.PutStatuses(ctx, &statuses)
+		require.NoError(t, err)
+
+		select {
+		case <-wakeup:
+			woken = true
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	assert.True(t, woken, "нет сигнала о событии заказа")
+
+	cancel()
+	wg.Wait()
+}
@@ -1,14 +1,206 @@
 package services
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	HashAlgorithmArgon2id = "argon2id"
+	HashAlgorithmBcrypt   = "bcrypt"
 )
 
+var ErrUnknownHashAlgorithm = errors.New("unknown password hash algorithm")
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// PasswordHasher хеширует пароли и проверяет их по сохраненному хешу.
+// Хеш хранится в users.hash в самоописывающем формате: по префиксу
+// ($argon2id$, $2a$/$2b$) видно, каким алгоритмом и с какими параметрами он получен.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(encoded, password string) (bool, error)
+	// NeedsRehash сообщает, что хеш получен другим алгоритмом или с другими параметрами
+	// и его стоит пересчитать при следующем успешном входе.
+	NeedsRehash(encoded string) bool
+}
+
+func NewPasswordHasher(algorithm string) (PasswordHasher, error) {
+	switch algorithm {
+	case "", HashAlgorithmArgon2id:
+		return NewArgon2idHasher(), nil
+	case HashAlgorithmBcrypt:
+		return NewBcryptHasher(bcrypt.DefaultCost), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownHashAlgorithm, algorithm)
+	}
+}
+
+// VerifyPassword проверяет пароль по хешу любого поддерживаемого формата,
+// включая устаревший sha256(login+password). rehash=true означает, что пароль верный,
+// но хеш нужно пересчитать текущим hasher и сохранить.
+func VerifyPassword(hasher PasswordHasher, login, password, encoded string) (ok bool, rehash bool, err error) {
+
+	switch {
+	case strings.HasPrefix(encoded, argon2idPrefix):
+		ok, err = NewArgon2idHasher().Verify(encoded, password)
+	case strings.HasPrefix(encoded, "$2"):
+		ok, err = NewBcryptHasher(bcrypt.DefaultCost).Verify(encoded, password)
+	case isLegacyHash(encoded):
+		ok = subtle.ConstantTimeCompare([]byte(GetHash(login, password)), []byte(encoded)) == 1
+		return ok, ok, nil
+	default:
+		return false, false, ErrUnknownHashFormat
+	}
+
+	if err != nil || !ok {
+		return false, false, err
+	}
+	return true, hasher.NeedsRehash(encoded), nil
+}
+
+// GetHash - устаревший формат хеша (sha256 без соли). Используется только для
+// проверки паролей пользователей, зарегистрированных до перехода на PasswordHasher.
 func GetHash(login, password string) string {
-	// TODO получение хеша нужно усложнить. добавить соль, может еще как-то
 
 	hash := sha256.Sum256([]byte(login + password))
 	return hex.EncodeToString(hash[:])
 
 }
+
+func isLegacyHash(encoded string) bool {
+	if len(encoded) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(encoded)
+	return err == nil
+}
+
+const argon2idPrefix = "$argon2id$"
+
+type argon2idHasher struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	saltLen uint32
+	keyLen  uint32
+}
+
+// NewArgon2idHasher - параметры по рекомендации RFC 9106 для ограниченной памяти.
+func NewArgon2idHasher() *argon2idHasher {
+	return &argon2idHasher{
+		memory:  64 * 1024,
+		time:    1,
+		threads: 4,
+		saltLen: 16,
+		keyLen:  32,
+	}
+}
+
+func (a *argon2idHasher) Hash(password string) (string, error) {
+
+	salt := make([]byte, a.saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.time, a.memory, a.threads, a.keyLen)
+
+	b64 := base64.RawStdEncoding
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, a.memory, a.time, a.threads,
+		b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+func (a *argon2idHasher) Verify(encoded, password string) (bool, error) {
+
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (a *argon2idHasher) NeedsRehash(encoded string) bool {
+
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.memory != a.memory || params.time != a.time || params.threads != a.threads ||
+		uint32(len(salt)) != a.saltLen || uint32(len(key)) != a.keyLen
+}
+
+func decodeArgon2id(encoded string) (argon2idHasher, []byte, []byte, error) {
+
+	// $argon2id$v=19$m=65536,t=1,p=4$<salt>$<key>
+	var params argon2idHasher
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+
+	b64 := base64.RawStdEncoding
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+	key, err := b64.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+
+	return params, salt, key, nil
+}
+
+type bcryptHasher struct {
+	cost int
+}
+
+func NewBcryptHasher(cost int) *bcryptHasher {
+	return &bcryptHasher{cost: cost}
+}
+
+func (b *bcryptHasher) Hash(password string) (string, error) {
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (b *bcryptHasher) Verify(encoded, password string) (bool, error) {
+
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	switch {
+	case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *bcryptHasher) NeedsRehash(encoded string) bool {
+
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.cost
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordHashers(t *testing.T) {
+
+	hashers := map[string]PasswordHasher{
+		"argon2id": NewArgon2idHasher(),
+		"bcrypt":   NewBcryptHasher(bcrypt.MinCost),
+	}
+
+	for name, hasher := range hashers {
+		encoded, err := hasher.Hash("12345")
+		require.NoError(t, err, name)
+
+		ok, err := hasher.Verify(encoded, "12345")
+		assert.NoError(t, err, name)
+		assert.True(t, ok, name)
+
+		ok, err = hasher.Verify(encoded, "54321")
+		assert.NoError(t, err, name)
+		assert.False(t, ok, name)
+
+		assert.False(t, hasher.NeedsRehash(encoded), name)
+
+		other, err := hasher.Hash("12345")
+		require.NoError(t, err, name)
+		assert.NotEqual(t, encoded, other, "%s: одинаковые хеши при разной соли", name)
+	}
+
+	encoded, err := NewArgon2idHasher().Hash("12345")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=65536,t=1,p=4$"))
+}
+
+func TestVerifyPassword(t *testing.T) {
+
+	argon := NewArgon2idHasher()
+	argonHash, err := argon.Hash("12345")
+	require.NoError(t, err)
+	bcryptHash, err := NewBcryptHasher(bcrypt.MinCost).Hash("12345")
+	require.NoError(t, err)
+
+	type testCase struct {
+		name     string
+		password string
+		encoded  string
+		ok       bool
+		rehash   bool
+		err      error
+	}
+
+	tests := []testCase{
+		{
+			name:     "устаревший sha256 - верный пароль, нужен пересчет",
+			password: "12345",
+			encoded:  "b13f28188ed29c08e6b0a220822e76c2c557a69c480f91924e1a8084004d4c55",
+			ok:       true,
+			rehash:   true,
+		},
+		{
+			name:     "устаревший sha256 - неверный пароль",
+			password: "54321",
+			encoded:  "b13f28188ed29c08e6b0a220822e76c2c557a69c480f91924e1a8084004d4c55",
+		},
+		{
+			name:     "argon2id - актуальный формат",
+			password: "12345",
+			encoded:  argonHash,
+			ok:       true,
+		},
+		{
+			name:     "bcrypt - верный пароль, другой алгоритм",
+			password: "12345",
+			encoded:  bcryptHash,
+			ok:       true,
+			rehash:   true,
+		},
+		{
+			name:     "bcrypt - неверный пароль",
+			password: "54321",
+			encoded:  bcryptHash,
+		},
+		{
+			name:     "неизвестный формат",
+			password: "12345",
+			encoded:  "plain",
+			err:      ErrUnknownHashFormat,
+		},
+	}
+
+	for _, tc := range tests {
+		ok, rehash, err := VerifyPassword(argon, "Jhon", tc.password, tc.encoded)
+		assert.ErrorIs(t, err, tc.err, tc.name)
+		assert.Equal(t, tc.ok, ok, tc.name)
+		assert.Equal(t, tc.rehash, rehash, tc.name)
+	}
+}
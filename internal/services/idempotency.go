@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	db "gophermart/internal/database"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// idempotencyJanitor периодически удаляет устаревшие Idempotency-Key.
+type idempotencyJanitor struct {
+	storage  db.StoragerDB
+	logger   *zap.SugaredLogger
+	ttl      time.Duration
+	interval time.Duration
+}
+
+func NewIdempotencyJanitor(storage db.StoragerDB, logger *zap.SugaredLogger, ttl time.Duration, interval time.Duration) *idempotencyJanitor {
+	return &idempotencyJanitor{
+		storage:  storage,
+		logger:   logger,
+		ttl:      ttl,
+		interval: interval,
+	}
+}
+
+func (j *idempotencyJanitor) RunIdempotencyJanitor(ctx context.Context, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			j.cleanup(ctx)
+		}
+	}
+}
+
+func (j *idempotencyJanitor) cleanup(ctx context.Context) {
+
+	n, err := j.storage.DeleteExpiredIdempotencyKeys(ctx, j.ttl)
+	if err != nil {
+		j.logger.Errorf("ошибка удаления устаревших Idempotency-Key %w", err)
+		return
+	}
+	if n > 0 {
+		j.logger.Infof("удалено устаревших Idempotency-Key: %d", n)
+	}
+}
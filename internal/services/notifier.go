@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	NotifierLog  = "log"
+	NotifierFile = "file"
+)
+
+// Notification - сообщение пользователю. To - логин: адресов у пользователей пока нет,
+// настоящая доставка (email) подключается своей реализацией Notifier.
+type Notification struct {
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	Text    string    `json:"text"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+// Notifier доставляет сообщения пользователям.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// NewNotifier выбирает Notifier по конфигурации: log - в лог сервера, file - в файл path.
+func NewNotifier(kind, path string, logger *zap.SugaredLogger) (Notifier, error) {
+	switch kind {
+	case "", NotifierLog:
+		return NewLogNotifier(logger), nil
+	case NotifierFile:
+		if path == "" {
+			return nil, fmt.Errorf("не задан файл для уведомлений")
+		}
+		return NewFileNotifier(path), nil
+	default:
+		return nil, fmt.Errorf("неизвестный способ уведомлений %s", kind)
+	}
+}
+
+// LogNotifier пишет сообщения в лог - замена почты для разработки.
+type LogNotifier struct {
+	logger *zap.SugaredLogger
+}
+
+func NewLogNotifier(logger *zap.SugaredLogger) *LogNotifier {
+	return &LogNotifier{logger: logger}
+}
+
+func (n *LogNotifier) Notify(ctx context.Context, msg Notification) error {
+	n.logger.Infof("уведомление для %s: %s\n%s", msg.To, msg.Subject, msg.Text)
+	return nil
+}
+
+// FileNotifier дописывает сообщения в файл по одному JSON в строке.
+type FileNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+func (n *FileNotifier) Notify(ctx context.Context, msg Notification) error {
+
+	if msg.SentAt.IsZero() {
+		msg.SentAt = time.Now().UTC()
+	}
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	file, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	db "gophermart/internal/database"
+	"gophermart/internal/models"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.uber.org/zap"
+)
+
+// OutboxEventIDHeader - id события в запросе HTTPSink, по нему получатель отбрасывает повторы.
+const OutboxEventIDHeader = "X-Gophermart-Event-ID"
+
+// maxOutboxError - сколько символов ошибки публикации сохраняется в outbox.
+const maxOutboxError = 512
+
+// OutboxSink - получатель доменных событий. Ошибка Publish - событие будет опубликовано повторно,
+// в том числе в sinks, которые его уже приняли.
+type OutboxSink interface {
+	Name() string
+	Publish(ctx context.Context, event models.OutboxEvent) error
+}
+
+// LogSink пишет события в лог.
+type LogSink struct {
+	logger *zap.SugaredLogger
+}
+
+func NewLogSink(logger *zap.SugaredLogger) *LogSink {
+	return &LogSink{logger: logger}
+}
+
+func (s *LogSink) Name() string {
+	return "log"
+}
+
+func (s *LogSink) Publish(ctx context.Context, event models.OutboxEvent) error {
+	s.logger.Infof("событие %d %s пользователя %s: %s", event.ID, event.Type, event.UserID, event.Payload)
+	return nil
+}
+
+// HTTPSink отправляет каждое событие JSON-запросом POST, ответ 2xx - событие принято.
+type HTTPSink struct {
+	url    string
+	client *resty.Client
+}
+
+func NewHTTPSink(url string, timeout time.Duration) *HTTPSink {
+	return &HTTPSink{
+		url:    url,
+		client: resty.New().SetTimeout(timeout),
+	}
+}
+
+func (s *HTTPSink) Name() string {
+	return "http"
+}
+
+func (s *HTTPSink) Publish(ctx context.Context, event models.OutboxEvent) error {
+
+	resp, err := s.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetHeader(OutboxEventIDHeader, strconv.FormatInt(event.ID, 10)).
+		SetBody(event).
+		Post(s.url)
+	if err != nil {
+		return err
+	}
+	if !resp.IsSuccess() {
+		return fmt.Errorf("wrong status code: %d", resp.StatusCode())
+	}
+	return nil
+}
+
+// OutboxHandler - подписчик внутри процесса. Ошибка - событие будет опубликовано повторно.
+type OutboxHandler func(ctx context.Context, event models.OutboxEvent) error
+
+// OutboxSubscribers - sink для подписчиков внутри процесса. Обработчики вызываются
+// синхронно из relay, поэтому долгую работу подписчик переносит в свою горутину.
+type OutboxSubscribers struct {
+	mu       sync.RWMutex
+	handlers map[int]OutboxHandler
+	next     int
+}
+
+func NewOutboxSubscribers() *OutboxSubscribers {
+	return &OutboxSubscribers{handlers: make(map[int]OutboxHandler)}
+}
+
+// Subscribe добавляет обработчик всех событий и возвращает функцию отписки.
+func (s *OutboxSubscribers) Subscribe(handler OutboxHandler) func() {
+
+	s.mu.Lock()
+	id := s.next
+	s.next++
+	s.handlers[id] = handler
+	s.mu.Unlock()
+
+	return func() {
+		s.mu.Lock()
+		delete(s.handlers, id)
+		s.mu.Unlock()
+	}
+}
+
+func (s *OutboxSubscribers) Name() string {
+	return "in-process"
+}
+
+func (s *OutboxSubscribers) Publish(ctx context.Context, event models.OutboxEvent) error {
+
+	s.mu.RLock()
+	handlers := make([]OutboxHandler, 0, len(s.handlers))
+	for _, h := range s.handlers {
+		handlers = append(handlers, h)
+	}
+	s.mu.RUnlock()
+
+	var errs []error
+	for _, h := range handlers {
+		if err := h(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// OutboxOptions - параметры relay. Relay захватывается под InstanceID на LeaseTTL,
+// так что события публикует один экземпляр; LeaseTTL должен быть больше времени публикации пачки.
+// Опубликованные события удаляются раз в CleanupInterval, если они старше Retention.
+type OutboxOptions struct {
+	InstanceID      string
+	PollInterval    time.Duration
+	BatchSize       int
+	LeaseTTL        time.Duration
+	RetryBaseDelay  time.Duration
+	RetryMaxDelay   time.Duration
+	Retention       time.Duration
+	CleanupInterval time.Duration
+}
+
+type outboxRelay struct {
+	storage db.StoragerDB
+	logger  *zap.SugaredLogger
+	opts    OutboxOptions
+	sinks   []OutboxSink
+}
+
+func NewOutboxRelay(storage db.StoragerDB, logger *zap.SugaredLogger, opts OutboxOptions, sinks ...OutboxSink) *outboxRelay {
+	return &outboxRelay{
+		storage: storage,
+		logger:  logger,
+		opts:    opts,
+		sinks:   sinks,
+	}
+}
+
+func (r *outboxRelay) RunOutboxRelay(ctx context.Context, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+	ticker := time.NewTicker(r.opts.PollInterval)
+	defer ticker.Stop()
+	cleanup := time.NewTicker(r.opts.CleanupInterval)
+	defer cleanup.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.relayBatch(ctx)
+		case <-cleanup.C:
+			r.cleanup(ctx)
+		}
+	}
+}
+
+func (r *outboxRelay) cleanup(ctx context.Context) {
+
+	n, err := r.storage.DeleteOutboxPublished(ctx, r.opts.Retention)
+	if err != nil {
+		r.logger.Errorf("ошибка удаления опубликованных событий outbox %w", err)
+		return
+	}
+	if n > 0 {
+		r.logger.Infof("удалено опубликованных событий outbox: %d", n)
+	}
+}
+
+// relayBatch публикует пачку событий по возрастанию id. После ошибки событие откладывается,
+// а следующие события того же пользователя в этой пачке пропускаются, чтобы сохранить порядок.
+func (r *outboxRelay) relayBatch(ctx context.Context) {
+
+	events, err := r.storage.LeaseOutbox(ctx, r.opts.InstanceID, r.opts.BatchSize, r.opts.LeaseTTL)
+	if err != nil {
+		r.logger.Errorf("ошибка чтения outbox %w", err)
+		return
+	}
+
+	blocked := make(map[string]struct{})
+	published := make([]int64, 0, len(events))
+	for _, e := range events {
+		if _, ok := blocked[e.UserID]; ok {
+			continue
+		}
+		err := r.publish(ctx, e)
+		if ctx.Err() != nil {
+			break
+		}
+		if err == nil {
+			published = append(published, e.ID)
+			continue
+		}
+
+		blocked[e.UserID] = struct{}{}
+		retryIn := backoff(r.opts.RetryBaseDelay, r.opts.RetryMaxDelay, e.Attempts)
+		r.logger.Errorf("ошибка публикации события %d, повтор через %s: %w", e.ID, retryIn, err)
+		if err := r.storage.RetryOutboxEvent(ctx, e.ID, retryIn, truncate(err.Error(), maxOutboxError)); err != nil {
+			r.logger.Errorf("ошибка откладывания события %d %w", e.ID, err)
+		}
+	}
+
+	if len(published) == 0 {
+		return
+	}
+	// если отметить не удалось, события опубликуются еще раз - получатели отбрасывают повторы по id
+	if err := r.storage.MarkOutboxPublished(ctx, published); err != nil {
+		r.logger.Errorf("ошибка отметки опубликованных событий %w", err)
+	}
+}
+
+func (r *outboxRelay) publish(ctx context.Context, event models.OutboxEvent) error {
+	for _, sink := range r.sinks {
+		if err := sink.Publish(ctx, event); err != nil {
+			return fmt.Errorf("sink %s: %w", sink.Name(), err)
+		}
+	}
+	return nil
+}
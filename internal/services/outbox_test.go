@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	db "gophermart/internal/database"
+	"gophermart/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestOutboxRelay(t *testing.T) {
+
+	ctx := context.Background()
+	storage := db.NewMemory(nil)
+
+	require.NoError(t, storage.AddUser(ctx, "Jhon", "123"))
+	require.NoError(t, storage.AddUser(ctx, "Bob", "123"))
+	_, err := storage.AddOrder(ctx, "112233", "Jhon")
+	require.NoError(t, err)
+	_, err = storage.AddOrder(ctx, "900", "Bob")
+	require.NoError(t, err)
+	_, err = storage.PutStatuses(ctx, &[]models.OrderStatusNew{{Number: "112233", Status: models.StatusProcessed, Accrual: 500}})
+	require.NoError(t, err)
+
+	// HTTP sink принимает события, подписчик внутри процесса отказывается от первого события Jhon
+	var mu sync.Mutex
+	var received []int64
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event models.OutboxEvent
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		assert.NotEmpty(t, r.Header.Get(OutboxEventIDHeader))
+		mu.Lock()
+		received = append(received, event.ID)
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	subscribers := NewOutboxSubscribers()
+	var handled []models.OutboxEvent
+	failed := false
+	unsubscribe := subscribers.Subscribe(func(ctx context.Context, event models.OutboxEvent) error {
+		if event.UserID == "Jhon" && !failed {
+			failed = true
+			return errors.New("not now")
+		}
+		handled = append(handled, event)
+		return nil
+	})
+	defer unsubscribe()
+
+	relay := NewOutboxRelay(storage, zap.NewNop().Sugar(), OutboxOptions{
+		InstanceID:     "test",
+		PollInterval:   time.Millisecond,
+		BatchSize:      100,
+		LeaseTTL:       time.Minute,
+		RetryBaseDelay: time.Millisecond,
+		RetryMaxDelay:  time.Millisecond,
+	}, NewHTTPSink(receiver.URL, time.Second), subscribers)
+
+	// события Jhon после ошибки ждут повтора, событие Bob публикуется сразу
+	relay.relayBatch(ctx)
+	require.Len(t, handled, 1)
+	assert.Equal(t, "Bob", handled[0].UserID)
+
+	time.Sleep(5 * time.Millisecond)
+	relay.relayBatch(ctx)
+	require.Len(t, handled, 3)
+	assert.Equal(t, models.OutboxOrderUploaded, handled[1].Type)
+	assert.Equal(t, models.OutboxOrderStatus, handled[2].Type)
+	assert.Less(t, handled[1].ID, handled[2].ID)
+
+	// все опубликовано, первое событие Jhon HTTP sink получил дважды
+	relay.relayBatch(ctx)
+	assert.Len(t, handled, 3)
+	assert.Len(t, received, 4)
+	assert.Equal(t, received[0], received[2])
+}
+
+func TestOutboxRelayHTTPSinkFailure(t *testing.T) {
+
+	ctx := context.Background()
+	storage := db.NewMemory(nil)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	require.NoError(t, storage.AddUser(ctx, "Jhon", "123"))
+	_, err := storage.AddOrder(ctx, "112233", "Jhon")
+	require.NoError(t, err)
+
+	relay := NewOutboxRelay(storage, zap.NewNop().Sugar(), OutboxOptions{
+		InstanceID:     "test",
+		BatchSize:      100,
+		LeaseTTL:       time.Minute,
+		RetryBaseDelay: time.Hour,
+		RetryMaxDelay:  time.Hour,
+	}, NewHTTPSink(receiver.URL, time.Second))
+
+	relay.relayBatch(ctx)
+
+	// событие отложено на час и не опубликовано
+	events, err := storage.LeaseOutbox(ctx, "test", 100, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+}
+
+func TestOutboxRelayCleanup(t *testing.T) {
+
+	ctx := context.Background()
+	storage := db.NewMemory(nil)
+
+	require.NoError(t, storage.AddUser(ctx, "Jhon", "123"))
+	_, err := storage.AddOrder(ctx, "112233", "Jhon")
+	require.NoError(t, err)
+
+	relay := NewOutboxRelay(storage, zap.NewNop().Sugar(), OutboxOptions{
+		InstanceID:     "test",
+		BatchSize:      100,
+		LeaseTTL:       time.Minute,
+		RetryBaseDelay: time.Millisecond,
+		RetryMaxDelay:  time.Millisecond,
+		Retention:      time.Millisecond,
+	})
+
+	relay.relayBatch(ctx)
+	_, err = storage.AddOrder(ctx, "1177", "Jhon")
+	require.NoError(t, err)
+
+	// опубликованное событие удалено, неопубликованное осталось
+	time.Sleep(5 * time.Millisecond)
+	relay.cleanup(ctx)
+	deleted, err := storage.DeleteOutboxPublished(ctx, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), deleted)
+
+	events, err := storage.LeaseOutbox(ctx, "test", 100, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "Jhon", events[0].UserID)
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var ErrWeakPassword = errors.New("password does not meet policy")
+
+// PasswordPolicy - требования к новому паролю: длина в символах, сколько классов символов
+// (строчные, заглавные, цифры, прочие) должно встречаться и список утекших паролей.
+type PasswordPolicy struct {
+	MinLength  int
+	MaxLength  int
+	MinClasses int
+	breached   map[string]struct{}
+}
+
+// DefaultPasswordPolicy - политика без списка утекших паролей.
+// 72 байта - предел bcrypt, длиннее пароль не хешируется.
+func DefaultPasswordPolicy() *PasswordPolicy {
+	return &PasswordPolicy{
+		MinLength:  8,
+		MaxLength:  72,
+		MinClasses: 3,
+	}
+}
+
+// LoadBreachedPasswords читает список утекших паролей: по одному в строке, пустые строки
+// и строки с # пропускаются. Сравнение без учета регистра.
+func (p *PasswordPolicy) LoadBreachedPasswords(path string) error {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	breached := make(map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		breached[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("ошибка чтения списка паролей %s: %w", path, err)
+	}
+
+	p.breached = breached
+	return nil
+}
+
+// Breached - сколько паролей в списке утекших.
+func (p *PasswordPolicy) Breached() int {
+	return len(p.breached)
+}
+
+// Validate проверяет пароль пользователя login. Ошибка оборачивает ErrWeakPassword
+// и объясняет, какое требование не выполнено.
+func (p *PasswordPolicy) Validate(login, password string) error {
+
+	length := utf8.RuneCountInString(password)
+	switch {
+	case length < p.MinLength:
+		return fmt.Errorf("%w: пароль короче %d символов", ErrWeakPassword, p.MinLength)
+	case p.MaxLength > 0 && len(password) > p.MaxLength:
+		return fmt.Errorf("%w: пароль длиннее %d байт", ErrWeakPassword, p.MaxLength)
+	case passwordClasses(password) < p.MinClasses:
+		return fmt.Errorf("%w: нужны символы минимум %d классов из: строчные, заглавные, цифры, прочие", ErrWeakPassword, p.MinClasses)
+	case strings.EqualFold(password, login):
+		return fmt.Errorf("%w: пароль совпадает с логином", ErrWeakPassword)
+	}
+
+	if _, ok := p.breached[strings.ToLower(password)]; ok {
+		return fmt.Errorf("%w: пароль есть в списке утекших", ErrWeakPassword)
+	}
+	return nil
+}
+
+func passwordClasses(password string) int {
+
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+
+	policy := DefaultPasswordPolicy()
+
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# утекшие пароли\nPassword1\n\nqwerty-123\n"), 0o600))
+	require.NoError(t, policy.LoadBreachedPasswords(path))
+	assert.Equal(t, 2, policy.Breached())
+
+	tests := []struct {
+		name     string
+		login    string
+		password string
+		valid    bool
+	}{
+		{name: "короткий", login: "Jhon", password: "Ab1-", valid: false},
+		{name: "длиннее предела bcrypt", login: "Jhon", password: "Aa1-" + string(make([]byte, 70)), valid: false},
+		{name: "два класса", login: "Jhon", password: "abcdefgh12", valid: false},
+		{name: "совпадает с логином", login: "Jhon-Doe-1", password: "jhon-doe-1", valid: false},
+		{name: "утекший без учета регистра", login: "Jhon", password: "PASSWORD1", valid: false},
+		{name: "утекший", login: "Jhon", password: "qwerty-123", valid: false},
+		{name: "три класса", login: "Jhon", password: "Gopher-2024", valid: true},
+		{name: "не ASCII", login: "Jhon", password: "Пароль-2024", valid: true},
+	}
+
+	for _, test := range tests {
+		err := policy.Validate(test.login, test.password)
+		if test.valid {
+			assert.NoError(t, err, test.name)
+		} else {
+			assert.ErrorIs(t, err, ErrWeakPassword, test.name)
+		}
+	}
+
+	assert.Error(t, policy.LoadBreachedPasswords(filepath.Join(t.TempDir(), "missing.txt")))
+}
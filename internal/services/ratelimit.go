@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// пауза, если accrual ответил 429 без корректного Retry-After
+const defaultRetryAfter = 60 * time.Second
+
+var requestsPerMinuteRe = regexp.MustCompile(`(?i)no more than (\d+) requests? per minute`)
+
+// rateLimiter общий для всех воркеров RunAccrualRequester.
+// После 429 все воркеры ждут до момента из Retry-After, затем запросы
+// выдаются не чаще, чем позволяет лимит N из тела ответа.
+type rateLimiter struct {
+	mu          sync.Mutex
+	pausedUntil time.Time
+	next        time.Time
+	limit       int
+	interval    time.Duration
+	now         func() time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{now: time.Now}
+}
+
+// Wait блокирует воркер, пока не наступит его очередь отправить запрос.
+func (l *rateLimiter) Wait(ctx context.Context) error {
+
+	for {
+		slot := l.reserve()
+		delay := slot.Sub(l.now())
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		// пока воркер спал, другой воркер мог получить 429 и продлить паузу
+		if !l.paused() {
+			return nil
+		}
+	}
+}
+
+// OnTooManyRequests обрабатывает ответ 429: приостанавливает все воркеры
+// и запоминает лимит запросов. Возвращает момент окончания паузы.
+func (l *rateLimiter) OnTooManyRequests(retryAfter string, body []byte) time.Time {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	until := now.Add(parseRetryAfter(retryAfter, now))
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+
+	if n := parseRequestsPerMinute(body); n > 0 {
+		l.limit = n
+		l.interval = time.Minute / time.Duration(n)
+	}
+
+	return l.pausedUntil
+}
+
+// Limit - выученный лимит запросов в минуту, 0 если accrual его еще не сообщил.
+func (l *rateLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+func (l *rateLimiter) reserve() time.Time {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	slot := l.now()
+	if l.pausedUntil.After(slot) {
+		slot = l.pausedUntil
+	}
+	if l.next.After(slot) {
+		slot = l.next
+	}
+	if l.interval > 0 {
+		l.next = slot.Add(l.interval)
+	}
+	return slot
+}
+
+func (l *rateLimiter) paused() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.pausedUntil.After(l.now())
+}
+
+// parseRetryAfter понимает оба формата заголовка: число секунд и HTTP-дату.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return defaultRetryAfter
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return defaultRetryAfter
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return defaultRetryAfter
+}
+
+func parseRequestsPerMinute(body []byte) int {
+
+	match := requestsPerMinuteRe.FindSubmatch(body)
+	if match == nil {
+		return 0
+	}
+	n, err := strconv.Atoi(string(match[1]))
+	if err != nil {
+		return 0
+	}
+	return n
+}
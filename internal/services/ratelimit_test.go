@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"gophermart/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		value    string
+		expected time.Duration
+	}{
+		{name: "секунды", value: "60", expected: 60 * time.Second},
+		{name: "пустой заголовок", value: "", expected: defaultRetryAfter},
+		{name: "мусор", value: "soon", expected: defaultRetryAfter},
+		{name: "отрицательное значение", value: "-5", expected: defaultRetryAfter},
+		{name: "HTTP-дата", value: now.Add(90 * time.Second).Format(http.TimeFormat), expected: 90 * time.Second},
+		{name: "HTTP-дата в прошлом", value: now.Add(-time.Minute).Format(http.TimeFormat), expected: 0},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.expected, parseRetryAfter(tc.value, now), tc.name)
+	}
+}
+
+func TestParseRequestsPerMinute(t *testing.T) {
+
+	assert.Equal(t, 10, parseRequestsPerMinute([]byte("No more than 10 requests per minute allowed")))
+	assert.Equal(t, 1, parseRequestsPerMinute([]byte("No more than 1 request per minute allowed\n")))
+	assert.Equal(t, 0, parseRequestsPerMinute([]byte("Too Many Requests")))
+}
+
+func TestRateLimiterPauseAndLimit(t *testing.T) {
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := newRateLimiter()
+	l.now = func() time.Time { return now }
+
+	// до первого 429 ограничений нет
+	assert.Equal(t, now, l.reserve())
+	assert.Equal(t, now, l.reserve())
+
+	until := l.OnTooManyRequests("30", []byte("No more than 60 requests per minute allowed"))
+	assert.Equal(t, now.Add(30*time.Second), until)
+	assert.Equal(t, 60, l.Limit())
+	assert.True(t, l.paused())
+
+	// после паузы запросы идут не чаще раза в секунду
+	assert.Equal(t, until, l.reserve())
+	assert.Equal(t, until.Add(time.Second), l.reserve())
+	assert.Equal(t, until.Add(2*time.Second), l.reserve())
+
+	// более короткий Retry-After не сокращает уже назначенную паузу
+	assert.Equal(t, until, l.OnTooManyRequests("1", nil))
+}
+
+func TestRateLimiterWaitCanceled(t *testing.T) {
+
+	l := newRateLimiter()
+	l.OnTooManyRequests("60", nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(t, l.Wait(ctx), context.DeadlineExceeded)
+}
+
+func TestWorkerRetryAfter(t *testing.T) {
+
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte("No more than 120 requests per minute allowed"))
+			return
+		}
+		accuralHandler(w, r)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in := make(chan string, 2)
+	out := make(chan models.OrderStatusNew, 1)
+	var wg sync.WaitGroup
+
+	a := &accrual{
+		accrualSysremAdress: server.URL,
+		logger:              zap.NewNop().Sugar(),
+		limiter:             newRateLimiter(),
+	}
+
+	wg.Add(1)
+	go a.worker(ctx, in, out, &wg)
+
+	start := time.Now()
+	in <- "123"
+	in <- "456"
+	close(in)
+
+	order := <-out
+	require.Equal(t, models.OrderStatusNew{Number: "123", Status: statusRetry}, order)
+	order = <-out
+	require.Equal(t, "456", order.Number)
+	assert.GreaterOrEqual(t, time.Since(start), time.Second, "воркер должен выждать Retry-After")
+	assert.Equal(t, 120, a.limiter.Limit())
+
+	wg.Wait()
+}
+
+func TestWorkersSharePause(t *testing.T) {
+
+	var mu sync.Mutex
+	var calls []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, time.Now())
+		first := len(calls) == 1
+		mu.Unlock()
+		if first {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte("No more than 600 requests per minute allowed"))
+			return
+		}
+		accuralHandler(w, r)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := NewAccrual(server.URL, 1, 1, nil, zap.NewNop().Sugar(), 3, time.Hour, LeaseOptions{})
+	require.Equal(t, 3, a.numberOfWorkers)
+	assert.Equal(t, 1, NewAccrual(server.URL, 1, 1, nil, zap.NewNop().Sugar(), 0, time.Hour, LeaseOptions{}).numberOfWorkers)
+
+	in := make(chan string, 4)
+	out := make(chan models.OrderStatusNew, 4)
+	var wg sync.WaitGroup
+	for i := 0; i < a.numberOfWorkers; i++ {
+		wg.Add(1)
+		go a.worker(ctx, in, out, &wg)
+	}
+
+	// первый запрос получает 429, после этого ждут все воркеры
+	in <- "123"
+	require.Equal(t, models.OrderStatusNew{Number: "123", Status: statusRetry}, <-out)
+	a.limiter.mu.Lock()
+	pausedUntil := a.limiter.pausedUntil
+	a.limiter.mu.Unlock()
+
+	in <- "456"
+	in <- "789"
+	in <- "1011"
+	close(in)
+	for i := 0; i < 3; i++ {
+		assert.NotEqual(t, statusRetry, (<-out).Status)
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, calls, 4)
+	for _, call := range calls[1:] {
+		assert.False(t, call.Before(pausedUntil), "воркер не должен отправлять запрос во время паузы")
+	}
+	// после паузы запросы воркеров идут не чаще лимита: 600 в минуту
+	for i := 2; i < len(calls); i++ {
+		assert.GreaterOrEqual(t, calls[i].Sub(calls[i-1]), 50*time.Millisecond)
+	}
+}
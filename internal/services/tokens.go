@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	db "gophermart/internal/database"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// NewRefreshToken - случайный refresh-токен для клиента и его хеш для хранилища.
+func NewRefreshToken() (token, hash string, err error) {
+	return newOpaqueToken()
+}
+
+// NewResetToken - одноразовый токен сброса пароля и его хеш для хранилища.
+func NewResetToken() (token, hash string, err error) {
+	return newOpaqueToken()
+}
+
+// NewMFAToken - токен второго шага входа с 2FA и его хеш для хранилища.
+func NewMFAToken() (token, hash string, err error) {
+	return newOpaqueToken()
+}
+
+func newOpaqueToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken - SHA-256 токена. Токен случайный и длинный, поэтому соль не нужна,
+// а поиск по хешу остается точным. Так же хешируются токены сброса пароля.
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewSessionID - id новой сессии входа.
+func NewSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// tokenJanitor периодически удаляет истекшие отозванные токены и сессии,
+// а также счетчики неудачных входов, по которым не было неудач дольше attemptsTTL.
+type tokenJanitor struct {
+	storage     db.StoragerDB
+	logger      *zap.SugaredLogger
+	interval    time.Duration
+	attemptsTTL time.Duration
+}
+
+func NewTokenJanitor(storage db.StoragerDB, logger *zap.SugaredLogger, interval, attemptsTTL time.Duration) *tokenJanitor {
+	return &tokenJanitor{
+		storage:     storage,
+		logger:      logger,
+		interval:    interval,
+		attemptsTTL: attemptsTTL,
+	}
+}
+
+func (j *tokenJanitor) RunTokenJanitor(ctx context.Context, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			j.cleanup(ctx)
+		}
+	}
+}
+
+func (j *tokenJanitor) cleanup(ctx context.Context) {
+
+	n, err := j.storage.DeleteExpiredTokens(ctx)
+	if err != nil {
+		j.logger.Errorf("ошибка удаления истекших токенов и сессий %w", err)
+	} else if n > 0 {
+		j.logger.Infof("удалено истекших токенов и сессий: %d", n)
+	}
+
+	n, err = j.storage.DeleteStaleLoginAttempts(ctx, j.attemptsTTL)
+	if err != nil {
+		j.logger.Errorf("ошибка удаления счетчиков неудачных входов %w", err)
+	} else if n > 0 {
+		j.logger.Infof("удалено счетчиков неудачных входов: %d", n)
+	}
+}
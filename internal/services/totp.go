@@ -0,0 +1,177 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// TOTPDigits и TOTPPeriod - параметры RFC 6238, которые понимают все приложения-аутентификаторы
+	TOTPDigits = 6
+	TOTPPeriod = 30 * time.Second
+	// totpSkew - сколько соседних интервалов принимается из-за расхождения часов
+	totpSkew = 1
+
+	recoveryCodeBytes = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+var ErrSecretBox = errors.New("cannot decrypt secret")
+
+// NewTOTPSecret - случайный секрет TOTP (160 бит, как рекомендует RFC 4226) в base32.
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TOTPURI - otpauth:// URI для QR-кода приложения-аутентификатора.
+func TOTPURI(issuer, account, secret string) string {
+
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(TOTPDigits))
+	v.Set("period", fmt.Sprint(int(TOTPPeriod.Seconds())))
+
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// TOTPCode - код для интервала step (RFC 6238, HMAC-SHA1).
+func TOTPCode(secret string, step int64) (string, error) {
+
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", fmt.Errorf("неверный секрет TOTP: %w", err)
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", TOTPDigits, code%1000000), nil
+}
+
+// TOTPStep - номер интервала для момента t.
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTPPeriod.Seconds())
+}
+
+// ValidateTOTP проверяет код в текущем и соседних интервалах и возвращает интервал,
+// для которого код верен. Интервал нужен, чтобы не принять тот же код второй раз.
+func ValidateTOTP(secret, code string, t time.Time) (int64, bool) {
+
+	if !IsTOTPCode(code) {
+		return 0, false
+	}
+
+	now := TOTPStep(t)
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		expected, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// IsTOTPCode - похоже ли на код TOTP, а не на код восстановления.
+func IsTOTPCode(code string) bool {
+	if len(code) != TOTPDigits {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// NewRecoveryCodes - n одноразовых кодов восстановления вида xxxx-xxxx-xxxx-xxxx и их хеши.
+func NewRecoveryCodes(n int) (codes, hashes []string, err error) {
+
+	for i := 0; i < n; i++ {
+		b := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(totpEncoding.EncodeToString(b))
+		code := raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode - хеш кода восстановления без учета регистра, пробелов и дефисов.
+// Код случайный (80 бит), поэтому соль не нужна.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return HashRefreshToken(code)
+}
+
+// SecretBox шифрует секреты TOTP в базе (AES-256-GCM): в отличие от паролей их нельзя
+// хранить хешем, а утечка дампа базы не должна выдавать второй фактор.
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox - шифрование ключом, выведенным из key.
+func NewSecretBox(key string) (*SecretBox, error) {
+
+	sum := sha256.Sum256([]byte("gophermart-totp:" + key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretBox{aead: aead}, nil
+}
+
+func (b *SecretBox) Seal(plain string) ([]byte, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return b.aead.Seal(nonce, nonce, []byte(plain), nil), nil
+}
+
+func (b *SecretBox) Open(sealed []byte) (string, error) {
+	if len(sealed) < b.aead.NonceSize() {
+		return "", ErrSecretBox
+	}
+	nonce, data := sealed[:b.aead.NonceSize()], sealed[b.aead.NonceSize():]
+	plain, err := b.aead.Open(nil, nonce, data, nil)
+	if err != nil {
+		return "", ErrSecretBox
+	}
+	return string(plain), nil
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTP(t *testing.T) {
+
+	// тестовые векторы RFC 6238 (SHA1), последние 6 цифр
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" // "12345678901234567890"
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{unix: 59, code: "287082"},
+		{unix: 1111111109, code: "081804"},
+		{unix: 1234567890, code: "005924"},
+	}
+	for _, test := range tests {
+		code, err := TOTPCode(secret, TOTPStep(time.Unix(test.unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, test.code, code, test.unix)
+	}
+
+	now := time.Unix(1234567890, 0)
+	step, ok := ValidateTOTP(secret, "005924", now)
+	assert.True(t, ok)
+	assert.Equal(t, TOTPStep(now), step)
+
+	// соседний интервал принимается, более далекий - нет
+	step, ok = ValidateTOTP(secret, "005924", now.Add(TOTPPeriod))
+	assert.True(t, ok)
+	assert.Equal(t, TOTPStep(now), step)
+	_, ok = ValidateTOTP(secret, "005924", now.Add(2*TOTPPeriod))
+	assert.False(t, ok)
+
+	_, ok = ValidateTOTP(secret, "00592", now)
+	assert.False(t, ok)
+	_, ok = ValidateTOTP("не base32", "005924", now)
+	assert.False(t, ok)
+
+	secret, err := NewTOTPSecret()
+	require.NoError(t, err)
+	assert.Len(t, secret, 32)
+	uri := TOTPURI("Gophermart", "Jhon", secret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Gophermart:Jhon?"), uri)
+	assert.Contains(t, uri, "secret="+secret)
+}
+
+func TestRecoveryCodes(t *testing.T) {
+
+	codes, hashes, err := NewRecoveryCodes(10)
+	require.NoError(t, err)
+	require.Len(t, codes, 10)
+	require.Len(t, hashes, 10)
+
+	for i, code := range codes {
+		assert.Regexp(t, `^[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}$`, code)
+		assert.False(t, IsTOTPCode(code))
+		assert.Equal(t, hashes[i], HashRecoveryCode(code))
+	}
+	assert.NotEqual(t, codes[0], codes[1])
+
+	// регистр, пробелы и дефисы не важны
+	assert.Equal(t, hashes[0], HashRecoveryCode(" "+strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))))
+}
+
+func TestSecretBox(t *testing.T) {
+
+	box, err := NewSecretBox("key")
+	require.NoError(t, err)
+
+	sealed, err := box.Seal("JBSWY3DPEHPK3PXP")
+	require.NoError(t, err)
+	assert.NotContains(t, string(sealed), "JBSWY3DPEHPK3PXP")
+
+	plain, err := box.Open(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", plain)
+
+	other, err := NewSecretBox("other")
+	require.NoError(t, err)
+	_, err = other.Open(sealed)
+	assert.ErrorIs(t, err, ErrSecretBox)
+
+	sealed[len(sealed)-1] ^= 1
+	_, err = box.Open(sealed)
+	assert.ErrorIs(t, err, ErrSecretBox)
+	_, err = box.Open(nil)
+	assert.ErrorIs(t, err, ErrSecretBox)
+}
@@ -0,0 +1,342 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	db "gophermart/internal/database"
+	"gophermart/internal/models"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.uber.org/zap"
+)
+
+// Заголовки запроса на webhook. Подпись - HMAC-SHA256 от "<timestamp>.<тело>"
+// на секрете webhook'а, см. SignWebhook.
+const (
+	WebhookEventHeader     = "X-Gophermart-Event"
+	WebhookDeliveryHeader  = "X-Gophermart-Delivery"
+	WebhookTimestampHeader = "X-Gophermart-Timestamp"
+	WebhookSignatureHeader = "X-Gophermart-Signature"
+)
+
+// maxWebhookError - сколько символов ошибки соединения попадает в журнал доставок.
+// Тело ответа получателя не сохраняется: журнал видит пользователь.
+const maxWebhookError = 512
+
+var (
+	ErrWebhookURL     = errors.New("webhook url must be an absolute http(s) url")
+	ErrWebhookAddress = errors.New("webhook address is not public")
+)
+
+// WebhookResolver разрешает имя хоста webhook'а, net.DefaultResolver подходит.
+type WebhookResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// WebhookOptions - параметры доставки событий на webhook'и. Доставки захватываются
+// под InstanceID, как заказы в LeaseOptions, поэтому несколько экземпляров не шлют событие дважды.
+// Завершенные доставки удаляются из журнала раз в CleanupInterval, если они старше Retention.
+type WebhookOptions struct {
+	InstanceID      string
+	PollInterval    time.Duration
+	Timeout         time.Duration
+	MaxAttempts     int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	BatchSize       int
+	Retention       time.Duration
+	CleanupInterval time.Duration
+}
+
+type webhookDeliverer struct {
+	storage db.StoragerDB
+	logger  *zap.SugaredLogger
+	opts    WebhookOptions
+	client  *resty.Client
+	// allowPrivate отключает проверку адреса при соединении, только для тестов с httptest
+	allowPrivate bool
+}
+
+// NewWebhookDeliverer - доставка без перенаправлений и только на публичные адреса:
+// адрес проверяется при каждом соединении, поэтому смена DNS после регистрации
+// не позволит отправить запрос во внутреннюю сеть.
+func NewWebhookDeliverer(storage db.StoragerDB, logger *zap.SugaredLogger, opts WebhookOptions) *webhookDeliverer {
+
+	d := &webhookDeliverer{
+		storage: storage,
+		logger:  logger,
+		opts:    opts,
+	}
+
+	dialer := &net.Dialer{
+		Timeout: opts.Timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			if d.allowPrivate {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip, err := netip.ParseAddr(host); err != nil || !PublicIP(ip) {
+				return fmt.Errorf("%w: %s", ErrWebhookAddress, host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// через прокси проверялся бы адрес прокси, а не получателя
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	d.client = resty.New().
+		SetTransport(transport).
+		SetTimeout(opts.Timeout).
+		SetRedirectPolicy(resty.NoRedirectPolicy())
+	return d
+}
+
+// CheckWebhookURL проверяет адрес при регистрации: абсолютный http(s) URL, все адреса хоста
+// публичные. Не разрешившееся имя - тоже ErrWebhookURL.
+func CheckWebhookURL(ctx context.Context, resolver WebhookResolver, rawURL string) (*url.URL, error) {
+
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return nil, ErrWebhookURL
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	addrs, err := resolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return nil, fmt.Errorf("%w: не удалось разрешить %s", ErrWebhookURL, u.Hostname())
+	}
+	for _, addr := range addrs {
+		if ip, ok := netip.AddrFromSlice(addr.IP); !ok || !PublicIP(ip) {
+			return nil, fmt.Errorf("%w: %s", ErrWebhookAddress, addr.IP)
+		}
+	}
+	return u, nil
+}
+
+// nonPublicPrefixes - сети, куда webhook не отправляется: записи реестров IANA
+// IPv4/IPv6 Special-Purpose Address Registry, не достижимые глобально, и multicast.
+// Стандартные net.IP.IsPrivate и подобные покрывают не все из них.
+var nonPublicPrefixes = mustParsePrefixes(
+	// IPv4
+	"0.0.0.0/8",       // "эта сеть"
+	"10.0.0.0/8",      // частная сеть
+	"100.64.0.0/10",   // carrier-grade NAT
+	"127.0.0.0/8",     // loopback
+	"169.254.0.0/16",  // link-local, метаданные облака
+	"172.16.0.0/12",   // частная сеть
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // документация TEST-NET-1
+	"192.88.99.0/24",  // 6to4 relay anycast
+	"192.168.0.0/16",  // частная сеть
+	"198.18.0.0/15",   // тестирование производительности
+	"198.51.100.0/24", // документация TEST-NET-2
+	"203.0.113.0/24",  // документация TEST-NET-3
+	"224.0.0.0/4",     // multicast
+	"240.0.0.0/4",     // зарезервировано, в том числе broadcast
+	// IPv6
+	"::/128",         // unspecified
+	"::1/128",        // loopback
+	"::ffff:0:0/96",  // IPv4-mapped, адрес разворачивается до проверки
+	"64:ff9b::/96",   // NAT64, может вести на 127.0.0.1 или 10.x
+	"64:ff9b:1::/48", // локальный NAT64
+	"100::/64",       // discard-only
+	"2001::/23",      // IETF protocol assignments, в том числе Teredo
+	"2001:db8::/32",  // документация
+	"2002::/16",      // 6to4, может вести на внутренний IPv4
+	"3fff::/20",      // документация
+	"5f00::/16",      // SRv6 SID
+	"fc00::/7",       // unique local
+	"fe80::/10",      // link-local
+	"fec0::/10",      // site-local
+	"ff00::/8",       // multicast
+)
+
+func mustParsePrefixes(prefixes ...string) []netip.Prefix {
+	parsed := make([]netip.Prefix, 0, len(prefixes))
+	for _, p := range prefixes {
+		parsed = append(parsed, netip.MustParsePrefix(p))
+	}
+	return parsed
+}
+
+// PublicIP - можно ли слать webhook на адрес: адрес не входит в nonPublicPrefixes.
+// IPv4, записанный как IPv6 (::ffff:a.b.c.d), проверяется как IPv4.
+func PublicIP(ip netip.Addr) bool {
+	if !ip.IsValid() {
+		return false
+	}
+	// адрес с зоной не попадает ни в одну сеть, fe80::1%eth0 - тот же link-local
+	ip = ip.Unmap().WithZone("")
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewWebhookSecret - случайный секрет для подписи событий нового webhook'а.
+func NewWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SignWebhook - значение заголовка X-Gophermart-Signature. Получатель проверяет подпись
+// и отбрасывает запросы со старым timestamp, чтобы их нельзя было повторить.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *webhookDeliverer) RunWebhookDeliverer(ctx context.Context, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+	ticker := time.NewTicker(d.opts.PollInterval)
+	defer ticker.Stop()
+	cleanup := time.NewTicker(d.opts.CleanupInterval)
+	defer cleanup.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.deliverBatch(ctx)
+		case <-cleanup.C:
+			d.cleanup(ctx)
+		}
+	}
+}
+
+func (d *webhookDeliverer) cleanup(ctx context.Context) {
+
+	n, err := d.storage.DeleteFinishedWebhookDeliveries(ctx, d.opts.Retention)
+	if err != nil {
+		d.logger.Errorf("ошибка удаления завершенных доставок webhook %w", err)
+		return
+	}
+	if n > 0 {
+		d.logger.Infof("удалено завершенных доставок webhook: %d", n)
+	}
+}
+
+// deliverBatch захватывает доставки, время которых наступило, и отправляет их параллельно.
+// Захват держится дольше таймаута запроса: пока идет отправка, доставку не возьмет другой экземпляр.
+func (d *webhookDeliverer) deliverBatch(ctx context.Context) {
+
+	deliveries, err := d.storage.LeaseWebhookDeliveries(ctx, d.opts.InstanceID, d.opts.BatchSize, 2*d.opts.Timeout)
+	if err != nil {
+		d.logger.Errorf("ошибка захвата доставок webhook %w", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func(delivery models.WebhookDelivery) {
+			defer wg.Done()
+			attempt := d.deliver(ctx, delivery)
+			if ctx.Err() != nil {
+				// захват истечет, и доставку повторит следующий запуск
+				return
+			}
+			err := d.storage.SaveWebhookAttempt(ctx, attempt)
+			switch {
+			case errors.Is(err, db.ErrWebhookLeaseLost):
+				// захват истек, и доставку уже взял другой экземпляр - его результат главнее
+				d.logger.Infof("захват доставки %d потерян, результат попытки не записан", delivery.ID)
+			case err != nil:
+				d.logger.Errorf("ошибка записи попытки доставки %d %w", delivery.ID, err)
+			}
+		}(delivery)
+	}
+	wg.Wait()
+}
+
+// deliver отправляет событие и решает, что делать с доставкой дальше:
+// ответ 2xx - доставлено, иначе (в том числе перенаправление) повтор с удвоением паузы,
+// пока не кончатся попытки.
+func (d *webhookDeliverer) deliver(ctx context.Context, delivery models.WebhookDelivery) models.WebhookAttempt {
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	resp, err := d.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetHeader(WebhookEventHeader, delivery.Event).
+		SetHeader(WebhookDeliveryHeader, strconv.FormatInt(delivery.ID, 10)).
+		SetHeader(WebhookTimestampHeader, timestamp).
+		SetHeader(WebhookSignatureHeader, SignWebhook(delivery.Secret, timestamp, delivery.Payload)).
+		SetBody([]byte(delivery.Payload)).
+		Post(delivery.URL)
+
+	attempt := models.WebhookAttempt{DeliveryID: delivery.ID, InstanceID: d.opts.InstanceID}
+	switch {
+	case err != nil:
+		attempt.Error = truncate(err.Error(), maxWebhookError)
+	case resp.IsSuccess():
+		attempt.Status = models.DeliveryDelivered
+		attempt.StatusCode = resp.StatusCode()
+		return attempt
+	default:
+		attempt.StatusCode = resp.StatusCode()
+	}
+
+	if delivery.Attempts+1 >= d.opts.MaxAttempts {
+		attempt.Status = models.DeliveryFailed
+		d.logger.Infof("доставка %d на webhook %d не удалась после %d попыток", delivery.ID, delivery.WebhookID, delivery.Attempts+1)
+		return attempt
+	}
+	attempt.Status = models.DeliveryPending
+	attempt.RetryIn = d.retryDelay(delivery.Attempts)
+	return attempt
+}
+
+// retryDelay - пауза после attempts неудачных попыток: BaseDelay * 2^attempts, не больше MaxDelay.
+func (d *webhookDeliverer) retryDelay(attempts int) time.Duration {
+	return backoff(d.opts.BaseDelay, d.opts.MaxDelay, attempts)
+}
+
+// backoff - base * 2^attempts, не больше max.
+func backoff(base, max time.Duration, attempts int) time.Duration {
+	if attempts >= 30 {
+		return max
+	}
+	delay := base << attempts
+	if delay <= 0 || delay > max {
+		return max
+	}
+	return delay
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return fmt.Sprintf("%s...", s[:n])
+}
@@ -0,0 +1,322 @@
+package services
+
+import (
+	"context"
+	"errors"
+	db "gophermart/internal/database"
+	"gophermart/internal/models"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestWebhookDeliverer(t *testing.T) {
+
+	ctx := context.Background()
+	storage := db.NewMemory(nil)
+
+	// получатель проверяет подпись и отвечает ошибкой на первую попытку
+	var mu sync.Mutex
+	var calls int
+	var bodies [][]byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		signature := SignWebhook("secret", r.Header.Get(WebhookTimestampHeader), body)
+		assert.Equal(t, signature, r.Header.Get(WebhookSignatureHeader))
+		assert.Equal(t, models.WebhookEventOrderProcessed, r.Header.Get(WebhookEventHeader))
+		assert.NotEmpty(t, r.Header.Get(WebhookDeliveryHeader))
+
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+			return
+		}
+		bodies = append(bodies, body)
+	}))
+	defer receiver.Close()
+
+	require.NoError(t, storage.AddUser(ctx, "Jhon", "123"))
+	webhook, err := storage.AddWebhook(ctx, "Jhon", receiver.URL, "secret")
+	require.NoError(t, err)
+	_, err = storage.AddOrder(ctx, "112233", "Jhon")
+	require.NoError(t, err)
+	_, err = storage.PutStatuses(ctx, &[]models.OrderStatusNew{{Number: "112233", Status: models.StatusProcessed, Accrual: 500}})
+	require.NoError(t, err)
+
+	d := NewWebhookDeliverer(storage, zap.NewNop().Sugar(), WebhookOptions{
+		InstanceID:   "test",
+		PollInterval: 10 * time.Millisecond,
+		Timeout:      time.Second,
+		MaxAttempts:  3,
+		BaseDelay:    time.Millisecond,
+		MaxDelay:     time.Millisecond,
+		BatchSize:    10,
+	})
+	// httptest слушает loopback
+	d.allowPrivate = true
+
+	d.deliverBatch(ctx)
+	deliveries, err := storage.GetWebhookDeliveries(ctx, "Jhon", webhook.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, models.DeliveryPending, deliveries[0].Status)
+	assert.Equal(t, 1, deliveries[0].Attempts)
+	assert.Equal(t, http.StatusServiceUnavailable, deliveries[0].LastStatusCode)
+
+	time.Sleep(5 * time.Millisecond)
+	d.deliverBatch(ctx)
+	deliveries, err = storage.GetWebhookDeliveries(ctx, "Jhon", webhook.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, models.DeliveryDelivered, deliveries[0].Status)
+	assert.Equal(t, 2, deliveries[0].Attempts)
+	require.Len(t, bodies, 1)
+	assert.JSONEq(t, string(deliveries[0].Payload), string(bodies[0]))
+}
+
+func TestWebhookDelivererGivesUp(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	storage := db.NewMemory(nil)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusGone)
+	}))
+	defer receiver.Close()
+
+	require.NoError(t, storage.AddUser(ctx, "Jhon", "123"))
+	webhook, err := storage.AddWebhook(ctx, "Jhon", receiver.URL, "secret")
+	require.NoError(t, err)
+	_, err = storage.AddOrder(ctx, "112233", "Jhon")
+	require.NoError(t, err)
+	_, err = storage.PutStatuses(ctx, &[]models.OrderStatusNew{{Number: "112233", Status: models.StatusInvalid}})
+	require.NoError(t, err)
+
+	d := NewWebhookDeliverer(storage, zap.NewNop().Sugar(), WebhookOptions{
+		InstanceID:      "test",
+		PollInterval:    5 * time.Millisecond,
+		Timeout:         time.Second,
+		MaxAttempts:     3,
+		BaseDelay:       time.Millisecond,
+		MaxDelay:        2 * time.Millisecond,
+		BatchSize:       10,
+		Retention:       time.Hour,
+		CleanupInterval: time.Hour,
+	})
+	// httptest слушает loopback
+	d.allowPrivate = true
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go d.RunWebhookDeliverer(ctx, &wg)
+
+	var deliveries []models.WebhookDelivery
+	for i := 0; i < 100; i++ {
+		deliveries, err = storage.GetWebhookDeliveries(ctx, "Jhon", webhook.ID, 10)
+		require.NoError(t, err)
+		if len(deliveries) == 1 && deliveries[0].Status == models.DeliveryFailed {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	wg.Wait()
+
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, models.DeliveryFailed, deliveries[0].Status)
+	assert.Equal(t, 3, deliveries[0].Attempts)
+	assert.Equal(t, http.StatusGone, deliveries[0].LastStatusCode)
+	// тело ответа получателя пользователю не показывается
+	assert.Empty(t, deliveries[0].LastError)
+}
+
+func TestWebhookDelivererCleanup(t *testing.T) {
+
+	ctx := context.Background()
+	storage := db.NewMemory(nil)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer receiver.Close()
+
+	require.NoError(t, storage.AddUser(ctx, "Jhon", "123"))
+	webhook, err := storage.AddWebhook(ctx, "Jhon", receiver.URL, "secret")
+	require.NoError(t, err)
+	_, err = storage.AddOrder(ctx, "112233", "Jhon")
+	require.NoError(t, err)
+	_, err = storage.PutStatuses(ctx, &[]models.OrderStatusNew{{Number: "112233", Status: models.StatusProcessed, Accrual: 500}})
+	require.NoError(t, err)
+
+	d := NewWebhookDeliverer(storage, zap.NewNop().Sugar(), WebhookOptions{
+		InstanceID:  "test",
+		Timeout:     time.Second,
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    time.Millisecond,
+		BatchSize:   10,
+		Retention:   time.Millisecond,
+	})
+	d.allowPrivate = true
+
+	d.deliverBatch(ctx)
+	require.NoError(t, storage.WithdrawBalance(ctx, "Jhon", models.OrderSum{OrderNumber: "2377225624", Sum: 100}))
+
+	// доставленная запись удалена из журнала, ожидающая доставки осталась
+	time.Sleep(5 * time.Millisecond)
+	d.cleanup(ctx)
+	deliveries, err := storage.GetWebhookDeliveries(ctx, "Jhon", webhook.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, models.DeliveryPending, deliveries[0].Status)
+	assert.Equal(t, models.WebhookEventWithdrawal, deliveries[0].Event)
+}
+
+func TestWebhookDelivererPublicOnly(t *testing.T) {
+
+	ctx := context.Background()
+	storage := db.NewMemory(nil)
+
+	var mu sync.Mutex
+	var paths []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		if r.URL.Path == "/hook" {
+			http.Redirect(w, r, "/internal", http.StatusFound)
+		}
+	}))
+	defer receiver.Close()
+
+	require.NoError(t, storage.AddUser(ctx, "Jhon", "123"))
+	webhook, err := storage.AddWebhook(ctx, "Jhon", receiver.URL+"/hook", "secret")
+	require.NoError(t, err)
+	_, err = storage.AddOrder(ctx, "112233", "Jhon")
+	require.NoError(t, err)
+	_, err = storage.PutStatuses(ctx, &[]models.OrderStatusNew{{Number: "112233", Status: models.StatusProcessed, Accrual: 500}})
+	require.NoError(t, err)
+
+	d := NewWebhookDeliverer(storage, zap.NewNop().Sugar(), WebhookOptions{
+		InstanceID:   "test",
+		PollInterval: 10 * time.Millisecond,
+		Timeout:      time.Second,
+		MaxAttempts:  3,
+		BaseDelay:    time.Millisecond,
+		MaxDelay:     time.Millisecond,
+		BatchSize:    10,
+	})
+
+	// соединение с loopback запрещено, даже если адрес попал в базу в обход регистрации
+	d.deliverBatch(ctx)
+	deliveries, err := storage.GetWebhookDeliveries(ctx, "Jhon", webhook.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, models.DeliveryPending, deliveries[0].Status)
+	assert.Contains(t, deliveries[0].LastError, ErrWebhookAddress.Error())
+	assert.Empty(t, paths)
+
+	// перенаправление не выполняется
+	d.allowPrivate = true
+	time.Sleep(5 * time.Millisecond)
+	d.deliverBatch(ctx)
+	deliveries, err = storage.GetWebhookDeliveries(ctx, "Jhon", webhook.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, models.DeliveryPending, deliveries[0].Status)
+	assert.Equal(t, []string{"/hook"}, paths)
+}
+
+// fakeResolver разрешает имена без сети
+type fakeResolver map[string][]net.IPAddr
+
+func (r fakeResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IPAddr{{IP: ip}}, nil
+	}
+	if addrs, ok := r[host]; ok {
+		return addrs, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func TestCheckWebhookURL(t *testing.T) {
+
+	ctx := context.Background()
+	resolver := fakeResolver{
+		"example.com":      {{IP: net.ParseIP("93.184.216.34")}},
+		"internal.example": {{IP: net.ParseIP("93.184.216.34")}, {IP: net.ParseIP("10.0.0.5")}},
+	}
+
+	u, err := CheckWebhookURL(ctx, resolver, "https://example.com/hook")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/hook", u.String())
+	_, err = CheckWebhookURL(ctx, resolver, "http://93.184.216.34:8080/hook")
+	assert.NoError(t, err)
+
+	for _, rawURL := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://[::1]/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.1.2.3/hook",
+		"http://192.168.0.1/hook",
+		"http://0.0.0.0/hook",
+		"http://224.0.0.1/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+		"http://100.64.0.1/hook",
+		"http://198.18.0.1/hook",
+		"http://[64:ff9b::7f00:1]/hook",
+		"https://internal.example/hook",
+	} {
+		_, err := CheckWebhookURL(ctx, resolver, rawURL)
+		assert.ErrorIs(t, err, ErrWebhookAddress, rawURL)
+	}
+	for _, rawURL := range []string{"ftp://example.com", "/hook", "", "http://unknown.example/hook"} {
+		_, err := CheckWebhookURL(ctx, resolver, rawURL)
+		assert.ErrorIs(t, err, ErrWebhookURL, rawURL)
+	}
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+
+	d := NewWebhookDeliverer(nil, nil, WebhookOptions{BaseDelay: 10 * time.Second, MaxDelay: time.Hour})
+
+	assert.Equal(t, 10*time.Second, d.retryDelay(0))
+	assert.Equal(t, 20*time.Second, d.retryDelay(1))
+	assert.Equal(t, 80*time.Second, d.retryDelay(3))
+	assert.Equal(t, time.Hour, d.retryDelay(9))
+	assert.Equal(t, time.Hour, d.retryDelay(100))
+}
+
+func TestPublicIP(t *testing.T) {
+
+	for _, addr := range []string{
+		"0.0.0.0", "0.1.2.3", "10.1.2.3", "100.64.0.1", "100.127.255.254", "127.0.0.1", "169.254.169.254",
+		"172.16.0.1", "192.0.0.8", "192.0.2.1", "192.168.1.1", "198.18.0.1", "198.19.255.255",
+		"224.0.0.1", "240.0.0.1", "255.255.255.255",
+		"::", "::1", "::ffff:127.0.0.1", "::ffff:10.0.0.1", "64:ff9b::7f00:1", "64:ff9b::a00:1",
+		"64:ff9b:1::1", "100::1", "2001::1", "2001:1ff::1", "2001:db8::1", "2002:7f00:1::1",
+		"fc00::1", "fd12:3456::1", "fe80::1", "fe80::1%eth0", "ff02::1",
+	} {
+		assert.False(t, PublicIP(netip.MustParseAddr(addr)), addr)
+	}
+
+	for _, addr := range []string{
+		"8.8.8.8", "93.184.216.34", "100.63.255.255", "100.128.0.1", "198.17.255.255", "198.20.0.1",
+		"223.255.255.255", "::ffff:8.8.8.8", "2001:200::1", "2606:4700::1111", "2a00:1450::1",
+	} {
+		assert.True(t, PublicIP(netip.MustParseAddr(addr)), addr)
+	}
+
+	assert.False(t, PublicIP(netip.Addr{}))
+}
@@ -0,0 +1,370 @@
+package transport
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	db "gophermart/internal/database"
+	"gophermart/internal/models"
+	jwtpackage "gophermart/pkg/jwt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+type roleRequest struct {
+	Role string `json:"role"`
+}
+
+type blockRequest struct {
+	Reason string `json:"reason"`
+}
+
+type adjustmentRequest struct {
+	Amount     models.Amount `json:"amount"`
+	ReasonCode string        `json:"reason_code"`
+	Reason     string        `json:"reason"`
+	Ticket     string        `json:"ticket"`
+}
+
+type refundRequest struct {
+	OrderNumber string        `json:"order"`
+	Amount      models.Amount `json:"amount"`
+	Reason      string        `json:"reason"`
+	Ticket      string        `json:"ticket"`
+}
+
+// GetUserProfile - роль, блокировка и баланс пользователя.
+func (h *handlersData) GetUserProfile(w http.ResponseWriter, r *http.Request) {
+
+	// 200 — пользователь;
+	// 403 — недостаточно прав;
+	// 404 — пользователь не найден;
+	// 500 — внутренняя ошибка сервера.
+
+	login := chi.URLParam(r, "login")
+	user, ok := h.adminUser(w, login)
+	if !ok {
+		return
+	}
+	balance, err := h.storage.GetBalance(h.ctx, login)
+	if err != nil {
+		h.logger.Errorf("Ошибка запроса к базе: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, models.UserProfile{
+		Login:         user.Login,
+		Role:          user.Role,
+		Blocked:       user.Blocked,
+		BlockedReason: user.BlockedReason,
+		Balance:       balance,
+	})
+}
+
+// GetUserOrders - заказы пользователя, как их видит он сам.
+func (h *handlersData) GetUserOrders(w http.ResponseWriter, r *http.Request) {
+
+	// 200 — список заказов, возможно пустой;
+	// 403 — недостаточно прав;
+	// 404 — пользователь не найден;
+	// 500 — внутренняя ошибка сервера.
+
+	login := chi.URLParam(r, "login")
+	if _, ok := h.adminUser(w, login); !ok {
+		return
+	}
+	orders, err := h.storage.GetOrders(h.ctx, login)
+	if err != nil {
+		h.logger.Errorf("Ошибка запроса к базе: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if orders == nil {
+		orders = []models.OrderStatus{}
+	}
+	h.writeJSON(w, http.StatusOK, orders)
+}
+
+// GetUserLedger - все движения баллов пользователя: начисления, списания и корректировки.
+func (h *handlersData) GetUserLedger(w http.ResponseWriter, r *http.Request) {
+
+	// 200 — журнал, возможно пустой;
+	// 403 — недостаточно прав;
+	// 404 — пользователь не найден;
+	// 500 — внутренняя ошибка сервера.
+
+	login := chi.URLParam(r, "login")
+	if _, ok := h.adminUser(w, login); !ok {
+		return
+	}
+	entries, err := h.storage.GetLedger(h.ctx, login)
+	if err != nil {
+		h.logger.Errorf("Ошибка запроса к базе: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []models.LedgerEntry{}
+	}
+	h.writeJSON(w, http.StatusOK, entries)
+}
+
+// SetUserRole назначает роль. Сессии пользователя отзываются, новая роль действует со следующего входа.
+func (h *handlersData) SetUserRole(w http.ResponseWriter, r *http.Request) {
+
+	// 204 — роль назначена;
+	// 400 — неизвестная роль;
+	// 403 — недостаточно прав;
+	// 404 — пользователь не найден;
+	// 409 — попытка изменить собственную роль;
+	// 500 — внутренняя ошибка сервера.
+
+	login := chi.URLParam(r, "login")
+	var data roleRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !models.ValidRole(data.Role) {
+		http.Error(w, "unknown role", http.StatusBadRequest)
+		return
+	}
+	// последний администратор не должен случайно лишить себя прав
+	if login == operator(r) {
+		http.Error(w, "нельзя изменить собственную роль", http.StatusConflict)
+		return
+	}
+
+	err := h.storage.SetUserRole(h.ctx, login, data.Role)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	case err != nil:
+		h.logger.Errorf("ошибка назначения роли %s: %w", login, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Infof("%s назначил пользователю %s роль %s", operator(r), login, data.Role)
+	setResponseHeaders(w, ApplicationJSON, http.StatusNoContent)
+}
+
+// BlockUser блокирует аккаунт с указанием причины и отзывает его сессии.
+func (h *handlersData) BlockUser(w http.ResponseWriter, r *http.Request) {
+
+	// 204 — аккаунт заблокирован;
+	// 400 — не указана причина;
+	// 403 — недостаточно прав;
+	// 404 — пользователь не найден;
+	// 409 — попытка заблокировать себя;
+	// 500 — внутренняя ошибка сервера.
+
+	login := chi.URLParam(r, "login")
+	var data blockRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data.Reason = strings.TrimSpace(data.Reason)
+	if data.Reason == "" {
+		http.Error(w, "reason required", http.StatusBadRequest)
+		return
+	}
+	if login == operator(r) {
+		http.Error(w, "нельзя заблокировать себя", http.StatusConflict)
+		return
+	}
+
+	err := h.storage.BlockUser(h.ctx, login, data.Reason)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	case err != nil:
+		h.logger.Errorf("ошибка блокировки %s: %w", login, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Infof("%s заблокировал пользователя %s: %s", operator(r), login, data.Reason)
+	setResponseHeaders(w, ApplicationJSON, http.StatusNoContent)
+}
+
+// UnblockUser снимает блокировку аккаунта.
+func (h *handlersData) UnblockUser(w http.ResponseWriter, r *http.Request) {
+
+	// 204 — блокировка снята или ее не было;
+	// 403 — недостаточно прав;
+	// 404 — пользователь не найден;
+	// 500 — внутренняя ошибка сервера.
+
+	login := chi.URLParam(r, "login")
+	err := h.storage.UnblockUser(h.ctx, login)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	case err != nil:
+		h.logger.Errorf("ошибка разблокировки %s: %w", login, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Infof("%s разблокировал пользователя %s", operator(r), login)
+	setResponseHeaders(w, ApplicationJSON, http.StatusNoContent)
+}
+
+// AdjustBalance вручную начисляет (amount > 0) или списывает (amount < 0) баллы
+// с кодом причины, пояснением и номером обращения.
+func (h *handlersData) AdjustBalance(w http.ResponseWriter, r *http.Request) {
+
+	// 201 — корректировка проведена;
+	// 400 — нулевая сумма, неизвестный код причины, не указаны пояснение или обращение;
+	// 403 — недостаточно прав;
+	// 404 — пользователь не найден;
+	// 409 — списание больше баланса;
+	// 500 — внутренняя ошибка сервера.
+
+	var data adjustmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// возврату нужен заказ, для него отдельный RefundWithdrawal
+	if !models.ValidAdjustmentReason(data.ReasonCode) || data.ReasonCode == models.AdjustmentRefund {
+		http.Error(w, "unknown reason_code", http.StatusBadRequest)
+		return
+	}
+	if data.Amount == 0 {
+		http.Error(w, "non-zero amount required", http.StatusBadRequest)
+		return
+	}
+
+	h.adjustBalance(w, r, models.BalanceAdjustment{
+		Amount:     data.Amount,
+		ReasonCode: data.ReasonCode,
+		Reason:     data.Reason,
+		Ticket:     data.Ticket,
+	})
+}
+
+// RefundWithdrawal возвращает пользователю баллы, списанные в счет заказа: весь невозвращенный
+// остаток (amount не указан) или его часть. Сумма списаний в балансе уменьшается на возврат.
+func (h *handlersData) RefundWithdrawal(w http.ResponseWriter, r *http.Request) {
+
+	// 201 — возврат проведен;
+	// 400 — не указаны заказ, пояснение или обращение, отрицательная сумма;
+	// 403 — недостаточно прав;
+	// 404 — пользователь не найден;
+	// 409 — по заказу нет списания, оно уже возвращено или сумма больше остатка;
+	// 500 — внутренняя ошибка сервера.
+
+	var data refundRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data.OrderNumber = strings.TrimSpace(data.OrderNumber)
+	if data.OrderNumber == "" {
+		http.Error(w, "order required", http.StatusBadRequest)
+		return
+	}
+	if data.Amount < 0 {
+		http.Error(w, "amount must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	h.adjustBalance(w, r, models.BalanceAdjustment{
+		Amount:      data.Amount,
+		ReasonCode:  models.AdjustmentRefund,
+		Reason:      data.Reason,
+		Ticket:      data.Ticket,
+		OrderNumber: data.OrderNumber,
+	})
+}
+
+// adjustBalance проводит корректировку пользователю из URL от имени текущего сотрудника.
+func (h *handlersData) adjustBalance(w http.ResponseWriter, r *http.Request, adjustment models.BalanceAdjustment) {
+
+	adjustment.UserID = chi.URLParam(r, "login")
+	adjustment.Operator = operator(r)
+	adjustment.Reason = strings.TrimSpace(adjustment.Reason)
+	adjustment.Ticket = strings.TrimSpace(adjustment.Ticket)
+	if adjustment.Reason == "" || adjustment.Ticket == "" {
+		http.Error(w, "reason and ticket required", http.StatusBadRequest)
+		return
+	}
+
+	adjustment, err := h.storage.AdjustBalance(h.ctx, adjustment)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	case errors.Is(err, db.ErrNotEnoughFunds), errors.Is(err, db.ErrInvalidRefund):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, db.ErrInvalidReasonCode), errors.Is(err, db.ErrNonPositiveSum), errors.Is(err, db.ErrEmptyValue):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		h.logger.Errorf("ошибка корректировки баланса %s: %w", adjustment.UserID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Infof("%s изменил баланс %s на %s (%s, обращение %s): %s", adjustment.Operator, adjustment.UserID,
+		adjustment.Amount, adjustment.ReasonCode, adjustment.Ticket, adjustment.Reason)
+	h.writeJSON(w, http.StatusCreated, adjustment)
+}
+
+// GetUserAdjustments - ручные корректировки и возвраты пользователя с причинами и авторами.
+func (h *handlersData) GetUserAdjustments(w http.ResponseWriter, r *http.Request) {
+
+	// 200 — корректировки, возможно пустой список;
+	// 403 — недостаточно прав;
+	// 404 — пользователь не найден;
+	// 500 — внутренняя ошибка сервера.
+
+	login := chi.URLParam(r, "login")
+	if _, ok := h.adminUser(w, login); !ok {
+		return
+	}
+	adjustments, err := h.storage.GetBalanceAdjustments(h.ctx, login)
+	if err != nil {
+		h.logger.Errorf("Ошибка запроса к базе: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if adjustments == nil {
+		adjustments = []models.BalanceAdjustment{}
+	}
+	h.writeJSON(w, http.StatusOK, adjustments)
+}
+
+// adminUser - пользователь для административной операции. false - ответ уже отправлен.
+func (h *handlersData) adminUser(w http.ResponseWriter, login string) (models.User, bool) {
+
+	user, err := h.storage.GetUser(h.ctx, login)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "user not found", http.StatusNotFound)
+		return user, false
+	case err != nil:
+		h.logger.Errorf("Ошибка при получении пользователя %s: %w", login, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return user, false
+	}
+	return user, true
+}
+
+// operator - логин сотрудника, выполняющего административную операцию.
+func operator(r *http.Request) string {
+	if claims, ok := r.Context().Value(claimsKey).(*jwtpackage.Claims); ok {
+		return claims.UserID
+	}
+	return ""
+}
@@ -19,10 +19,8 @@ func (h *handlersData) GetBalance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	balanceInterface, err := h.storage.WithRetry(h.ctx, h.storage.GetBalance(h.ctx, userID))
-	balance, ok := balanceInterface.(models.Balance)
-
-	if err != nil || !ok {
+	balance, err := h.storage.GetBalance(h.ctx, userID)
+	if err != nil {
 		h.logger.Errorf("ошибка при получении баланса: %w", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -85,7 +83,7 @@ func (h *handlersData) WithdrawBalance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = h.storage.WithRetry(h.ctx, h.storage.WithdrawBalance(h.ctx, userID, data))
+	err = h.storage.WithdrawBalance(h.ctx, userID, data)
 	switch {
 	case errors.Is(err, db.ErrNotEnoughFunds):
 
@@ -93,6 +91,12 @@ func (h *handlersData) WithdrawBalance(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusPaymentRequired)
 		return
 
+	case errors.Is(err, db.ErrNonPositiveSum):
+
+		h.logger.Errorf("некорректная сумма списания %s", data.Sum)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+
 	case err != nil:
 
 		h.logger.Errorf("ошибка получения данных из БД %w", err)
@@ -117,8 +121,17 @@ func (h *handlersData) GetWithdrawals(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	withdrawalsInterface, err := h.storage.WithRetry(h.ctx, h.storage.GetWithdrawals(h.ctx, userID))
-	withdrawals, ok := withdrawalsInterface.([]models.Withdrawal)
+	query, paged, err := parsePageQuery(r, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if paged {
+		h.getWithdrawalsPage(w, r, userID, query)
+		return
+	}
+
+	withdrawals, err := h.storage.GetWithdrawals(h.ctx, userID)
 
 	switch {
 	case errors.Is(err, sql.ErrNoRows):
@@ -127,7 +140,7 @@ func (h *handlersData) GetWithdrawals(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusNoContent)
 		return
 
-	case err != nil || !ok:
+	case err != nil:
 		h.logger.Errorf("Ошибка запроса к базе: %w", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -144,3 +157,60 @@ func (h *handlersData) GetWithdrawals(w http.ResponseWriter, r *http.Request) {
 	}
 
 }
+
+// getWithdrawalsPage - GetWithdrawals с параметрами выдачи, см. getOrdersPage.
+func (h *handlersData) getWithdrawalsPage(w http.ResponseWriter, r *http.Request, userID string, query models.PageQuery) {
+
+	page, err := h.storage.GetWithdrawalsPage(h.ctx, userID, query)
+
+	switch {
+	case errors.Is(err, db.ErrInvalidCursor):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		h.logger.Errorf("Ошибка запроса к базе: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(page.Withdrawals) == 0 {
+		h.logger.Info("нет данных о выводе средств")
+		setResponseHeaders(w, ApplicationJSON, http.StatusNoContent)
+		return
+	}
+	setNextPageHeaders(w, r, page.NextCursor)
+	setResponseHeaders(w, ApplicationJSON, http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(page.Withdrawals); err != nil {
+		h.logger.Errorf("Ошибка маршалинга: %w", err)
+	}
+}
+
+// GetBalanceHistory - все движения баллов пользователя: начисления, списания,
+// ручные корректировки и возвраты с кодом причины.
+func (h *handlersData) GetBalanceHistory(w http.ResponseWriter, r *http.Request) {
+
+	// 200 — движения баллов;
+	// 204 — движений нет;
+	// 401 — пользователь не авторизован;
+	// 500 — внутренняя ошибка сервера.
+
+	userID, ok := r.Context().Value(userIDKey).(string)
+	if !ok {
+		h.logger.Errorf("путой юзер детектед")
+		http.Error(w, "wrong user id", http.StatusUnauthorized)
+		return
+	}
+
+	entries, err := h.storage.GetLedger(h.ctx, userID)
+	if err != nil {
+		h.logger.Errorf("Ошибка запроса к базе: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(entries) == 0 {
+		setResponseHeaders(w, ApplicationJSON, http.StatusNoContent)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, entries)
+}
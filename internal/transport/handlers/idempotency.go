@@ -0,0 +1,124 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const maxIdempotencyKeyLength = 255
+
+// responseRecorder запоминает ответ хендлера, чтобы сохранить его под Idempotency-Key.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	if r.statusCode == 0 {
+		r.statusCode = statusCode
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.statusCode == 0 {
+		r.statusCode = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// IdempotencyMiddleware - повторный запрос с тем же Idempotency-Key получает сохраненный ответ,
+// а не выполняется заново. Тот же ключ с другим телом запроса - 422, запрос с ключом,
+// который еще выполняется, - 409. Ставится после AuthMiddleware: ключи хранятся по пользователю.
+func (h *handlersData) IdempotencyMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			http.Error(w, "слишком длинный Idempotency-Key", http.StatusBadRequest)
+			return
+		}
+
+		userID, ok := r.Context().Value(userIDKey).(string)
+		if !ok {
+			h.logger.Errorf("путой юзер детектед")
+			http.Error(w, "wrong user id", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Error reading request body", http.StatusInternalServerError)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+		hash.Write(body)
+		requestHash := hex.EncodeToString(hash.Sum(nil))
+
+		record, err := h.storage.ReserveIdempotencyKey(h.ctx, userID, key, requestHash)
+		if err != nil {
+			h.logger.Errorf("ошибка получения Idempotency-Key %s: %w", key, err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		switch {
+		case record.RequestHash != requestHash:
+			h.logger.Infof("Idempotency-Key %s пользователя %s повторно использован с другим запросом", key, userID)
+			http.Error(w, "Idempotency-Key уже использован с другим запросом", http.StatusUnprocessableEntity)
+			return
+
+		case !record.Reserved && !record.Completed():
+			http.Error(w, "запрос с этим Idempotency-Key еще выполняется", http.StatusConflict)
+			return
+
+		case !record.Reserved:
+			h.logger.Infof("повтор запроса по Idempotency-Key %s пользователя %s", key, userID)
+			if record.ContentType != "" {
+				w.Header().Set("Content-Type", record.ContentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(record.StatusCode)
+			w.Write(record.Body)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.statusCode == 0 {
+			recorder.statusCode = http.StatusOK
+		}
+
+		// внутренние ошибки не запоминаем, чтобы клиент мог повторить запрос; 403 и 429 тоже:
+		// после ввода кода 2FA или окончания блокировки повтор с тем же ключом должен выполниться
+		if recorder.statusCode >= http.StatusInternalServerError ||
+			recorder.statusCode == http.StatusForbidden || recorder.statusCode == http.StatusTooManyRequests {
+			if err := h.storage.DeleteIdempotencyKey(h.ctx, userID, key); err != nil {
+				h.logger.Errorf("ошибка освобождения Idempotency-Key %s: %w", key, err)
+			}
+			return
+		}
+
+		record.StatusCode = recorder.statusCode
+		record.ContentType = w.Header().Get("Content-Type")
+		record.Body = recorder.body.Bytes()
+		if err := h.storage.SaveIdempotentResponse(h.ctx, record); err != nil {
+			h.logger.Errorf("ошибка сохранения ответа по Idempotency-Key %s: %w", key, err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package transport
+
+import (
+	db "gophermart/internal/database"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// errBadCredentials - один ответ для неизвестного логина и неверного пароля,
+// чтобы по ответу нельзя было узнать, есть ли аккаунт.
+const errBadCredentials = "неверный логин или пароль"
+
+func loginAttemptKey(login string) string {
+	return "login:" + login
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// clientIP - адрес клиента. X-Forwarded-For и X-Real-IP подделываются клиентом,
+// поэтому учитываются, только если сервер стоит за доверенным прокси.
+func (h *handlersData) clientIP(r *http.Request) string {
+
+	if h.TrustProxyHeaders {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			return strings.TrimSpace(strings.Split(xff, ",")[0])
+		}
+		if ip := r.Header.Get("X-Real-IP"); ip != "" {
+			return strings.TrimSpace(ip)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loginLocked отвечает 429, если вход по логину или с адреса клиента временно заблокирован.
+// Блокировка ведется и для несуществующих логинов, поэтому тоже не выдает наличие аккаунта.
+func (h *handlersData) loginLocked(w http.ResponseWriter, login, ip string) bool {
+
+	locked, err := h.storage.LoginLockedFor(h.ctx, loginAttemptKey(login), ipAttemptKey(ip))
+	if err != nil {
+		h.logger.Errorf("ошибка проверки блокировки входа: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	if locked <= 0 {
+		return false
+	}
+
+	h.logger.Infof("вход %s с адреса %s заблокирован еще на %s", login, ip, locked)
+	tooManyAttempts(w, locked)
+	return true
+}
+
+// loginFailed учитывает неудачный вход по логину и адресу клиента и отвечает 401.
+func (h *handlersData) loginFailed(w http.ResponseWriter, login, ip string) {
+	h.registerLoginFailure(login, ip)
+	http.Error(w, errBadCredentials, http.StatusUnauthorized)
+}
+
+// registerLoginFailure учитывает неверный пароль: при входе и при проверке старого пароля.
+func (h *handlersData) registerLoginFailure(login, ip string) {
+
+	attempts := []struct {
+		key    string
+		policy db.LockoutPolicy
+	}{
+		{loginAttemptKey(login), h.LoginPolicy},
+		{ipAttemptKey(ip), h.IPLoginPolicy},
+	}
+
+	var lock time.Duration
+	for _, a := range attempts {
+		d, err := h.storage.RegisterLoginFailure(h.ctx, a.key, a.policy)
+		if err != nil {
+			h.logger.Errorf("ошибка учета неудачного входа %s: %w", a.key, err)
+			continue
+		}
+		if d > lock {
+			lock = d
+		}
+	}
+	if lock > 0 {
+		h.logger.Infof("вход %s с адреса %s заблокирован на %s", login, ip, lock)
+	}
+}
+
+// dummyHash - хеш для проверки пароля неизвестного логина: ответ занимает столько же времени,
+// сколько для существующего, и время ответа не выдает наличие аккаунта.
+func (h *handlersData) dummyHash() string {
+
+	h.dummy.once.Do(func() {
+		hash, err := h.Hasher.Hash("gophermart-dummy-password")
+		if err != nil {
+			h.logger.Errorf("ошибка хеширования пароля %w", err)
+		}
+		h.dummy.hash = hash
+	})
+	return h.dummy.hash
+}
+
+func tooManyAttempts(w http.ResponseWriter, locked time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.Seconds()))))
+	http.Error(w, "слишком много попыток входа, повторите позже", http.StatusTooManyRequests)
+}
+
+// UnlockLogin снимает блокировку входа с логина, а с параметром ip - и с адреса.
+func (h *handlersData) UnlockLogin(w http.ResponseWriter, r *http.Request) {
+
+	// 204 — блокировка снята или ее не было;
+	// 500 — внутренняя ошибка сервера.
+
+	keys := []string{loginAttemptKey(chi.URLParam(r, "login"))}
+	if ip := r.URL.Query().Get("ip"); ip != "" {
+		keys = append(keys, ipAttemptKey(ip))
+	}
+
+	for _, key := range keys {
+		if err := h.storage.ResetLoginFailures(h.ctx, key); err != nil {
+			h.logger.Errorf("ошибка снятия блокировки %s: %w", key, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	h.logger.Infof("%s снял блокировку входа %v", operator(r), keys)
+	setResponseHeaders(w, ApplicationJSON, http.StatusNoContent)
+}
@@ -2,12 +2,18 @@ package transport
 
 import (
 	"context"
+	"gophermart/internal/models"
+	jwtpackage "gophermart/pkg/jwt"
 	"net/http"
 )
 
 type key string
 
-const userIDKey key = "userID"
+const (
+	userIDKey key = "userID"
+	// claimsKey - claims проверенного токена, нужны для выхода
+	claimsKey key = "claims"
+)
 
 func (h *handlersData) AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -18,16 +24,59 @@ func (h *handlersData) AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			http.Error(w, "остутствует токен авторизации", http.StatusUnauthorized)
 			return
 		}
-		user, err := h.AuthToken.GetUserID(authHeader)
+		claims, err := h.AuthToken.ParseClaims(authHeader)
 		if err != nil {
 			h.logger.Errorf("ошибка проверки токена: %w", err)
 			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
 
+		// токен мог быть отозван выходом до истечения срока
+		revoked, err := h.storage.IsTokenRevoked(h.ctx, claims.ID, claims.SessionID)
+		if err != nil {
+			h.logger.Errorf("ошибка проверки отзыва токена: %w", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if revoked {
+			h.logger.Infof("отозванный токен пользователя %s", claims.UserID)
+			http.Error(w, "token revoked", http.StatusUnauthorized)
+			return
+		}
+
 		// #ВОПРОСМЕНТОРУ  получаем юзера, и передаем его дальше через контекст. Не знаю хороший ли способ. Возможно есть более предпочтительный?
-		ctx := context.WithValue(r.Context(), userIDKey, user)
+		ctx := context.WithValue(r.Context(), userIDKey, claims.UserID)
+		ctx = context.WithValue(ctx, claimsKey, claims)
 		w.Header().Set("Content-Type", ApplicationJSON)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
+
+// RequireRole пропускает запрос, только если роль из токена входит в roles.
+// Ставится после AuthMiddleware; токен без роли - обычный пользователь.
+func (h *handlersData) RequireRole(roles ...string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+
+			claims, ok := r.Context().Value(claimsKey).(*jwtpackage.Claims)
+			if !ok {
+				h.logger.Errorf("путой юзер детектед")
+				http.Error(w, "wrong user id", http.StatusUnauthorized)
+				return
+			}
+			role := claims.Role
+			if role == "" {
+				role = models.RoleUser
+			}
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			h.logger.Infof("пользователю %s с ролью %s запрещен %s %s", claims.UserID, role, r.Method, r.URL.Path)
+			http.Error(w, "forbidden", http.StatusForbidden)
+		}
+	}
+}
@@ -7,12 +7,15 @@ import (
 	"errors"
 	db "gophermart/internal/database"
 	"gophermart/internal/models"
+	"gophermart/internal/services"
 	jwtpackage "gophermart/pkg/jwt"
 	"gophermart/utils"
 	"io"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/go-chi/chi"
 	"go.uber.org/zap"
 )
 
@@ -27,6 +30,34 @@ type handlersData struct {
 	TokenExp time.Duration
 	// навверное AuthToken можно(нужно) сделать через интерфейс
 	AuthToken jwtpackage.Token
+	Hasher    services.PasswordHasher
+	// Events будит потоки StreamOrders, nil - поток недоступен
+	Events            *services.OrderEventBus
+	HeartbeatInterval time.Duration
+	// RefreshTokenExp - срок refresh-токена, access-токен живет AuthToken.TokenExp
+	RefreshTokenExp time.Duration
+	// защита входа от перебора паролей по логину и по адресу клиента
+	LoginPolicy       db.LockoutPolicy
+	IPLoginPolicy     db.LockoutPolicy
+	TrustProxyHeaders bool
+	// PasswordPolicy проверяет новые пароли, Notifier доставляет токены сброса пароля
+	PasswordPolicy        *services.PasswordPolicy
+	Notifier              services.Notifier
+	PasswordResetTokenTTL time.Duration
+	// TOTPBox шифрует секреты TOTP в базе, nil - 2FA недоступна
+	TOTPBox           *services.SecretBox
+	TOTPIssuer        string
+	MFAChallengeTTL   time.Duration
+	MFAMaxAttempts    int
+	RecoveryCodeCount int
+	// WebhookResolver разрешает хосты webhook'ов при регистрации, nil - net.DefaultResolver
+	WebhookResolver services.WebhookResolver
+
+	// dummy - хеш для проверки пароля неизвестного логина, см. dummyHash
+	dummy struct {
+		once sync.Once
+		hash string
+	}
 }
 
 type authData struct {
@@ -39,6 +70,13 @@ func New(ctx context.Context, storage db.StoragerDB, logger *zap.SugaredLogger)
 		ctx:     ctx,
 		storage: storage,
 		logger:  logger,
+		Hasher:  services.NewArgon2idHasher(),
+
+		LoginPolicy:    db.DefaultLoginPolicy(),
+		IPLoginPolicy:  db.DefaultIPLoginPolicy(),
+		PasswordPolicy: services.DefaultPasswordPolicy(),
+		Notifier:       services.NewLogNotifier(logger),
+		TOTPIssuer:     DefaultTOTPIssuer,
 	}
 }
 
@@ -69,8 +107,7 @@ func (h *handlersData) UploadOrders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	orderUserIDInterface, err := h.storage.WithRetry(h.ctx, h.storage.AddOrder(h.ctx, ordersNumber, userID))
-	orderUserID, _ := orderUserIDInterface.(models.OrderUserID)
+	orderUserID, err := h.storage.AddOrder(h.ctx, ordersNumber, userID)
 
 	if err != nil {
 
@@ -109,9 +146,17 @@ func (h *handlersData) GetUploadedOrders(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	ordersInterface, err := h.storage.WithRetry(h.ctx, h.storage.GetOrders(h.ctx, userID))
+	query, paged, err := parsePageQuery(r, true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if paged {
+		h.getOrdersPage(w, r, userID, query)
+		return
+	}
 
-	orders, ok := ordersInterface.([]models.OrderStatus)
+	orders, err := h.storage.GetOrders(h.ctx, userID)
 
 	switch {
 	case errors.Is(err, sql.ErrNoRows):
@@ -120,7 +165,7 @@ func (h *handlersData) GetUploadedOrders(w http.ResponseWriter, r *http.Request)
 		http.Error(w, err.Error(), http.StatusNoContent)
 		return
 
-	case err != nil || !ok:
+	case err != nil:
 		h.logger.Errorf("Ошибка запроса к базе: %w", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -144,3 +189,66 @@ func (h *handlersData) GetUploadedOrders(w http.ResponseWriter, r *http.Request)
 	}
 
 }
+
+// GetOrder - заказ пользователя с историей статусов.
+// 404 - заказа нет, 403 - заказ загружен другим пользователем.
+func (h *handlersData) GetOrder(w http.ResponseWriter, r *http.Request) {
+
+	userID, ok := r.Context().Value(userIDKey).(string)
+	if !ok {
+		h.logger.Errorf("путой юзер детектед")
+		http.Error(w, "wrong user id", http.StatusUnauthorized)
+		return
+	}
+
+	number := chi.URLParam(r, "number")
+	details, err := h.storage.GetOrder(h.ctx, number)
+
+	switch {
+	case errors.Is(err, db.ErrOrderNotFound):
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	case err != nil:
+		h.logger.Errorf("Ошибка запроса к базе: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	case details.UserID != userID:
+		h.logger.Infof("пользователь %s запросил чужой заказ %s", userID, number)
+		http.Error(w, "order belongs to another user", http.StatusForbidden)
+		return
+	}
+
+	setResponseHeaders(w, ApplicationJSON, http.StatusOK)
+	if err := json.NewEncoder(w).Encode(details); err != nil {
+		h.logger.Errorf("Ошибка маршалинга: %w", err)
+	}
+}
+
+// getOrdersPage - GetUploadedOrders с параметрами выдачи: тело - такой же массив заказов,
+// ссылка на следующую страницу - в заголовках Link и X-Next-Cursor.
+func (h *handlersData) getOrdersPage(w http.ResponseWriter, r *http.Request, userID string, query models.PageQuery) {
+
+	page, err := h.storage.GetOrdersPage(h.ctx, userID, query)
+
+	switch {
+	case errors.Is(err, db.ErrInvalidCursor):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		h.logger.Errorf("Ошибка запроса к базе: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(page.Orders) == 0 {
+		h.logger.Info("нет данных о заказах")
+		setResponseHeaders(w, ApplicationJSON, http.StatusNoContent)
+		return
+	}
+	setNextPageHeaders(w, r, page.NextCursor)
+	setResponseHeaders(w, ApplicationJSON, http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(page.Orders); err != nil {
+		h.logger.Errorf("Ошибка маршалинга: %w", err)
+	}
+}
@@ -0,0 +1,113 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"gophermart/internal/models"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Параметры постраничной выдачи списков пользователя.
+const (
+	pageParamLimit  = "limit"
+	pageParamCursor = "cursor"
+	pageParamStatus = "status"
+	pageParamFrom   = "from"
+	pageParamTo     = "to"
+	pageParamOrder  = "order"
+
+	// NextCursorHeader - курсор следующей страницы, дублирует ссылку rel="next" из Link.
+	NextCursorHeader = "X-Next-Cursor"
+)
+
+var pageParams = []string{pageParamLimit, pageParamCursor, pageParamStatus, pageParamFrom, pageParamTo, pageParamOrder}
+
+var errPageQuery = errors.New("wrong page query")
+
+// parsePageQuery разбирает параметры выдачи. paged=false - параметров нет,
+// отвечаем полным списком, как до появления постраничной выдачи.
+// status принимается через запятую или повторением параметра, если withStatus.
+func parsePageQuery(r *http.Request, withStatus bool) (query models.PageQuery, paged bool, err error) {
+
+	values := r.URL.Query()
+	for _, name := range pageParams {
+		if values.Has(name) {
+			paged = true
+		}
+	}
+	if !paged {
+		return query, false, nil
+	}
+
+	if limit := values.Get(pageParamLimit); limit != "" {
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit <= 0 || query.Limit > models.MaxPageLimit {
+			return query, true, fmt.Errorf("%w: limit должен быть от 1 до %d", errPageQuery, models.MaxPageLimit)
+		}
+	}
+	query.Cursor = values.Get(pageParamCursor)
+
+	for _, value := range values[pageParamStatus] {
+		if !withStatus {
+			return query, true, fmt.Errorf("%w: фильтр по статусу не поддерживается", errPageQuery)
+		}
+		for _, status := range strings.Split(value, ",") {
+			status = strings.ToUpper(strings.TrimSpace(status))
+			if models.StatusRank(status) < 0 {
+				return query, true, fmt.Errorf("%w: неизвестный статус %q", errPageQuery, status)
+			}
+			query.Statuses = append(query.Statuses, status)
+		}
+	}
+
+	if query.From, err = parsePageTime(values.Get(pageParamFrom)); err != nil {
+		return query, true, err
+	}
+	if query.To, err = parsePageTime(values.Get(pageParamTo)); err != nil {
+		return query, true, err
+	}
+	if !query.From.IsZero() && !query.To.IsZero() && !query.From.Before(query.To) {
+		return query, true, fmt.Errorf("%w: from должен быть раньше to", errPageQuery)
+	}
+
+	switch values.Get(pageParamOrder) {
+	case "", "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return query, true, fmt.Errorf("%w: order может быть asc или desc", errPageQuery)
+	}
+
+	return query, true, nil
+}
+
+func parsePageTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: время в формате RFC3339 %w", errPageQuery, err)
+	}
+	return t, nil
+}
+
+// setNextPageHeaders добавляет ссылку на следующую страницу: тот же запрос с новым cursor.
+func setNextPageHeaders(w http.ResponseWriter, r *http.Request, nextCursor string) {
+
+	if nextCursor == "" {
+		return
+	}
+
+	next := url.URL{Path: r.URL.Path}
+	values := r.URL.Query()
+	values.Set(pageParamCursor, nextCursor)
+	next.RawQuery = values.Encode()
+
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.String()))
+	w.Header().Set(NextCursorHeader, nextCursor)
+}
@@ -0,0 +1,219 @@
+package transport
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	db "gophermart/internal/database"
+	"gophermart/internal/services"
+	jwtpackage "gophermart/pkg/jwt"
+	"net/http"
+	"time"
+)
+
+// DefaultPasswordResetTokenTTL - срок токена сброса пароля, если PasswordResetTokenTTL не задан.
+const DefaultPasswordResetTokenTTL = 30 * time.Minute
+
+type changePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type resetPasswordRequest struct {
+	Login string `json:"login"`
+}
+
+type confirmResetRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// ChangePassword меняет пароль по старому паролю и отзывает все сессии, кроме текущей.
+func (h *handlersData) ChangePassword(w http.ResponseWriter, r *http.Request) {
+
+	// 204 — пароль изменен;
+	// 400 — неверный формат запроса или пароль не соответствует политике;
+	// 401 — пользователь не аутентифицирован;
+	// 403 — неверный старый пароль;
+	// 429 — слишком много неверных паролей, вход временно заблокирован;
+	// 500 — внутренняя ошибка сервера.
+
+	claims, ok := r.Context().Value(claimsKey).(*jwtpackage.Claims)
+	if !ok {
+		h.logger.Errorf("путой юзер детектед")
+		http.Error(w, "wrong user id", http.StatusUnauthorized)
+		return
+	}
+
+	var data changePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if data.OldPassword == "" || data.NewPassword == "" {
+		http.Error(w, "old_password and new_password required", http.StatusBadRequest)
+		return
+	}
+
+	// подбор старого пароля с украденным токеном ограничен так же, как подбор при входе
+	ip := h.clientIP(r)
+	if h.loginLocked(w, claims.UserID, ip) {
+		return
+	}
+
+	user, err := h.storage.GetUser(h.ctx, claims.UserID)
+	if err != nil {
+		h.logger.Errorf("Ошибка при получении пользователя %s: %w", claims.UserID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	valid, _, err := services.VerifyPassword(h.Hasher, claims.UserID, data.OldPassword, user.Hash)
+	if err != nil {
+		h.logger.Errorf("ошибка проверки пароля пользователя %s: %w", claims.UserID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		h.logger.Infof("неверный старый пароль пользователя %s", claims.UserID)
+		h.registerLoginFailure(claims.UserID, ip)
+		http.Error(w, "неверный пароль", http.StatusForbidden)
+		return
+	}
+
+	if err := h.PasswordPolicy.Validate(claims.UserID, data.NewPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hash, err := h.Hasher.Hash(data.NewPassword)
+	if err != nil {
+		h.logger.Errorf("ошибка хеширования пароля %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	n, err := h.storage.ChangePassword(h.ctx, claims.UserID, hash, claims.SessionID)
+	if err != nil {
+		h.logger.Errorf("ошибка смены пароля пользователя %s: %w", claims.UserID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Infof("пользователь %s сменил пароль, отозвано сессий: %d", claims.UserID, n)
+	setResponseHeaders(w, ApplicationJSON, http.StatusNoContent)
+}
+
+// RequestPasswordReset отправляет пользователю одноразовый токен сброса пароля.
+// Ответ одинаков для существующего и неизвестного логина.
+func (h *handlersData) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+
+	// 202 — запрос принят, токен отправлен, если логин существует;
+	// 400 — неверный формат запроса;
+	// 500 — внутренняя ошибка сервера.
+
+	var data resetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if data.Login == "" {
+		http.Error(w, "login required", http.StatusBadRequest)
+		return
+	}
+
+	_, err := h.storage.GetUser(h.ctx, data.Login)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		h.logger.Infof("сброс пароля для неизвестного логина %s", data.Login)
+		setResponseHeaders(w, ApplicationJSON, http.StatusAccepted)
+		return
+	case err != nil:
+		h.logger.Errorf("Ошибка при получении пользователя %s: %w", data.Login, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	token, tokenHash, err := services.NewResetToken()
+	if err != nil {
+		h.logger.Errorf("ошибка создания токена сброса: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ttl := h.passwordResetTokenTTL()
+	if err := h.storage.AddPasswordResetToken(h.ctx, data.Login, tokenHash, ttl); err != nil {
+		h.logger.Errorf("ошибка сохранения токена сброса: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// ошибку доставки клиенту не показываем: по ней можно было бы понять, что логин существует
+	err = h.Notifier.Notify(h.ctx, services.Notification{
+		To:      data.Login,
+		Subject: "Сброс пароля",
+		Text: fmt.Sprintf("Токен для сброса пароля: %s\nДействует %s. Если вы не запрашивали сброс, ничего не делайте.",
+			token, ttl),
+	})
+	if err != nil {
+		h.logger.Errorf("ошибка отправки токена сброса пользователю %s: %w", data.Login, err)
+	}
+
+	h.logger.Infof("пользователь %s запросил сброс пароля", data.Login)
+	setResponseHeaders(w, ApplicationJSON, http.StatusAccepted)
+}
+
+// ConfirmPasswordReset устанавливает новый пароль по токену сброса, отзывает все сессии
+// пользователя и снимает блокировку входа.
+func (h *handlersData) ConfirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+
+	// 204 — пароль изменен;
+	// 400 — неверный формат запроса или пароль не соответствует политике;
+	// 401 — токен неизвестен, истек или уже использован;
+	// 500 — внутренняя ошибка сервера.
+
+	var data confirmResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if data.Token == "" || data.NewPassword == "" {
+		http.Error(w, "token and new_password required", http.StatusBadRequest)
+		return
+	}
+
+	// логин станет известен только по токену, поэтому совпадение пароля с логином здесь не проверяется
+	if err := h.PasswordPolicy.Validate("", data.NewPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hash, err := h.Hasher.Hash(data.NewPassword)
+	if err != nil {
+		h.logger.Errorf("ошибка хеширования пароля %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	login, err := h.storage.ResetPassword(h.ctx, services.HashRefreshToken(data.Token), hash)
+	switch {
+	case errors.Is(err, db.ErrInvalidResetToken):
+		http.Error(w, "invalid reset token", http.StatusUnauthorized)
+		return
+	case err != nil:
+		h.logger.Errorf("ошибка сброса пароля: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.storage.ResetLoginFailures(h.ctx, loginAttemptKey(login)); err != nil {
+		h.logger.Errorf("ошибка сброса неудачных входов %s: %w", login, err)
+	}
+
+	h.logger.Infof("пользователь %s сбросил пароль", login)
+	setResponseHeaders(w, ApplicationJSON, http.StatusNoContent)
+}
+
+func (h *handlersData) passwordResetTokenTTL() time.Duration {
+	if h.PasswordResetTokenTTL <= 0 {
+		return DefaultPasswordResetTokenTTL
+	}
+	return h.PasswordResetTokenTTL
+}
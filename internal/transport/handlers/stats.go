@@ -0,0 +1,20 @@
+package transport
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// GetDBStats отдает состояние пула соединений с БД для мониторинга.
+func (h *handlersData) GetDBStats(w http.ResponseWriter, r *http.Request) {
+
+	jsonData, err := json.Marshal(h.storage.Stats())
+	if err != nil {
+		h.logger.Errorf("ошибка маршалинга: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setResponseHeaders(w, ApplicationJSON, http.StatusOK)
+	w.Write(jsonData)
+}
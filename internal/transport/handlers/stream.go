@@ -0,0 +1,155 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	jwtpackage "gophermart/pkg/jwt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultHeartbeatInterval - как часто поток шлет комментарий, чтобы прокси не закрыли соединение.
+	DefaultHeartbeatInterval = 15 * time.Second
+	// orderEventsBatch - сколько событий читается из хранилища за раз.
+	orderEventsBatch = 100
+	// streamRetry - через сколько миллисекунд браузер переподключается к потоку.
+	streamRetry = 3000
+)
+
+// StreamOrders - поток изменений статусов заказов пользователя (Server-Sent Events).
+// Каждое событие - "status" с id из хранилища. При переподключении клиент передает
+// Last-Event-ID и получает пропущенные события; без него поток начинается с текущего момента.
+// Поток закрывается, когда истекает access-токен или он отозван (выход, блокировка,
+// смена роли): клиент переподключится с новым токеном.
+func (h *handlersData) StreamOrders(w http.ResponseWriter, r *http.Request) {
+
+	userID, ok := r.Context().Value(userIDKey).(string)
+	if !ok {
+		h.logger.Errorf("путой юзер детектед")
+		http.Error(w, "wrong user id", http.StatusUnauthorized)
+		return
+	}
+	claims, ok := r.Context().Value(claimsKey).(*jwtpackage.Claims)
+	if !ok {
+		h.logger.Errorf("путой юзер детектед")
+		http.Error(w, "wrong user id", http.StatusUnauthorized)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok || h.Events == nil {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// соединение живет долго, поэтому запросы к хранилищу привязаны к запросу клиента
+	ctx := r.Context()
+
+	// подписываемся до чтения событий, чтобы не пропустить записанные между ними
+	wakeup, unsubscribe := h.Events.Subscribe(userID)
+	defer unsubscribe()
+
+	var lastID int64
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		id, err := strconv.ParseInt(header, 10, 64)
+		if err != nil || id < 0 {
+			http.Error(w, "wrong Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		lastID = id
+	} else {
+		id, err := h.storage.LastOrderEventID(ctx, userID)
+		if err != nil {
+			h.logger.Errorf("Ошибка запроса к базе: %w", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		lastID = id
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	setResponseHeaders(w, "text/event-stream", http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry)
+	flusher.Flush()
+
+	heartbeat := h.HeartbeatInterval
+	if heartbeat <= 0 {
+		heartbeat = DefaultHeartbeatInterval
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	var expired <-chan time.Time
+	if claims.ExpiresAt != nil {
+		timer := time.NewTimer(time.Until(claims.ExpiresAt.Time))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	send := func() error {
+		for {
+			events, err := h.storage.GetOrderEvents(ctx, userID, lastID, orderEventsBatch)
+			if err != nil {
+				return err
+			}
+			for _, e := range events {
+				data, err := json.Marshal(e)
+				if err != nil {
+					return err
+				}
+				if _, err := fmt.Fprintf(w, "id: %d\nevent: status\ndata: %s\n\n", e.ID, data); err != nil {
+					return err
+				}
+				lastID = e.ID
+			}
+			flusher.Flush()
+			if len(events) < orderEventsBatch {
+				return nil
+			}
+		}
+	}
+
+	if err := send(); err != nil {
+		h.logger.Errorf("ошибка отправки событий заказов: %w", err)
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.ctx.Done():
+			return
+		case <-expired:
+			h.logger.Infof("токен пользователя %s истек, поток событий закрыт", userID)
+			return
+		case <-wakeup:
+		case <-ticker.C:
+			revoked, err := h.storage.IsTokenRevoked(ctx, claims.ID, claims.SessionID)
+			if err != nil {
+				if ctx.Err() == nil {
+					h.logger.Errorf("ошибка проверки отзыва токена: %w", err)
+				}
+				return
+			}
+			if revoked {
+				h.logger.Infof("токен пользователя %s отозван, поток событий закрыт", userID)
+				return
+			}
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		// по heartbeat тоже читаем события: сигнал мог потеряться, пока шина переподключалась к хранилищу
+		if err := send(); err != nil {
+			if ctx.Err() == nil {
+				h.logger.Errorf("ошибка отправки событий заказов: %w", err)
+			}
+			return
+		}
+	}
+}
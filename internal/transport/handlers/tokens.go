@@ -0,0 +1,197 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	db "gophermart/internal/database"
+	"gophermart/internal/models"
+	"gophermart/internal/services"
+	jwtpackage "gophermart/pkg/jwt"
+	"net/http"
+	"time"
+)
+
+// DefaultRefreshTokenExp - срок refresh-токена, если RefreshTokenExp не задан.
+const DefaultRefreshTokenExp = 30 * 24 * time.Hour
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// issueTokens открывает новую сессию пользователя и отвечает парой токенов.
+// Access-токен, как и раньше, передается в заголовке Authorization.
+func (h *handlersData) issueTokens(w http.ResponseWriter, userID, role string) {
+
+	sessionID, err := services.NewSessionID()
+	if err != nil {
+		h.logger.Errorf("ошибка создания сессии: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	refreshToken, refreshHash, err := services.NewRefreshToken()
+	if err != nil {
+		h.logger.Errorf("ошибка создания refresh-токена: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.storage.AddSession(h.ctx, userID, sessionID, refreshHash, h.refreshTokenExp()); err != nil {
+		h.logger.Errorf("ошибка сохранения сессии: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeTokens(w, models.Session{ID: sessionID, UserID: userID, Role: role}, refreshToken)
+}
+
+func (h *handlersData) writeTokens(w http.ResponseWriter, session models.Session, refreshToken string) {
+
+	jwtString, err := h.AuthToken.BuildRoleJWT(session.UserID, session.ID, session.Role)
+	if err != nil {
+		h.logger.Errorf("ошибка создания токена: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Authorization", jwtString)
+	setResponseHeaders(w, ApplicationJSON, http.StatusOK)
+
+	tokens := models.TokenPair{
+		AccessToken:  jwtString,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(h.AuthToken.TokenExp.Seconds()),
+	}
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
+		h.logger.Errorf("Ошибка маршалинга: %w", err)
+	}
+}
+
+func (h *handlersData) refreshTokenExp() time.Duration {
+	if h.RefreshTokenExp <= 0 {
+		return DefaultRefreshTokenExp
+	}
+	return h.RefreshTokenExp
+}
+
+// RefreshToken меняет refresh-токен на новую пару токенов той же сессии.
+// Каждый refresh-токен действует один раз: повторное предъявление отзывает сессию.
+func (h *handlersData) RefreshToken(w http.ResponseWriter, r *http.Request) {
+
+	// 200 — новая пара токенов;
+	// 400 — неверный формат запроса;
+	// 401 — токен недействителен, истек, отозван или уже использован;
+	// 500 — внутренняя ошибка сервера.
+
+	var data refreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if data.RefreshToken == "" {
+		http.Error(w, "refresh token required", http.StatusBadRequest)
+		return
+	}
+
+	refreshToken, refreshHash, err := services.NewRefreshToken()
+	if err != nil {
+		h.logger.Errorf("ошибка создания refresh-токена: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	session, err := h.storage.RotateRefreshToken(h.ctx, services.HashRefreshToken(data.RefreshToken), refreshHash, h.refreshTokenExp())
+	switch {
+	case errors.Is(err, db.ErrRefreshTokenReused):
+		h.logger.Infof("повторное использование refresh-токена, сессия отозвана")
+		http.Error(w, "invalid refresh token", http.StatusUnauthorized)
+		return
+	case errors.Is(err, db.ErrInvalidRefreshToken):
+		http.Error(w, "invalid refresh token", http.StatusUnauthorized)
+		return
+	case err != nil:
+		h.logger.Errorf("Ошибка запроса к базе: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeTokens(w, session, refreshToken)
+}
+
+// Logout отзывает текущий access-токен и его сессию вместе с refresh-токенами.
+func (h *handlersData) Logout(w http.ResponseWriter, r *http.Request) {
+
+	claims, ok := r.Context().Value(claimsKey).(*jwtpackage.Claims)
+	if !ok {
+		h.logger.Errorf("путой юзер детектед")
+		http.Error(w, "wrong user id", http.StatusUnauthorized)
+		return
+	}
+
+	if !h.revokeToken(w, claims) {
+		return
+	}
+	if claims.SessionID != "" {
+		if err := h.storage.RevokeSession(h.ctx, claims.UserID, claims.SessionID); err != nil {
+			h.logger.Errorf("ошибка отзыва сессии: %w", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	h.logger.Infof("пользователь %s вышел", claims.UserID)
+	setResponseHeaders(w, ApplicationJSON, http.StatusNoContent)
+}
+
+// LogoutAll отзывает все сессии пользователя, в том числе текущую.
+func (h *handlersData) LogoutAll(w http.ResponseWriter, r *http.Request) {
+
+	claims, ok := r.Context().Value(claimsKey).(*jwtpackage.Claims)
+	if !ok {
+		h.logger.Errorf("путой юзер детектед")
+		http.Error(w, "wrong user id", http.StatusUnauthorized)
+		return
+	}
+
+	if !h.revokeToken(w, claims) {
+		return
+	}
+	n, err := h.storage.RevokeAllSessions(h.ctx, claims.UserID)
+	if err != nil {
+		h.logger.Errorf("ошибка отзыва сессий: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Infof("пользователь %s вышел из всех сессий (%d)", claims.UserID, n)
+	setResponseHeaders(w, ApplicationJSON, http.StatusNoContent)
+}
+
+// revokeToken отзывает access-токен до истечения его срока. false - ответ уже отправлен.
+func (h *handlersData) revokeToken(w http.ResponseWriter, claims *jwtpackage.Claims) bool {
+
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return true
+	}
+	if err := h.storage.RevokeToken(h.ctx, claims.ID, time.Until(claims.ExpiresAt.Time)); err != nil {
+		h.logger.Errorf("ошибка отзыва токена: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// GetJWKS отдает открытые ключи, которыми другие сервисы проверяют токены без общего секрета.
+func (h *handlersData) GetJWKS(w http.ResponseWriter, r *http.Request) {
+
+	jsonData, err := json.Marshal(h.AuthToken.JWKS())
+	if err != nil {
+		h.logger.Errorf("ошибка маршалинга: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// набор меняется только при ротации, клиенты могут его кешировать
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	setResponseHeaders(w, ApplicationJSON, http.StatusOK)
+	w.Write(jsonData)
+}
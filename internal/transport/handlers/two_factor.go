@@ -0,0 +1,524 @@
+package transport
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	db "gophermart/internal/database"
+	"gophermart/internal/models"
+	"gophermart/internal/services"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// OTPCodeHeader - код TOTP или код восстановления для списания, если пользователь включил требование 2FA.
+	OTPCodeHeader = "X-OTP-Code"
+
+	DefaultTOTPIssuer        = "Gophermart"
+	DefaultMFAChallengeTTL   = 5 * time.Minute
+	DefaultMFAMaxAttempts    = 5
+	DefaultRecoveryCodeCount = 10
+)
+
+type codeRequest struct {
+	Code string `json:"code"`
+}
+
+type withdrawalTwoFactorRequest struct {
+	Required bool   `json:"required"`
+	Code     string `json:"code"`
+}
+
+type mfaLoginRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+// EnrollTOTP выдает новый секрет TOTP. 2FA включается только после ConfirmTOTP.
+func (h *handlersData) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+
+	// 200 — секрет и otpauth:// URI;
+	// 401 — пользователь не авторизован;
+	// 409 — 2FA уже включена;
+	// 500 — внутренняя ошибка сервера.
+
+	userID, ok := r.Context().Value(userIDKey).(string)
+	if !ok {
+		h.logger.Errorf("путой юзер детектед")
+		http.Error(w, "wrong user id", http.StatusUnauthorized)
+		return
+	}
+	if h.TOTPBox == nil {
+		h.logger.Errorf("не задан ключ шифрования секретов 2FA")
+		http.Error(w, "2FA недоступна", http.StatusInternalServerError)
+		return
+	}
+
+	secret, err := services.NewTOTPSecret()
+	if err != nil {
+		h.logger.Errorf("ошибка создания секрета TOTP: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sealed, err := h.TOTPBox.Seal(secret)
+	if err != nil {
+		h.logger.Errorf("ошибка шифрования секрета TOTP: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = h.storage.StartTOTPEnrollment(h.ctx, userID, sealed)
+	switch {
+	case errors.Is(err, db.ErrTwoFactorEnabled):
+		http.Error(w, "2FA уже включена", http.StatusConflict)
+		return
+	case err != nil:
+		h.logger.Errorf("ошибка сохранения секрета TOTP: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, models.TOTPEnrollment{
+		Secret: secret,
+		URI:    services.TOTPURI(h.TOTPIssuer, userID, secret),
+	})
+}
+
+// ConfirmTOTP включает 2FA по первому коду из аутентификатора и выдает коды восстановления.
+func (h *handlersData) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+
+	// 200 — 2FA включена, коды восстановления;
+	// 400 — неверный формат запроса;
+	// 401 — пользователь не авторизован;
+	// 409 — 2FA уже включена или не начато подключение;
+	// 422 — неверный код;
+	// 500 — внутренняя ошибка сервера.
+
+	userID, ok := r.Context().Value(userIDKey).(string)
+	if !ok {
+		h.logger.Errorf("путой юзер детектед")
+		http.Error(w, "wrong user id", http.StatusUnauthorized)
+		return
+	}
+	var data codeRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tf, err := h.storage.GetTwoFactor(h.ctx, userID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows) || tf.Enabled:
+		http.Error(w, "нет неподтвержденного секрета TOTP", http.StatusConflict)
+		return
+	case err != nil:
+		h.logger.Errorf("ошибка получения настроек 2FA: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	secret, err := h.openTOTPSecret(tf)
+	if err != nil {
+		h.logger.Errorf("ошибка расшифровки секрета TOTP пользователя %s: %w", userID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	step, valid := services.ValidateTOTP(secret, strings.TrimSpace(data.Code), time.Now())
+	if !valid {
+		http.Error(w, "неверный код", http.StatusUnprocessableEntity)
+		return
+	}
+
+	codes, hashes, err := services.NewRecoveryCodes(h.recoveryCodeCount())
+	if err != nil {
+		h.logger.Errorf("ошибка создания кодов восстановления: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = h.storage.ConfirmTOTP(h.ctx, userID, step, hashes)
+	switch {
+	case errors.Is(err, db.ErrTwoFactorEnabled):
+		http.Error(w, "2FA уже включена", http.StatusConflict)
+		return
+	case err != nil:
+		h.logger.Errorf("ошибка подтверждения TOTP: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Infof("пользователь %s включил 2FA", userID)
+	h.writeJSON(w, http.StatusOK, models.RecoveryCodes{Codes: codes})
+}
+
+// DisableTwoFactor отключает 2FA по действующему коду.
+func (h *handlersData) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+
+	// 204 — 2FA отключена;
+	// 400 — неверный формат запроса;
+	// 403 — неверный код;
+	// 409 — 2FA не включена;
+	// 429 — слишком много неверных кодов;
+	// 500 — внутренняя ошибка сервера.
+
+	userID, data, ok := h.codeRequest(w, r)
+	if !ok || !h.requireCode(w, r, userID, data.Code) {
+		return
+	}
+
+	if err := h.storage.DisableTwoFactor(h.ctx, userID); err != nil {
+		h.logger.Errorf("ошибка отключения 2FA: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Infof("пользователь %s отключил 2FA", userID)
+	setResponseHeaders(w, ApplicationJSON, http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes заменяет коды восстановления новыми.
+func (h *handlersData) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+
+	// 200 — новые коды восстановления, прежние больше не действуют;
+	// коды ошибок как у DisableTwoFactor.
+
+	userID, data, ok := h.codeRequest(w, r)
+	if !ok || !h.requireCode(w, r, userID, data.Code) {
+		return
+	}
+
+	codes, hashes, err := services.NewRecoveryCodes(h.recoveryCodeCount())
+	if err != nil {
+		h.logger.Errorf("ошибка создания кодов восстановления: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.storage.ReplaceRecoveryCodes(h.ctx, userID, hashes); err != nil {
+		h.logger.Errorf("ошибка сохранения кодов восстановления: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, models.RecoveryCodes{Codes: codes})
+}
+
+// SetWithdrawalTwoFactor включает или выключает требование кода 2FA для списаний.
+// Код нужен в обоих случаях: иначе укравший пароль мог бы просто выключить требование.
+func (h *handlersData) SetWithdrawalTwoFactor(w http.ResponseWriter, r *http.Request) {
+
+	// 204 — настройка сохранена;
+	// коды ошибок как у DisableTwoFactor.
+
+	userID, ok := r.Context().Value(userIDKey).(string)
+	if !ok {
+		h.logger.Errorf("путой юзер детектед")
+		http.Error(w, "wrong user id", http.StatusUnauthorized)
+		return
+	}
+	var data withdrawalTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.requireCode(w, r, userID, data.Code) {
+		return
+	}
+
+	err := h.storage.SetWithdrawalTwoFactor(h.ctx, userID, data.Required)
+	switch {
+	case errors.Is(err, db.ErrTwoFactorNotEnabled):
+		http.Error(w, "2FA не включена", http.StatusConflict)
+		return
+	case err != nil:
+		h.logger.Errorf("ошибка сохранения настройки 2FA: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Infof("пользователь %s: код 2FA для списаний %v", userID, data.Required)
+	setResponseHeaders(w, ApplicationJSON, http.StatusNoContent)
+}
+
+// LoginSecondFactor - второй шаг входа: токен из ответа Login и код TOTP или код восстановления.
+func (h *handlersData) LoginSecondFactor(w http.ResponseWriter, r *http.Request) {
+
+	// 200 — пользователь аутентифицирован;
+	// 400 — неверный формат запроса;
+	// 401 — неверный код, токен второго шага неизвестен, истек или исчерпан;
+	// 403 — аккаунт заблокирован администратором;
+	// 429 — слишком много неверных кодов;
+	// 500 — внутренняя ошибка сервера.
+
+	var data mfaLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if data.MFAToken == "" || data.Code == "" {
+		http.Error(w, "mfa_token and code required", http.StatusBadRequest)
+		return
+	}
+
+	tokenHash := services.HashRefreshToken(data.MFAToken)
+	userID, err := h.storage.UseMFAChallenge(h.ctx, tokenHash, h.mfaMaxAttempts())
+	switch {
+	case errors.Is(err, db.ErrInvalidMFAToken):
+		http.Error(w, "invalid mfa token", http.StatusUnauthorized)
+		return
+	case err != nil:
+		h.logger.Errorf("ошибка проверки токена второго шага: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ip := h.clientIP(r)
+	if h.loginLocked(w, userID, ip) {
+		return
+	}
+
+	tf, err := h.storage.GetTwoFactor(h.ctx, userID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// 2FA отключили, пока шел вход: токен второго шага больше не действует
+		http.Error(w, "invalid mfa token", http.StatusUnauthorized)
+		return
+	case err != nil:
+		h.logger.Errorf("ошибка получения настроек 2FA: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	valid, err := h.verifySecondFactor(tf, data.Code)
+	if err != nil {
+		h.logger.Errorf("ошибка проверки кода 2FA пользователя %s: %w", userID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		h.logger.Infof("неверный код 2FA пользователя %s", userID)
+		h.registerLoginFailure(userID, ip)
+		http.Error(w, "неверный код", http.StatusUnauthorized)
+		return
+	}
+
+	// аккаунт могли заблокировать или сменить роль, пока шел вход
+	user, err := h.storage.GetUser(h.ctx, userID)
+	if err != nil {
+		h.logger.Errorf("Ошибка при получении пользователя %s: %w", userID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user.Blocked {
+		http.Error(w, "аккаунт заблокирован", http.StatusForbidden)
+		return
+	}
+
+	if err := h.storage.DeleteMFAChallenge(h.ctx, tokenHash); err != nil {
+		h.logger.Errorf("ошибка удаления токена второго шага: %w", err)
+	}
+	if err := h.storage.ResetLoginFailures(h.ctx, loginAttemptKey(userID)); err != nil {
+		h.logger.Errorf("ошибка сброса неудачных входов %s: %w", userID, err)
+	}
+
+	h.logger.Infof("пользователь %s прошел второй шаг входа", userID)
+	h.issueTokens(w, userID, user.Role)
+}
+
+// WithdrawalSecondFactor требует код 2FA в заголовке X-OTP-Code для списания,
+// если пользователь включил это требование. Ставится после AuthMiddleware.
+func (h *handlersData) WithdrawalSecondFactor(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		userID, ok := r.Context().Value(userIDKey).(string)
+		if !ok {
+			h.logger.Errorf("путой юзер детектед")
+			http.Error(w, "wrong user id", http.StatusUnauthorized)
+			return
+		}
+
+		tf, err := h.storage.GetTwoFactor(h.ctx, userID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			next.ServeHTTP(w, r)
+			return
+		case err != nil:
+			h.logger.Errorf("ошибка получения настроек 2FA: %w", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		case !tf.Enabled || !tf.RequireForWithdrawals:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		code := r.Header.Get(OTPCodeHeader)
+		if code == "" {
+			http.Error(w, "для списания нужен код 2FA в заголовке "+OTPCodeHeader, http.StatusForbidden)
+			return
+		}
+		ip := h.clientIP(r)
+		if h.loginLocked(w, userID, ip) {
+			return
+		}
+		valid, err := h.verifySecondFactor(tf, code)
+		if err != nil {
+			h.logger.Errorf("ошибка проверки кода 2FA пользователя %s: %w", userID, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !valid {
+			h.logger.Infof("неверный код 2FA для списания пользователя %s", userID)
+			h.registerLoginFailure(userID, ip)
+			http.Error(w, "неверный код", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// startSecondFactor - если у пользователя включена 2FA, вместо токенов отвечает 202
+// с токеном второго шага. false - 2FA не включена и вход продолжается.
+func (h *handlersData) startSecondFactor(w http.ResponseWriter, userID string) bool {
+
+	tf, err := h.storage.GetTwoFactor(h.ctx, userID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return false
+	case err != nil:
+		h.logger.Errorf("ошибка получения настроек 2FA: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	case !tf.Enabled:
+		return false
+	}
+
+	token, tokenHash, err := services.NewMFAToken()
+	if err != nil {
+		h.logger.Errorf("ошибка создания токена второго шага: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	ttl := h.mfaChallengeTTL()
+	if err := h.storage.AddMFAChallenge(h.ctx, userID, tokenHash, ttl); err != nil {
+		h.logger.Errorf("ошибка сохранения токена второго шага: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+
+	h.logger.Infof("пользователь %s: нужен второй шаг входа", userID)
+	h.writeJSON(w, http.StatusAccepted, models.MFAChallenge{MFAToken: token, ExpiresIn: int64(ttl.Seconds())})
+	return true
+}
+
+// requireCode проверяет код 2FA для изменения настроек. false - ответ уже отправлен.
+func (h *handlersData) requireCode(w http.ResponseWriter, r *http.Request, userID, code string) bool {
+
+	ip := h.clientIP(r)
+	if h.loginLocked(w, userID, ip) {
+		return false
+	}
+
+	tf, err := h.storage.GetTwoFactor(h.ctx, userID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows) || (err == nil && !tf.Enabled):
+		http.Error(w, "2FA не включена", http.StatusConflict)
+		return false
+	case err != nil:
+		h.logger.Errorf("ошибка получения настроек 2FA: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	valid, err := h.verifySecondFactor(tf, code)
+	if err != nil {
+		h.logger.Errorf("ошибка проверки кода 2FA пользователя %s: %w", userID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !valid {
+		h.logger.Infof("неверный код 2FA пользователя %s", userID)
+		h.registerLoginFailure(userID, ip)
+		http.Error(w, "неверный код", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// verifySecondFactor принимает код TOTP (каждый интервал один раз) или неиспользованный код восстановления.
+func (h *handlersData) verifySecondFactor(tf models.TwoFactor, code string) (bool, error) {
+
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return false, nil
+	}
+	if !services.IsTOTPCode(code) {
+		return h.storage.UseRecoveryCode(h.ctx, tf.UserID, services.HashRecoveryCode(code))
+	}
+
+	secret, err := h.openTOTPSecret(tf)
+	if err != nil {
+		return false, err
+	}
+	step, valid := services.ValidateTOTP(secret, code, time.Now())
+	if !valid || step <= tf.LastStep {
+		return false, nil
+	}
+	return h.storage.UseTOTPStep(h.ctx, tf.UserID, step)
+}
+
+func (h *handlersData) openTOTPSecret(tf models.TwoFactor) (string, error) {
+	if h.TOTPBox == nil {
+		return "", services.ErrSecretBox
+	}
+	return h.TOTPBox.Open(tf.Secret)
+}
+
+func (h *handlersData) codeRequest(w http.ResponseWriter, r *http.Request) (string, codeRequest, bool) {
+
+	var data codeRequest
+	userID, ok := r.Context().Value(userIDKey).(string)
+	if !ok {
+		h.logger.Errorf("путой юзер детектед")
+		http.Error(w, "wrong user id", http.StatusUnauthorized)
+		return "", data, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", data, false
+	}
+	return userID, data, true
+}
+
+func (h *handlersData) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		h.logger.Errorf("ошибка маршалинга: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setResponseHeaders(w, ApplicationJSON, status)
+	w.Write(jsonData)
+}
+
+func (h *handlersData) mfaChallengeTTL() time.Duration {
+	if h.MFAChallengeTTL <= 0 {
+		return DefaultMFAChallengeTTL
+	}
+	return h.MFAChallengeTTL
+}
+
+func (h *handlersData) mfaMaxAttempts() int {
+	if h.MFAMaxAttempts <= 0 {
+		return DefaultMFAMaxAttempts
+	}
+	return h.MFAMaxAttempts
+}
+
+func (h *handlersData) recoveryCodeCount() int {
+	if h.RecoveryCodeCount <= 0 {
+		return DefaultRecoveryCodeCount
+	}
+	return h.RecoveryCodeCount
+}
@@ -4,7 +4,6 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"gophermart/internal/models"
 	"gophermart/internal/services"
 	"net/http"
@@ -23,30 +22,31 @@ func (h *handlersData) Registration(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Пароль или логин не кор ректны", http.StatusBadRequest)
 		return
 	}
+	if err := h.PasswordPolicy.Validate(data.Login, data.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	_, err = h.storage.WithRetry(h.ctx, h.storage.GetUser(h.ctx, data.Login))
+	_, err = h.storage.GetUser(h.ctx, data.Login)
 
 	switch {
 	case errors.Is(err, sql.ErrNoRows):
 
-		hash := services.GetHash(data.Login, data.Password)
-
-		_, err = h.storage.WithRetry(h.ctx, h.storage.AddUser(h.ctx, data.Login, hash))
+		hash, err := h.Hasher.Hash(data.Password)
 		if err != nil {
-			h.logger.Errorf("Ошибка добавления пользователя %w", err)
+			h.logger.Errorf("ошибка хеширования пароля %w", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		jwtString, err := h.AuthToken.BuildJWTString(data.Login)
+		err = h.storage.AddUser(h.ctx, data.Login, hash)
 		if err != nil {
-			h.logger.Errorf("ошибка создания токена:  %w")
+			h.logger.Errorf("Ошибка добавления пользователя %w", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		w.Header().Add("Authorization", jwtString)
-		setResponseHeaders(w, ApplicationJSON, http.StatusOK)
+		h.issueTokens(w, data.Login, models.RoleUser)
 
 	case err != nil:
 
@@ -71,6 +71,14 @@ func setResponseHeaders(w http.ResponseWriter, contentType string, statusCode in
 
 func (h *handlersData) Login(w http.ResponseWriter, r *http.Request) {
 
+	// 200 — пользователь успешно аутентифицирован;
+	// 202 — пароль верен, нужен второй шаг входа POST /api/user/login/2fa;
+	// 400 — неверный формат запроса;
+	// 401 — неверная пара логин/пароль, одинаково для неизвестного логина и неверного пароля;
+	// 403 — аккаунт заблокирован администратором;
+	// 429 — слишком много неудачных попыток, вход временно заблокирован (Retry-After);
+	// 500 — внутренняя ошибка сервера.
+
 	var data authData
 
 	err := json.NewDecoder(r.Body).Decode(&data)
@@ -83,42 +91,86 @@ func (h *handlersData) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userInterface, err := h.storage.WithRetry(h.ctx, h.storage.GetUser(h.ctx, data.Login))
-	user, ok := userInterface.(models.User)
+	ip := h.clientIP(r)
+	if h.loginLocked(w, data.Login, ip) {
+		return
+	}
+
+	user, err := h.storage.GetUser(h.ctx, data.Login)
 
 	switch {
 	case errors.Is(err, sql.ErrNoRows):
 
-		h.logger.Errorf("Пользователя %w не существует", data.Login)
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		// пароль проверяется и для неизвестного логина, чтобы время ответа было тем же
+		services.VerifyPassword(h.Hasher, data.Login, data.Password, h.dummyHash())
+		h.logger.Infof("вход с неизвестным логином %s", data.Login)
+		h.loginFailed(w, data.Login, ip)
 		return
 
-	case err != nil || !ok:
+	case err != nil:
 		h.logger.Errorf("Ошибка при получении пользователя %w", data.Login)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	default:
 
-		hash := services.GetHash(data.Login, data.Password)
-		if hash == user.Hash {
+		valid, rehash, err := services.VerifyPassword(h.Hasher, data.Login, data.Password, user.Hash)
+		if err != nil {
+			h.logger.Errorf("ошибка проверки пароля пользователя %s: %w", data.Login, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if valid {
 
 			h.logger.Infof("пользователь %s идентифицирован", data.Login)
 
-			jwtString, err := h.AuthToken.BuildJWTString(data.Login)
-			if err != nil {
-				h.logger.Error("ошибка создания токена")
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+			if rehash {
+				h.upgradeHash(data.Login, data.Password)
+			}
+
+			// о блокировке сообщаем только после верного пароля, чтобы не выдавать наличие аккаунта
+			if user.Blocked {
+				h.logger.Infof("вход заблокированного пользователя %s", data.Login)
+				http.Error(w, "аккаунт заблокирован", http.StatusForbidden)
+				return
+			}
+
+			// с включенной 2FA неудачи сбрасываются только после второго шага
+			if h.startSecondFactor(w, data.Login) {
 				return
 			}
 
-			w.Header().Add("Authorization", jwtString)
-			setResponseHeaders(w, ApplicationJSON, http.StatusOK)
+			// счетчик адреса не сбрасывается: иначе перебор чужих логинов
+			// можно было бы прятать между входами в свой аккаунт
+			if err := h.storage.ResetLoginFailures(h.ctx, loginAttemptKey(data.Login)); err != nil {
+				h.logger.Errorf("ошибка сброса неудачных входов %s: %w", data.Login, err)
+			}
+
+			h.issueTokens(w, data.Login, user.Role)
 
 		} else {
 			h.logger.Infof("неверный пароль для пользователя %s", data.Login)
-			http.Error(w, fmt.Sprint(data.Login, "-неверный пароль"), http.StatusUnauthorized)
+			h.loginFailed(w, data.Login, ip)
 		}
 
 	}
 
 }
+
+// upgradeHash пересчитывает хеш пароля текущим алгоритмом. Ошибка не мешает входу:
+// хеш обновится при следующем успешном логине.
+func (h *handlersData) upgradeHash(login, password string) {
+
+	hash, err := h.Hasher.Hash(password)
+	if err != nil {
+		h.logger.Errorf("ошибка хеширования пароля %w", err)
+		return
+	}
+
+	err = h.storage.UpdateUserHash(h.ctx, login, hash)
+	if err != nil {
+		h.logger.Errorf("ошибка обновления хеша пользователя %s: %w", login, err)
+		return
+	}
+	h.logger.Infof("хеш пароля пользователя %s обновлен", login)
+}
@@ -0,0 +1,161 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	db "gophermart/internal/database"
+	"gophermart/internal/services"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+)
+
+// webhookDeliveriesLimit - сколько последних доставок показывает журнал webhook'а.
+const webhookDeliveriesLimit = 100
+
+type webhookRequest struct {
+	URL string `json:"url"`
+}
+
+// AddWebhook регистрирует адрес для событий пользователя. Секрет для проверки подписи
+// возвращается только в ответе на регистрацию.
+func (h *handlersData) AddWebhook(w http.ResponseWriter, r *http.Request) {
+
+	// 201 — webhook зарегистрирован;
+	// 400 — неверный формат запроса;
+	// 401 — пользователь не авторизован;
+	// 422 — адрес не является абсолютным http(s) URL или ведет во внутреннюю сеть;
+	// 500 — внутренняя ошибка сервера.
+
+	userID, ok := r.Context().Value(userIDKey).(string)
+	if !ok {
+		h.logger.Errorf("путой юзер детектед")
+		http.Error(w, "wrong user id", http.StatusUnauthorized)
+		return
+	}
+
+	var data webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	u, err := services.CheckWebhookURL(r.Context(), h.WebhookResolver, data.URL)
+	if err != nil {
+		h.logger.Infof("пользователь %s: неверный адрес webhook %q: %v", userID, data.URL, err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	secret, err := services.NewWebhookSecret()
+	if err != nil {
+		h.logger.Errorf("ошибка генерации секрета webhook: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	webhook, err := h.storage.AddWebhook(h.ctx, userID, u.String(), secret)
+	if err != nil {
+		h.logger.Errorf("Ошибка запроса к базе: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Infof("пользователь %s зарегистрировал webhook %d", userID, webhook.ID)
+	setResponseHeaders(w, ApplicationJSON, http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(webhook); err != nil {
+		h.logger.Errorf("Ошибка маршалинга: %w", err)
+	}
+}
+
+func (h *handlersData) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+
+	userID, ok := r.Context().Value(userIDKey).(string)
+	if !ok {
+		h.logger.Errorf("путой юзер детектед")
+		http.Error(w, "wrong user id", http.StatusUnauthorized)
+		return
+	}
+
+	webhooks, err := h.storage.GetWebhooks(h.ctx, userID)
+	if err != nil {
+		h.logger.Errorf("Ошибка запроса к базе: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(webhooks) == 0 {
+		setResponseHeaders(w, ApplicationJSON, http.StatusNoContent)
+		return
+	}
+
+	setResponseHeaders(w, ApplicationJSON, http.StatusOK)
+	if err := json.NewEncoder(w).Encode(webhooks); err != nil {
+		h.logger.Errorf("Ошибка маршалинга: %w", err)
+	}
+}
+
+func (h *handlersData) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+
+	userID, ok := r.Context().Value(userIDKey).(string)
+	if !ok {
+		h.logger.Errorf("путой юзер детектед")
+		http.Error(w, "wrong user id", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "wrong webhook id", http.StatusBadRequest)
+		return
+	}
+
+	err = h.storage.DeleteWebhook(h.ctx, userID, id)
+	switch {
+	case errors.Is(err, db.ErrWebhookNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err != nil:
+		h.logger.Errorf("Ошибка запроса к базе: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		h.logger.Infof("пользователь %s удалил webhook %d", userID, id)
+		setResponseHeaders(w, ApplicationJSON, http.StatusNoContent)
+	}
+}
+
+// GetWebhookDeliveries - журнал доставок webhook'а, новые первыми.
+func (h *handlersData) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+
+	userID, ok := r.Context().Value(userIDKey).(string)
+	if !ok {
+		h.logger.Errorf("путой юзер детектед")
+		http.Error(w, "wrong user id", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "wrong webhook id", http.StatusBadRequest)
+		return
+	}
+
+	deliveries, err := h.storage.GetWebhookDeliveries(h.ctx, userID, id, webhookDeliveriesLimit)
+	switch {
+	case errors.Is(err, db.ErrWebhookNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		h.logger.Errorf("Ошибка запроса к базе: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(deliveries) == 0 {
+		setResponseHeaders(w, ApplicationJSON, http.StatusNoContent)
+		return
+	}
+
+	setResponseHeaders(w, ApplicationJSON, http.StatusOK)
+	if err := json.NewEncoder(w).Encode(deliveries); err != nil {
+		h.logger.Errorf("Ошибка маршалинга: %w", err)
+	}
+}
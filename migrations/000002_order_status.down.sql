@@ -0,0 +1,2 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS unregistered_since;
+ALTER TABLE billing DROP CONSTRAINT IF EXISTS billing_status_check;
@@ -0,0 +1,11 @@
+-- REGISTERED из системы расчета раньше записывался как есть, теперь это PROCESSING
+DELETE FROM billing r
+WHERE r.status = 'REGISTERED'
+AND EXISTS (SELECT 1 FROM billing p WHERE p.order_number = r.order_number AND p.status = 'PROCESSING');
+
+UPDATE billing SET status = 'PROCESSING' WHERE status = 'REGISTERED';
+
+ALTER TABLE billing ADD CONSTRAINT billing_status_check
+	CHECK (status IN ('NEW', 'PROCESSING', 'PROCESSED', 'INVALID', 'WITHDRAWN'));
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS unregistered_since timestamp;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS orders_locked_until_idx;
+ALTER TABLE orders DROP COLUMN IF EXISTS locked_until;
+ALTER TABLE orders DROP COLUMN IF EXISTS locked_by;
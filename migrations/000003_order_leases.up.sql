@@ -0,0 +1,4 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS locked_by VARCHAR;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS locked_until timestamp;
+
+CREATE INDEX IF NOT EXISTS orders_locked_until_idx ON orders (locked_until);
@@ -0,0 +1,3 @@
+ALTER TABLE billing ALTER COLUMN accrual DROP NOT NULL;
+ALTER TABLE billing ALTER COLUMN accrual DROP DEFAULT;
+ALTER TABLE billing ALTER COLUMN accrual TYPE int USING (accrual * 10)::int;
@@ -0,0 +1,6 @@
+-- суммы хранились как float*1000 в int, переходим на копейки в BIGINT
+UPDATE billing SET accrual = 0 WHERE accrual IS NULL;
+
+ALTER TABLE billing ALTER COLUMN accrual TYPE BIGINT USING ROUND(accrual / 10.0)::BIGINT;
+ALTER TABLE billing ALTER COLUMN accrual SET DEFAULT 0;
+ALTER TABLE billing ALTER COLUMN accrual SET NOT NULL;
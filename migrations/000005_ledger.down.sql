@@ -0,0 +1,21 @@
+-- возвращаем списания в billing фиктивными заказами
+INSERT INTO orders (number, user_id, uploaded_at)
+SELECT e.order_number, e.user_id, e.created_at
+FROM ledger_entries e
+WHERE e.kind = 'withdrawal'
+ON CONFLICT (number) DO NOTHING;
+
+INSERT INTO billing (order_number, status, accrual, uploaded_at, time)
+SELECT e.order_number, 'WITHDRAWN', p.amount, e.created_at, e.created_at
+FROM ledger_entries e
+JOIN ledger_postings p ON p.entry_id = e.id
+JOIN ledger_accounts a ON a.id = p.account_id
+WHERE e.kind = 'withdrawal' AND a.kind = 'withdrawal'
+ON CONFLICT (order_number, status) DO NOTHING;
+
+DROP TABLE IF EXISTS user_balances;
+DROP TRIGGER IF EXISTS ledger_postings_balanced ON ledger_postings;
+DROP FUNCTION IF EXISTS ledger_check_balanced();
+DROP TABLE IF EXISTS ledger_postings;
+DROP TABLE IF EXISTS ledger_entries;
+DROP TABLE IF EXISTS ledger_accounts;
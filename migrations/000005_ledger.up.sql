@@ -0,0 +1,107 @@
+-- счета: у каждого пользователя счет баллов (points), системные счета без user_id:
+-- accrual - источник начислений, withdrawal - куда уходят списанные баллы
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+	id BIGSERIAL PRIMARY KEY,
+	user_id VARCHAR REFERENCES users(user_id),
+	kind VARCHAR NOT NULL CHECK (kind <> '')
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_accounts_user_kind_idx ON ledger_accounts (user_id, kind) WHERE user_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_accounts_system_kind_idx ON ledger_accounts (kind) WHERE user_id IS NULL;
+
+-- проводка: одно движение баллов пользователя (начисление по заказу, списание)
+CREATE TABLE IF NOT EXISTS ledger_entries (
+	id BIGSERIAL PRIMARY KEY,
+	user_id VARCHAR NOT NULL REFERENCES users(user_id),
+	kind VARCHAR NOT NULL CHECK (kind <> ''),
+	order_number VARCHAR NOT NULL CHECK (order_number <> ''),
+	created_at timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS ledger_entries_user_idx ON ledger_entries (user_id, created_at);
+-- одно начисление на заказ и одно списание на номер заказа
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_entries_order_kind_idx ON ledger_entries (order_number, kind);
+
+CREATE TABLE IF NOT EXISTS ledger_postings (
+	id BIGSERIAL PRIMARY KEY,
+	entry_id BIGINT NOT NULL REFERENCES ledger_entries(id),
+	account_id BIGINT NOT NULL REFERENCES ledger_accounts(id),
+	amount BIGINT NOT NULL CHECK (amount <> 0)
+);
+
+CREATE INDEX IF NOT EXISTS ledger_postings_entry_idx ON ledger_postings (entry_id);
+
+-- сумма проводок по записи должна быть нулевой; проверяется при commit
+CREATE OR REPLACE FUNCTION ledger_check_balanced() RETURNS trigger AS $$
+BEGIN
+	IF (SELECT COALESCE(SUM(amount), 0) FROM ledger_postings WHERE entry_id = NEW.entry_id) <> 0 THEN
+		RAISE EXCEPTION 'ledger entry % is not balanced', NEW.entry_id;
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER ledger_postings_balanced
+	AFTER INSERT OR UPDATE ON ledger_postings
+	DEFERRABLE INITIALLY DEFERRED
+	FOR EACH ROW EXECUTE FUNCTION ledger_check_balanced();
+
+-- кеш баланса, обновляется в той же транзакции, что и проводки
+CREATE TABLE IF NOT EXISTS user_balances (
+	user_id VARCHAR PRIMARY KEY REFERENCES users(user_id),
+	current BIGINT NOT NULL DEFAULT 0,
+	withdrawn BIGINT NOT NULL DEFAULT 0
+);
+
+INSERT INTO ledger_accounts (user_id, kind) VALUES (NULL, 'accrual'), (NULL, 'withdrawal');
+INSERT INTO ledger_accounts (user_id, kind) SELECT user_id, 'points' FROM users;
+
+-- переносим начисления и списания из billing в журнал
+DO $$
+DECLARE
+	r RECORD;
+	entry BIGINT;
+	user_account BIGINT;
+	accrual_account BIGINT;
+	withdrawal_account BIGINT;
+BEGIN
+	SELECT id INTO accrual_account FROM ledger_accounts WHERE user_id IS NULL AND kind = 'accrual';
+	SELECT id INTO withdrawal_account FROM ledger_accounts WHERE user_id IS NULL AND kind = 'withdrawal';
+
+	FOR r IN
+		SELECT o.user_id, b.order_number, b.status, b.accrual, b.uploaded_at
+		FROM billing b
+		JOIN orders o ON o.number = b.order_number
+		WHERE b.status IN ('PROCESSED', 'WITHDRAWN') AND b.accrual <> 0
+		ORDER BY b.time
+	LOOP
+		SELECT id INTO user_account FROM ledger_accounts WHERE user_id = r.user_id AND kind = 'points';
+
+		IF r.status = 'PROCESSED' THEN
+			INSERT INTO ledger_entries (user_id, kind, order_number, created_at)
+			VALUES (r.user_id, 'accrual', r.order_number, r.uploaded_at) RETURNING id INTO entry;
+			INSERT INTO ledger_postings (entry_id, account_id, amount)
+			VALUES (entry, accrual_account, -r.accrual), (entry, user_account, r.accrual);
+		ELSE
+			INSERT INTO ledger_entries (user_id, kind, order_number, created_at)
+			VALUES (r.user_id, 'withdrawal', r.order_number, r.uploaded_at) RETURNING id INTO entry;
+			INSERT INTO ledger_postings (entry_id, account_id, amount)
+			VALUES (entry, user_account, -r.accrual), (entry, withdrawal_account, r.accrual);
+		END IF;
+	END LOOP;
+END $$;
+
+INSERT INTO user_balances (user_id, current, withdrawn)
+SELECT u.user_id,
+	COALESCE((SELECT SUM(p.amount) FROM ledger_postings p
+		JOIN ledger_accounts a ON a.id = p.account_id
+		WHERE a.user_id = u.user_id AND a.kind = 'points'), 0),
+	COALESCE((SELECT SUM(p.amount) FROM ledger_postings p
+		JOIN ledger_entries e ON e.id = p.entry_id
+		JOIN ledger_accounts a ON a.id = p.account_id
+		WHERE e.user_id = u.user_id AND e.kind = 'withdrawal' AND a.kind = 'withdrawal'), 0)
+FROM users u;
+
+-- списания больше не хранятся фиктивными заказами
+DELETE FROM billing WHERE status = 'WITHDRAWN';
+DELETE FROM orders o WHERE NOT EXISTS (SELECT 1 FROM billing b WHERE b.order_number = o.number);
@@ -0,0 +1 @@
+ALTER TABLE user_balances DROP CONSTRAINT IF EXISTS user_balances_current_non_negative;
@@ -0,0 +1,2 @@
+-- последний рубеж: даже при ошибке в коде баланс не уйдет в минус
+ALTER TABLE user_balances ADD CONSTRAINT user_balances_current_non_negative CHECK (current >= 0);
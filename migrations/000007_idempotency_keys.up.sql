@@ -0,0 +1,13 @@
+-- ответы на запросы с заголовком Idempotency-Key. status_code IS NULL - запрос еще выполняется
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	user_id VARCHAR NOT NULL REFERENCES users(user_id),
+	key VARCHAR NOT NULL CHECK (key <> ''),
+	request_hash VARCHAR NOT NULL,
+	status_code int,
+	content_type VARCHAR,
+	body BYTEA,
+	created_at timestamp NOT NULL,
+	PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_created_at_idx ON idempotency_keys (created_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS billing_order_time_idx;
+DROP INDEX IF EXISTS ledger_entries_user_kind_created_idx;
+DROP INDEX IF EXISTS orders_user_uploaded_idx;
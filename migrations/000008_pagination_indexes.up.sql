@@ -0,0 +1,5 @@
+-- постраничная выдача заказов и списаний пользователя (keyset по времени и ключу строки)
+CREATE INDEX IF NOT EXISTS orders_user_uploaded_idx ON orders (user_id, uploaded_at, number);
+CREATE INDEX IF NOT EXISTS ledger_entries_user_kind_created_idx ON ledger_entries (user_id, kind, created_at, id);
+-- текущий статус заказа - последняя строка billing
+CREATE INDEX IF NOT EXISTS billing_order_time_idx ON billing (order_number, time);
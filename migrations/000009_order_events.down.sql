@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS order_events_notify ON order_events;
+DROP FUNCTION IF EXISTS order_events_notify();
+DROP TABLE IF EXISTS order_events;
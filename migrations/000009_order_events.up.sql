@@ -0,0 +1,25 @@
+-- изменения статусов заказов для потока событий пользователя (SSE).
+-- id - позиция в потоке, клиент возобновляет чтение с нее по Last-Event-ID
+CREATE TABLE IF NOT EXISTS order_events (
+	id BIGSERIAL PRIMARY KEY,
+	user_id VARCHAR NOT NULL REFERENCES users(user_id),
+	order_number VARCHAR NOT NULL REFERENCES orders(number),
+	status VARCHAR NOT NULL,
+	accrual BIGINT NOT NULL DEFAULT 0,
+	created_at timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS order_events_user_idx ON order_events (user_id, id);
+
+-- NOTIFY доставляется слушателям только после commit, payload - пользователь,
+-- у которого появились события; сами события читаются из таблицы
+CREATE OR REPLACE FUNCTION order_events_notify() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_notify('order_events', NEW.user_id);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER order_events_notify
+	AFTER INSERT ON order_events
+	FOR EACH ROW EXECUTE FUNCTION order_events_notify();
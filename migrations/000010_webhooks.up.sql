@@ -0,0 +1,30 @@
+-- webhook'и пользователя: события подписываются HMAC-SHA256 с секретом webhook'а
+CREATE TABLE IF NOT EXISTS webhooks (
+	id BIGSERIAL PRIMARY KEY,
+	user_id VARCHAR NOT NULL REFERENCES users(user_id),
+	url VARCHAR NOT NULL CHECK (url <> ''),
+	secret VARCHAR NOT NULL CHECK (secret <> ''),
+	created_at timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_user_idx ON webhooks (user_id);
+
+-- очередь доставки и журнал: строка пишется в транзакции события и остается после доставки
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id BIGSERIAL PRIMARY KEY,
+	webhook_id BIGINT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+	event VARCHAR NOT NULL,
+	payload BYTEA NOT NULL,
+	status VARCHAR NOT NULL CHECK (status IN ('pending', 'delivered', 'failed')),
+	attempts int NOT NULL DEFAULT 0,
+	next_attempt_at timestamp NOT NULL,
+	last_status_code int,
+	last_error VARCHAR,
+	locked_by VARCHAR,
+	locked_until timestamp,
+	created_at timestamp NOT NULL,
+	delivered_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS outbox_relay;
+DROP TABLE IF EXISTS outbox;
@@ -0,0 +1,26 @@
+-- outbox: доменные события пишутся в транзакции изменения состояния,
+-- relay публикует их в sinks по возрастанию id и отмечает published_at
+CREATE TABLE IF NOT EXISTS outbox (
+	id BIGSERIAL PRIMARY KEY,
+	user_id VARCHAR NOT NULL,
+	event_type VARCHAR NOT NULL,
+	payload BYTEA NOT NULL,
+	attempts int NOT NULL DEFAULT 0,
+	next_attempt_at timestamp NOT NULL,
+	last_error VARCHAR,
+	created_at timestamp NOT NULL,
+	published_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS outbox_unpublished_idx ON outbox (id) WHERE published_at IS NULL;
+CREATE INDEX IF NOT EXISTS outbox_user_unpublished_idx ON outbox (user_id, id) WHERE published_at IS NULL;
+
+-- единственная строка - захват relay: события публикует один экземпляр за раз,
+-- иначе порядок событий пользователя не сохранить
+CREATE TABLE IF NOT EXISTS outbox_relay (
+	id int PRIMARY KEY CHECK (id = 1),
+	locked_by VARCHAR,
+	locked_until timestamp
+);
+
+INSERT INTO outbox_relay (id) VALUES (1) ON CONFLICT DO NOTHING;
@@ -0,0 +1,27 @@
+-- сессии пользователя: access-токен несет id сессии, отзыв сессии отзывает все ее токены
+CREATE TABLE IF NOT EXISTS sessions (
+	id VARCHAR PRIMARY KEY,
+	user_id VARCHAR NOT NULL REFERENCES users(user_id),
+	created_at timestamp NOT NULL,
+	revoked_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_idx ON sessions (user_id) WHERE revoked_at IS NULL;
+
+-- refresh-токены хранятся только хешем; использованный токен остается,
+-- чтобы повторное предъявление отозвало сессию
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	token_hash VARCHAR PRIMARY KEY,
+	session_id VARCHAR NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+	created_at timestamp NOT NULL,
+	expires_at timestamp NOT NULL,
+	used_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_session_idx ON refresh_tokens (session_id);
+
+-- отозванные access-токены (jti) до истечения их срока
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+	jti VARCHAR PRIMARY KEY,
+	expires_at timestamp NOT NULL
+);
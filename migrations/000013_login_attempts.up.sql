@@ -0,0 +1,10 @@
+-- неудачные входы по логину и по IP клиента. Ключ - "login:<логин>" или "ip:<адрес>",
+-- строка заводится и для несуществующего логина, чтобы блокировка не выдавала наличие аккаунта
+CREATE TABLE IF NOT EXISTS login_attempts (
+	key VARCHAR PRIMARY KEY,
+	failures INT NOT NULL,
+	last_failure_at timestamp NOT NULL,
+	locked_until timestamp
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_last_failure_idx ON login_attempts (last_failure_at);
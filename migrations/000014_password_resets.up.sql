@@ -0,0 +1,10 @@
+-- одноразовые токены сброса пароля, хранятся только хешем
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+	token_hash VARCHAR PRIMARY KEY,
+	user_id VARCHAR NOT NULL REFERENCES users(user_id),
+	created_at timestamp NOT NULL,
+	expires_at timestamp NOT NULL,
+	used_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS password_reset_tokens_user_idx ON password_reset_tokens (user_id) WHERE used_at IS NULL;
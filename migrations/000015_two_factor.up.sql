@@ -0,0 +1,26 @@
+-- TOTP пользователя: секрет зашифрован, confirmed_at пуст до подтверждения первым кодом
+CREATE TABLE IF NOT EXISTS user_totp (
+	user_id VARCHAR PRIMARY KEY REFERENCES users(user_id),
+	secret BYTEA NOT NULL,
+	created_at timestamp NOT NULL,
+	confirmed_at timestamp,
+	last_step BIGINT NOT NULL DEFAULT 0,
+	require_for_withdrawals BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+-- одноразовые коды восстановления, хранятся только хешем
+CREATE TABLE IF NOT EXISTS recovery_codes (
+	code_hash VARCHAR PRIMARY KEY,
+	user_id VARCHAR NOT NULL REFERENCES users(user_id),
+	used_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS recovery_codes_user_idx ON recovery_codes (user_id);
+
+-- второй шаг входа: выдается после верного пароля, обменивается на токены вместе с кодом
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+	token_hash VARCHAR PRIMARY KEY,
+	user_id VARCHAR NOT NULL REFERENCES users(user_id),
+	attempts INT NOT NULL DEFAULT 0,
+	expires_at timestamp NOT NULL
+);
@@ -0,0 +1,9 @@
+-- корректировки остаются в журнале и балансе, удаляется только их описание
+DROP TABLE IF EXISTS balance_adjustments;
+
+UPDATE ledger_entries SET order_number = 'adjustment-' || id WHERE order_number IS NULL;
+ALTER TABLE ledger_entries ALTER COLUMN order_number SET NOT NULL;
+
+ALTER TABLE users DROP COLUMN IF EXISTS blocked_reason;
+ALTER TABLE users DROP COLUMN IF EXISTS blocked_at;
+ALTER TABLE users DROP COLUMN IF EXISTS role;
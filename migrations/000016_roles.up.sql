@@ -0,0 +1,24 @@
+-- роль пользователя и блокировка аккаунта администратором
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR NOT NULL DEFAULT 'user'
+	CHECK (role IN ('user', 'support', 'admin'));
+ALTER TABLE users ADD COLUMN IF NOT EXISTS blocked_at timestamp;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS blocked_reason VARCHAR;
+
+-- ручные корректировки баланса не привязаны к заказу
+ALTER TABLE ledger_entries ALTER COLUMN order_number DROP NOT NULL;
+
+INSERT INTO ledger_accounts (user_id, kind) VALUES (NULL, 'adjustment')
+ON CONFLICT (kind) WHERE user_id IS NULL DO NOTHING;
+
+-- кто, когда и почему изменил баланс вручную; сами баллы проводятся через журнал
+CREATE TABLE IF NOT EXISTS balance_adjustments (
+	id BIGSERIAL PRIMARY KEY,
+	entry_id BIGINT NOT NULL REFERENCES ledger_entries(id),
+	user_id VARCHAR NOT NULL REFERENCES users(user_id),
+	amount BIGINT NOT NULL CHECK (amount <> 0),
+	reason VARCHAR NOT NULL CHECK (reason <> ''),
+	operator VARCHAR NOT NULL REFERENCES users(user_id),
+	created_at timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS balance_adjustments_user_idx ON balance_adjustments (user_id, created_at);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS balance_adjustments_entry_idx;
+ALTER TABLE balance_adjustments DROP COLUMN IF EXISTS order_number;
+ALTER TABLE balance_adjustments DROP COLUMN IF EXISTS ticket;
+ALTER TABLE balance_adjustments DROP COLUMN IF EXISTS reason_code;
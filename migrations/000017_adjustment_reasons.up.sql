@@ -0,0 +1,9 @@
+-- код причины, обращение и заказ (для возврата) у ручных корректировок
+ALTER TABLE balance_adjustments ADD COLUMN IF NOT EXISTS reason_code VARCHAR NOT NULL DEFAULT 'other'
+	CHECK (reason_code IN ('accrual_correction', 'refund', 'compensation', 'fraud', 'other'));
+ALTER TABLE balance_adjustments ALTER COLUMN reason_code DROP DEFAULT;
+ALTER TABLE balance_adjustments ADD COLUMN IF NOT EXISTS ticket VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE balance_adjustments ALTER COLUMN ticket DROP DEFAULT;
+ALTER TABLE balance_adjustments ADD COLUMN IF NOT EXISTS order_number VARCHAR;
+
+CREATE INDEX IF NOT EXISTS balance_adjustments_entry_idx ON balance_adjustments (entry_id);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS balance_adjustments_order_idx;
+-- не получится, если по заказу уже было несколько возвратов
+DROP INDEX IF EXISTS ledger_entries_order_kind_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_entries_order_kind_idx ON ledger_entries (order_number, kind);
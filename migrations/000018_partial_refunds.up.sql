@@ -0,0 +1,8 @@
+-- возвратов по одному заказу может быть несколько (частичные возвраты),
+-- остальные виды проводок по-прежнему уникальны для заказа
+DROP INDEX IF EXISTS ledger_entries_order_kind_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_entries_order_kind_idx ON ledger_entries (order_number, kind)
+	WHERE kind <> 'refund';
+
+CREATE INDEX IF NOT EXISTS balance_adjustments_order_idx ON balance_adjustments (user_id, order_number)
+	WHERE order_number IS NOT NULL;
@@ -0,0 +1,2 @@
+-- опубликованные события удаляются relay после срока хранения OutboxRetention
+CREATE INDEX IF NOT EXISTS outbox_published_idx ON outbox (published_at) WHERE published_at IS NOT NULL;
@@ -0,0 +1,2 @@
+-- завершенные доставки удаляются из журнала после срока хранения WebhookRetention
+CREATE INDEX IF NOT EXISTS webhook_deliveries_finished_idx ON webhook_deliveries (created_at) WHERE status <> 'pending';
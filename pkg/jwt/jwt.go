@@ -1,6 +1,8 @@
 package jwtpackage
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"time"
@@ -8,14 +10,21 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// Token подписывает токены общим секретом (HS256) или, если задан Keys,
+// текущим ключом набора (RS256/EdDSA) с kid в заголовке.
 type Token struct {
 	TokenExp time.Duration
 	Secret   string
+	Keys     *KeyRing
 }
 
+// Claims - UserID и SessionID сессии, в которой выдан токен. ID (jti) - по нему токен отзывается.
+// Role - роль пользователя на момент выдачи токена, пустая - обычный пользователь.
 type Claims struct {
 	jwt.RegisteredClaims
-	UserID string
+	UserID    string
+	SessionID string `json:",omitempty"`
+	Role      string `json:",omitempty"`
 }
 
 var ErrInvalidToken = errors.New("invalid token")
@@ -27,42 +36,112 @@ func NewToken(tokenExp time.Duration, secret string) *Token {
 	}
 }
 
+// NewTokenWithKeys - токены, подписанные ключами набора keys. Общий секрет не используется,
+// такие токены проверяются другими сервисами по /.well-known/jwks.json.
+func NewTokenWithKeys(tokenExp time.Duration, keys *KeyRing) *Token {
+	return &Token{
+		TokenExp: tokenExp,
+		Keys:     keys,
+	}
+}
+
 // BuildJWTString создаёт токен и возвращает его в виде строки.
 func (tok *Token) BuildJWTString(UserID string) (string, error) {
+	return tok.BuildSessionJWT(UserID, "")
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(tok.TokenExp)),
-		},
+// BuildSessionJWT создаёт токен сессии sessionID: после отзыва сессии токен не принимается.
+func (tok *Token) BuildSessionJWT(userID, sessionID string) (string, error) {
+	return tok.BuildRoleJWT(userID, sessionID, "")
+}
 
-		UserID: UserID,
-	})
+// BuildRoleJWT создаёт токен сессии sessionID с ролью пользователя.
+func (tok *Token) BuildRoleJWT(userID, sessionID, role string) (string, error) {
 
-	tokenString, err := token.SignedString([]byte(tok.Secret))
+	jti, err := newJTI()
 	if err != nil {
 		return "", err
 	}
 
-	return tokenString, nil
+	now := time.Now()
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(tok.TokenExp)),
+		},
+
+		UserID:    userID,
+		SessionID: sessionID,
+		Role:      role,
+	}
+
+	if tok.Keys == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(tok.Secret))
+	}
+
+	key := tok.Keys.Current()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.Private)
 }
 
-func (tok *Token) GetUserID(tokenString string) (string, error) {
+// ParseClaims проверяет подпись и срок токена и возвращает его claims.
+func (tok *Token) ParseClaims(tokenString string) (*Claims, error) {
 
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims,
-		func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-			}
-			return []byte(tok.Secret), nil
-		})
+	token, err := jwt.ParseWithClaims(tokenString, claims, tok.verificationKey)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if !token.Valid {
-		return "", ErrInvalidToken
+		return nil, ErrInvalidToken
 	}
 
-	return claims.UserID, err
+	return claims, nil
+}
+
+// verificationKey - ключ проверки подписи: ключ набора по kid или общий секрет.
+func (tok *Token) verificationKey(t *jwt.Token) (interface{}, error) {
+
+	if tok.Keys != nil {
+		kid, _ := t.Header["kid"].(string)
+		key, err := tok.Keys.Lookup(kid, t.Method.Alg())
+		if err != nil {
+			return nil, err
+		}
+		return key.Public, nil
+	}
+
+	if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+	}
+	return []byte(tok.Secret), nil
+}
+
+// JWKS - открытые ключи для проверки токенов. При подписи общим секретом набор пуст.
+func (tok *Token) JWKS() JWKSet {
+	if tok.Keys == nil {
+		return JWKSet{Keys: []JWK{}}
+	}
+	return tok.Keys.JWKS()
+}
+
+func (tok *Token) GetUserID(tokenString string) (string, error) {
+
+	claims, err := tok.ParseClaims(tokenString)
+	if err != nil {
+		return "", err
+	}
+	return claims.UserID, nil
+}
+
+func newJTI() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
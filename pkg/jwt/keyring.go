@@ -0,0 +1,209 @@
+package jwtpackage
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrNoSigningKey = errors.New("no signing key")
+	ErrUnknownKey   = errors.New("unknown key id")
+)
+
+// Key - ключ из набора. Private есть только у ключей, которыми можно подписывать,
+// выведенные из оборота ключи могут храниться одним открытым ключом - только для проверки.
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+// KeyRing - набор ключей: текущим подписываются новые токены,
+// любым из набора проверяются токены по kid из заголовка.
+type KeyRing struct {
+	current *Key
+	keys    map[string]*Key
+}
+
+// JWK - открытый ключ в формате RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSet - ответ /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewKey создает ключ по закрытому или открытому ключу RSA (RS256) или Ed25519 (EdDSA).
+func NewKey(kid string, key interface{}) (*Key, error) {
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &Key{ID: kid, Method: jwt.SigningMethodRS256, Private: k, Public: &k.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &Key{ID: kid, Method: jwt.SigningMethodRS256, Public: k}, nil
+	case ed25519.PrivateKey:
+		return &Key{ID: kid, Method: jwt.SigningMethodEdDSA, Private: k, Public: k.Public()}, nil
+	case ed25519.PublicKey:
+		return &Key{ID: kid, Method: jwt.SigningMethodEdDSA, Public: k}, nil
+	}
+	return nil, fmt.Errorf("неподдерживаемый тип ключа %T", key)
+}
+
+// ParseKey разбирает PEM: закрытый ключ PKCS#8 (для RSA также PKCS#1) или открытый ключ PKIX.
+func ParseKey(kid string, data []byte) (*Key, error) {
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("нет PEM блока")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("неподдерживаемый PEM блок %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKey(kid, key)
+}
+
+// NewKeyRing собирает набор из ключей, текущий - currentID.
+func NewKeyRing(currentID string, keys ...*Key) (*KeyRing, error) {
+
+	ring := &KeyRing{keys: make(map[string]*Key, len(keys))}
+	for _, k := range keys {
+		if _, ok := ring.keys[k.ID]; ok {
+			return nil, fmt.Errorf("ключ %s встречается дважды", k.ID)
+		}
+		ring.keys[k.ID] = k
+	}
+
+	current, ok := ring.keys[currentID]
+	if !ok {
+		return nil, fmt.Errorf("текущий ключ %q: %w", currentID, ErrUnknownKey)
+	}
+	if current.Private == nil {
+		return nil, fmt.Errorf("текущий ключ %q: %w", currentID, ErrNoSigningKey)
+	}
+	ring.current = current
+
+	return ring, nil
+}
+
+// LoadKeyRing читает ключи из файлов <kid>.pem каталога dir.
+// Если currentID пуст, текущим становится закрытый ключ с наибольшим kid,
+// поэтому файлы ключей удобно называть датой выпуска.
+func LoadKeyRing(dir, currentID string) (*KeyRing, error) {
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("в каталоге %s нет ключей: %w", dir, ErrNoSigningKey)
+	}
+	sort.Strings(files)
+
+	keys := make([]*Key, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		k, err := ParseKey(strings.TrimSuffix(filepath.Base(file), ".pem"), data)
+		if err != nil {
+			return nil, fmt.Errorf("ключ %s: %w", file, err)
+		}
+		keys = append(keys, k)
+	}
+
+	if currentID == "" {
+		for _, k := range keys {
+			if k.Private != nil {
+				currentID = k.ID
+			}
+		}
+	}
+
+	return NewKeyRing(currentID, keys...)
+}
+
+// Current - ключ, которым подписываются новые токены.
+func (ring *KeyRing) Current() *Key {
+	return ring.current
+}
+
+// Lookup - ключ по kid. Алгоритм токена должен совпадать с алгоритмом ключа,
+// иначе открытый ключ RSA можно было бы выдать за секрет HMAC.
+func (ring *KeyRing) Lookup(kid, alg string) (*Key, error) {
+
+	k, ok := ring.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+	if k.Method.Alg() != alg {
+		return nil, fmt.Errorf("ключ %s не для алгоритма %s", kid, alg)
+	}
+	return k, nil
+}
+
+// JWKS - открытые ключи набора, отсортированные по kid.
+func (ring *KeyRing) JWKS() JWKSet {
+
+	set := JWKSet{Keys: make([]JWK, 0, len(ring.keys))}
+	for _, k := range ring.keys {
+		set.Keys = append(set.Keys, k.JWK())
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+
+	return set
+}
+
+// JWK - открытая часть ключа.
+func (k *Key) JWK() JWK {
+
+	jwk := JWK{Use: "sig", Alg: k.Method.Alg(), Kid: k.ID}
+	switch pub := k.Public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+	return jwk
+}
@@ -0,0 +1,155 @@
+package jwtpackage
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRSAKey(t *testing.T, kid string) *Key {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	k, err := NewKey(kid, private)
+	require.NoError(t, err)
+	return k
+}
+
+func newEd25519Key(t *testing.T, kid string) *Key {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	k, err := NewKey(kid, private)
+	require.NoError(t, err)
+	return k
+}
+
+func TestKeyRingRotation(t *testing.T) {
+
+	oldKey := newRSAKey(t, "2026-01")
+	newKey := newEd25519Key(t, "2026-02")
+
+	ring, err := NewKeyRing("2026-01", oldKey, newKey)
+	require.NoError(t, err)
+	tok := NewTokenWithKeys(time.Minute, ring)
+
+	oldToken, err := tok.BuildSessionJWT("Jhon", "s1")
+	require.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(oldToken, &Claims{})
+	require.NoError(t, err)
+	assert.Equal(t, "RS256", parsed.Method.Alg())
+	assert.Equal(t, "2026-01", parsed.Header["kid"])
+
+	// новый ключ стал текущим, старый остался для проверки
+	ring, err = NewKeyRing("2026-02", oldKey, newKey)
+	require.NoError(t, err)
+	tok = NewTokenWithKeys(time.Minute, ring)
+
+	newToken, err := tok.BuildSessionJWT("Jhon", "s1")
+	require.NoError(t, err)
+	parsed, _, err = jwt.NewParser().ParseUnverified(newToken, &Claims{})
+	require.NoError(t, err)
+	assert.Equal(t, "EdDSA", parsed.Method.Alg())
+
+	for _, s := range []string{oldToken, newToken} {
+		claims, err := tok.ParseClaims(s)
+		require.NoError(t, err)
+		assert.Equal(t, "Jhon", claims.UserID)
+		assert.Equal(t, "s1", claims.SessionID)
+	}
+
+	// старый ключ удален
+	ring, err = NewKeyRing("2026-02", newKey)
+	require.NoError(t, err)
+	tok = NewTokenWithKeys(time.Minute, ring)
+	_, err = tok.ParseClaims(oldToken)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	// токены общего секрета не принимаются
+	hmacToken, err := NewToken(time.Minute, "verySecretKey").BuildJWTString("Jhon")
+	require.NoError(t, err)
+	_, err = tok.ParseClaims(hmacToken)
+	assert.Error(t, err)
+
+	// только открытым ключом подписывать нельзя
+	public, err := NewKey("2026-03", newKey.Public)
+	require.NoError(t, err)
+	_, err = NewKeyRing("2026-03", newKey, public)
+	assert.ErrorIs(t, err, ErrNoSigningKey)
+}
+
+func TestKeyRingAlgorithmConfusion(t *testing.T) {
+
+	key := newRSAKey(t, "rsa")
+	ring, err := NewKeyRing("rsa", key)
+	require.NoError(t, err)
+	tok := NewTokenWithKeys(time.Minute, ring)
+
+	// HS256, подписанный открытым ключом как секретом, с kid ключа RSA
+	der, err := x509.MarshalPKIXPublicKey(key.Public)
+	require.NoError(t, err)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{UserID: "admin"})
+	forged.Header["kid"] = "rsa"
+	s, err := forged.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	require.NoError(t, err)
+
+	_, err = tok.ParseClaims(s)
+	assert.Error(t, err)
+}
+
+func TestLoadKeyRing(t *testing.T) {
+
+	dir := t.TempDir()
+	writePEM := func(name, blockType string, der []byte) {
+		data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0o600))
+	}
+
+	oldKey := newRSAKey(t, "2026-01")
+	der, err := x509.MarshalPKIXPublicKey(oldKey.Public)
+	require.NoError(t, err)
+	writePEM("2026-01.pem", "PUBLIC KEY", der)
+
+	rsaKey := newRSAKey(t, "2026-02")
+	writePEM("2026-02.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey.Private.(*rsa.PrivateKey)))
+
+	edKey := newEd25519Key(t, "2026-03")
+	der, err = x509.MarshalPKCS8PrivateKey(edKey.Private)
+	require.NoError(t, err)
+	writePEM("2026-03.pem", "PRIVATE KEY", der)
+
+	// по умолчанию текущий - закрытый ключ с наибольшим kid
+	ring, err := LoadKeyRing(dir, "")
+	require.NoError(t, err)
+	assert.Equal(t, "2026-03", ring.Current().ID)
+
+	ring, err = LoadKeyRing(dir, "2026-02")
+	require.NoError(t, err)
+	assert.Equal(t, "2026-02", ring.Current().ID)
+
+	_, err = LoadKeyRing(dir, "2026-01")
+	assert.ErrorIs(t, err, ErrNoSigningKey)
+	_, err = LoadKeyRing(dir, "2025-12")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	_, err = LoadKeyRing(t.TempDir(), "")
+	assert.ErrorIs(t, err, ErrNoSigningKey)
+
+	jwks := NewTokenWithKeys(time.Minute, ring).JWKS()
+	require.Len(t, jwks.Keys, 3)
+	assert.Equal(t, JWK{Kty: "RSA", Use: "sig", Alg: "RS256", Kid: "2026-01",
+		N: base64.RawURLEncoding.EncodeToString(oldKey.Public.(*rsa.PublicKey).N.Bytes()), E: "AQAB"}, jwks.Keys[0])
+	assert.Equal(t, "2026-02", jwks.Keys[1].Kid)
+	assert.Equal(t, JWK{Kty: "OKP", Use: "sig", Alg: "EdDSA", Kid: "2026-03", Crv: "Ed25519",
+		X: base64.RawURLEncoding.EncodeToString(edKey.Public.(ed25519.PublicKey))}, jwks.Keys[2])
+
+	assert.Empty(t, NewToken(time.Minute, "verySecretKey").JWKS().Keys)
+}